@@ -0,0 +1,203 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+/*
+Package aoi provides pure Go areas of interest for github.com/xeonx/raster, without dependency on the GEOS C library.
+
+An area of interest is a multipolygon in longitude/latitude degrees (holes supported), read from WKT or GeoJSON.
+It provides the bounding box and the Filter used to restrict a copy to the tiles intersecting the area.
+*/
+package aoi
+
+import (
+	"io/ioutil"
+	"math"
+	"strings"
+
+	"github.com/xeonx/geographic"
+	"github.com/xeonx/raster"
+)
+
+//Point is a longitude (X) and latitude (Y) in degrees
+type Point struct {
+	X, Y float64
+}
+
+//Ring is a closed line string. The last point may or may not repeat the first one.
+type Ring []Point
+
+//Polygon is a list of rings: the first one is the exterior ring, the other ones are holes.
+type Polygon []Ring
+
+//MultiPolygon is a set of polygons.
+//The polygons may overlap: a MultiPolygon is the union of its polygons.
+type MultiPolygon []Polygon
+
+//Rect is an axis aligned rectangle
+type Rect struct {
+	Min, Max Point
+}
+
+//TileRect returns the rectangle in longitude/latitude degrees covered by a tile
+func TileRect(level, x, y int) Rect {
+	return Rect{
+		Min: Point{X: raster.X2Lon(level, x), Y: raster.Y2Lat(level, y)},
+		Max: Point{X: raster.X2Lon(level, x+1), Y: raster.Y2Lat(level, y+1)},
+	}
+}
+
+//BoundingBox computes the bounding box of the multipolygon
+func (m MultiPolygon) BoundingBox() geographic.BoundingBox {
+	bbox := geographic.BoundingBox{
+		LongitudeMinDeg: math.Inf(1),
+		LatitudeMinDeg:  math.Inf(1),
+		LongitudeMaxDeg: math.Inf(-1),
+		LatitudeMaxDeg:  math.Inf(-1),
+	}
+	for _, p := range m {
+		if len(p) == 0 {
+			continue
+		}
+		//Holes are inside the exterior ring
+		for _, pt := range p[0] {
+			bbox.LongitudeMinDeg = math.Min(bbox.LongitudeMinDeg, pt.X)
+			bbox.LongitudeMaxDeg = math.Max(bbox.LongitudeMaxDeg, pt.X)
+			bbox.LatitudeMinDeg = math.Min(bbox.LatitudeMinDeg, pt.Y)
+			bbox.LatitudeMaxDeg = math.Max(bbox.LatitudeMaxDeg, pt.Y)
+		}
+	}
+	return bbox
+}
+
+//edges calls fct for each segment of the ring, until fct returns true
+func (r Ring) edges(fct func(a, b Point) bool) bool {
+	for i := range r {
+		j := i + 1
+		if j == len(r) {
+			j = 0
+		}
+		if fct(r[i], r[j]) {
+			return true
+		}
+	}
+	return false
+}
+
+//ContainsPoint returns true if the point is inside the polygon, using the even-odd rule over all rings.
+func (p Polygon) ContainsPoint(pt Point) bool {
+	inside := false
+	for _, r := range p {
+		r.edges(func(a, b Point) bool {
+			if (a.Y > pt.Y) != (b.Y > pt.Y) && pt.X < (b.X-a.X)*(pt.Y-a.Y)/(b.Y-a.Y)+a.X {
+				inside = !inside
+			}
+			return false
+		})
+	}
+	return inside
+}
+
+//ContainsPoint returns true if the point is inside one of the polygons
+func (m MultiPolygon) ContainsPoint(pt Point) bool {
+	for _, p := range m {
+		if p.ContainsPoint(pt) {
+			return true
+		}
+	}
+	return false
+}
+
+//segmentIntersectsRect returns true if the segment [a, b] intersects the rectangle, using Liang-Barsky clipping.
+func segmentIntersectsRect(a, b Point, r Rect) bool {
+	t0, t1 := 0.0, 1.0
+	dx, dy := b.X-a.X, b.Y-a.Y
+	for _, c := range [4][2]float64{
+		{-dx, a.X - r.Min.X},
+		{dx, r.Max.X - a.X},
+		{-dy, a.Y - r.Min.Y},
+		{dy, r.Max.Y - a.Y},
+	} {
+		p, q := c[0], c[1]
+		if p == 0 {
+			if q < 0 {
+				return false
+			}
+			continue
+		}
+		t := q / p
+		if p < 0 {
+			if t > t1 {
+				return false
+			}
+			if t > t0 {
+				t0 = t
+			}
+		} else {
+			if t < t0 {
+				return false
+			}
+			if t < t1 {
+				t1 = t
+			}
+		}
+	}
+	return true
+}
+
+//boundaryIntersectsRect returns true if a ring of the multipolygon crosses or touches the rectangle
+func (m MultiPolygon) boundaryIntersectsRect(r Rect) bool {
+	for _, p := range m {
+		for _, ring := range p {
+			if ring.edges(func(a, b Point) bool { return segmentIntersectsRect(a, b, r) }) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//IntersectsRect returns true if the rectangle and the multipolygon have at least one point in common.
+func (m MultiPolygon) IntersectsRect(r Rect) bool {
+	if m.boundaryIntersectsRect(r) {
+		return true
+	}
+	//The rectangle is either fully inside or fully outside
+	return m.ContainsPoint(r.Min)
+}
+
+//ContainsRect returns true if the rectangle is fully inside the multipolygon, without touching its boundary.
+func (m MultiPolygon) ContainsRect(r Rect) bool {
+	if m.boundaryIntersectsRect(r) {
+		return false
+	}
+	return m.ContainsPoint(r.Min)
+}
+
+//GetBoundingBox computes the bounding box for a given area of interest
+func GetBoundingBox(m MultiPolygon) geographic.BoundingBox {
+	return m.BoundingBox()
+}
+
+//IntersectsFilter creates a Filter allowing to skip tiles outside of a given area of interest.
+//It relies on a Coverage: use NewCoverage directly to also iterate only on the intersecting tiles.
+func IntersectsFilter(m MultiPolygon) raster.Filter {
+	return NewCoverage(m).Filter()
+}
+
+//Parse parses an area of interest given either as GeoJSON or in WKT.
+func Parse(s string) (MultiPolygon, error) {
+	if strings.HasPrefix(strings.TrimSpace(s), "{") {
+		return ParseGeoJSON([]byte(s))
+	}
+	return ParseWKT(s)
+}
+
+//ReadFile reads an area of interest from a GeoJSON or WKT file.
+func ReadFile(filename string) (MultiPolygon, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(string(b))
+}
@@ -0,0 +1,169 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package aoi
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+//square with a hole, in WKT and GeoJSON
+const squareWKT = "POLYGON Z ((0 0 1, 40 0 1, 40 40 1, 0 40 1, 0 0 1), (10 10 1, 30 10 1, 30 30 1, 10 30 1, 10 10 1))"
+const squareGeoJSON = `{"type": "Polygon", "coordinates": [[[0, 0], [40, 0], [40, 40], [0, 40], [0, 0]], [[10, 10], [30, 10], [30, 30], [10, 30], [10, 10]]]}`
+
+func TestParse(t *testing.T) {
+	for _, s := range []string{squareWKT, squareGeoJSON} {
+		m, err := Parse(s)
+		if err != nil {
+			t.Fatalf("Parse(%s) failed: %s", s, err)
+		}
+		if len(m) != 1 || len(m[0]) != 2 || len(m[0][0]) != 5 || m[0][1][2] != (Point{30, 30}) {
+			t.Errorf("Parse(%s): unexpected polygon %v", s, m)
+		}
+		bbox := m.BoundingBox()
+		if bbox.LongitudeMinDeg != 0 || bbox.LatitudeMinDeg != 0 || bbox.LongitudeMaxDeg != 40 || bbox.LatitudeMaxDeg != 40 {
+			t.Errorf("Parse(%s): unexpected bounding box %v", s, bbox)
+		}
+	}
+
+	m, err := ParseWKT("multipolygon(((0 0,1 0,1 1,0 0)),((2 2,3 2,3 3,2 2)))")
+	if err != nil || len(m) != 2 {
+		t.Errorf("ParseWKT(multipolygon) = %v, %v", m, err)
+	}
+	m, err = ParseWKT("MULTIPOLYGON EMPTY")
+	if err != nil || len(m) != 0 {
+		t.Errorf("ParseWKT(empty) = %v, %v", m, err)
+	}
+
+	for _, s := range []string{"POINT(1 2)", "POLYGON((0 0, 1 0, 1 1)", "POLYGON((0 0, 1 a, 1 1))", "POLYGON((0 0, 1 1)) x", `{"type": "LineString"}`} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%s) should fail", s)
+		}
+	}
+}
+
+func TestIntersectsRect(t *testing.T) {
+	m, err := ParseWKT(squareWKT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		r          Rect
+		intersects bool
+		contains   bool
+	}{
+		{Rect{Point{1, 1}, Point{5, 5}}, true, true},           //inside
+		{Rect{Point{-10, -10}, Point{50, 50}}, true, false},    //around
+		{Rect{Point{-5, 5}, Point{5, 8}}, true, false},         //crossing the exterior ring
+		{Rect{Point{40, 40}, Point{45, 45}}, true, false},      //touching a corner
+		{Rect{Point{41, 0}, Point{45, 45}}, false, false},      //outside
+		{Rect{Point{15, 15}, Point{25, 25}}, false, false},     //inside the hole
+		{Rect{Point{5, 15}, Point{15, 25}}, true, false},       //crossing the hole
+		{Rect{Point{-50, -50}, Point{-45, -45}}, false, false}, //far away
+	}
+
+	for _, tc := range testCases {
+		if got := m.IntersectsRect(tc.r); got != tc.intersects {
+			t.Errorf("IntersectsRect(%v) = %v, expected %v", tc.r, got, tc.intersects)
+		}
+		if got := m.ContainsRect(tc.r); got != tc.contains {
+			t.Errorf("ContainsRect(%v) = %v, expected %v", tc.r, got, tc.contains)
+		}
+	}
+}
+
+func TestIntersectsFilter(t *testing.T) {
+	m, err := ParseWKT("POLYGON((10 10, 40 10, 40 40, 10 40, 10 10))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := IntersectsFilter(m)
+
+	//At level 1, only the north-east tile (x=1, y=1 in TMS) intersects
+	for x := 0; x < 2; x++ {
+		for y := 0; y < 2; y++ {
+			excluded, err := filter(1, x, y)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if excluded != (x != 1 || y != 1) {
+				t.Errorf("filter(1, %d, %d) = %v", x, y, excluded)
+			}
+		}
+	}
+}
+
+func TestParseCollections(t *testing.T) {
+	testCases := []struct {
+		s        string
+		polygons int
+	}{
+		{`{"type": "Feature", "geometry": ` + squareGeoJSON + `, "properties": {}}`, 1},
+		{`{"type": "Feature", "geometry": null, "properties": {}}`, 0},
+		{`{"type": "FeatureCollection", "features": [{"type": "Feature", "geometry": ` + squareGeoJSON + `}, {"type": "Feature", "geometry": {"type": "MultiPolygon", "coordinates": [[[[0, 0], [1, 0], [1, 1], [0, 0]]], [[[2, 2], [3, 2], [3, 3], [2, 2]]]]}}]}`, 3},
+		{`{"type": "GeometryCollection", "geometries": [` + squareGeoJSON + `, ` + squareGeoJSON + `]}`, 2},
+		{squareWKT + "\n" + squareWKT + "\n", 2},
+		{"GEOMETRYCOLLECTION(POLYGON((0 0, 1 0, 1 1, 0 0)), MULTIPOLYGON(((0 0, 1 0, 1 1, 0 0))));", 2},
+	}
+
+	for _, tc := range testCases {
+		m, err := Parse(tc.s)
+		if err != nil {
+			t.Errorf("Parse(%s) failed: %s", tc.s, err)
+			continue
+		}
+		if len(m) != tc.polygons {
+			t.Errorf("Parse(%s): %d polygons, expected %d", tc.s, len(m), tc.polygons)
+		}
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "aoi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(squareWKT)
+	f.Close()
+
+	m, err := ReadFile(f.Name())
+	if err != nil || len(m) != 1 {
+		t.Errorf("ReadFile() = %v, %v", m, err)
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	m, err := ParseWKT("POLYGON((2 48, 3 48, 3 49, 2 49, 2 48), (2.4 48.4, 2.6 48.4, 2.6 48.6, 2.4 48.6, 2.4 48.4))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := Buffer(m, 1000)
+
+	//1 degree of latitude is about 111km, 1 degree of longitude is about 74km at 48 degrees.
+	testCases := []struct {
+		pt     Point
+		inside bool
+	}{
+		{Point{2.2, 48.2}, true},
+		{Point{2, 47.995}, true},   //555m south
+		{Point{2, 47.98}, false},   //2.2km south
+		{Point{3.01, 48.5}, true},  //740m east
+		{Point{3.02, 48.5}, false}, //1.5km east
+		{Point{2.5, 48.5}, false},  //in the hole
+		{Point{2.5, 48.405}, true}, //in the hole, 555m from the edge
+	}
+	for _, tc := range testCases {
+		if got := b.ContainsPoint(tc.pt); got != tc.inside {
+			t.Errorf("Buffer: ContainsPoint(%v) = %v, expected %v", tc.pt, got, tc.inside)
+		}
+	}
+
+	if len(Buffer(m, 0)) != 1 {
+		t.Error("Buffer(0) should not change the area")
+	}
+}
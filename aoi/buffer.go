@@ -0,0 +1,62 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package aoi
+
+import (
+	"math"
+
+	"github.com/xeonx/raster"
+)
+
+//capSegments is the number of segments used to approximate the half circles of a buffer
+const capSegments = 8
+
+//Buffer extends the area by a distance in meters. A distance lower or equal to 0 returns the area unchanged.
+//
+//Each edge of the area is surrounded by a capsule (a rectangle with half circles at both ends) of the given radius,
+//and the result is the union of the area with these capsules. Capsules are computed in web mercator,
+//with the scale factor at the latitude of the edge, so the distance is approximate for long edges.
+func Buffer(m MultiPolygon, meters float64) MultiPolygon {
+	if meters <= 0 {
+		return m
+	}
+
+	result := append(MultiPolygon{}, m...)
+	for _, p := range m {
+		for _, r := range p {
+			r.edges(func(a, b Point) bool {
+				result = append(result, Polygon{capsule(a, b, meters)})
+				return false
+			})
+		}
+	}
+	return result
+}
+
+//capsule computes the ring of the points at less than meters from the segment [a, b]
+func capsule(a, b Point, meters float64) Ring {
+	//Web mercator scale factor at the middle of the segment
+	lat := (a.Y + b.Y) / 2 * math.Pi / 180
+	radius := meters / math.Cos(lat)
+
+	ax, ay := raster.Lon2Meters(a.X), raster.Lat2Meters(a.Y)
+	bx, by := raster.Lon2Meters(b.X), raster.Lat2Meters(b.Y)
+	theta := math.Atan2(by-ay, bx-ax)
+
+	r := make(Ring, 0, 2*capSegments+2)
+	for _, c := range []struct{ x, y, start float64 }{
+		{bx, by, theta - math.Pi/2},
+		{ax, ay, theta + math.Pi/2},
+	} {
+		for i := 0; i <= capSegments; i++ {
+			angle := c.start + math.Pi*float64(i)/capSegments
+			r = append(r, Point{
+				X: raster.Meters2Lon(c.x + radius*math.Cos(angle)),
+				Y: raster.Meters2Lat(c.y + radius*math.Sin(angle)),
+			})
+		}
+	}
+	return r
+}
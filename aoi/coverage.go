@@ -0,0 +1,145 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package aoi
+
+import (
+	"sync"
+
+	"github.com/xeonx/raster"
+)
+
+//maxCacheSize is the maximum number of relations kept by a Coverage. The cache is cleared when it is full:
+//the relations are then computed again from the coarsest levels.
+var maxCacheSize = 1 << 20
+
+//tileKey identifies a tile
+type tileKey struct {
+	level, x, y int
+}
+
+//Coverage relates the tiles of the pyramid to an area of interest, using the pyramid as a quadtree:
+//when a tile is fully inside or fully outside the area, all its descendants are decided without further geometry tests.
+//
+//A Coverage is safe for use by multiple goroutines.
+type Coverage struct {
+	prepared *Prepared
+	exclude  *Prepared //Optional area removed from the coverage
+
+	mu    sync.Mutex
+	cache map[tileKey]Relation //Relations of the tiles crossed by the boundary and of their children
+}
+
+//NewCoverage creates the Coverage of an area of interest
+func NewCoverage(m MultiPolygon) *Coverage {
+	return &Coverage{
+		prepared: Prepare(m),
+		cache:    make(map[tileKey]Relation),
+	}
+}
+
+//NewCoverageExcluding creates the Coverage of an area of interest, minus an area to exclude
+func NewCoverageExcluding(include, exclude MultiPolygon) *Coverage {
+	c := NewCoverage(include)
+	if len(exclude) > 0 {
+		c.exclude = Prepare(exclude)
+	}
+	return c
+}
+
+//relate computes the position of a rectangle relative to the included area minus the excluded one
+func (c *Coverage) relate(r Rect) Relation {
+	rel := c.prepared.Relate(r)
+	if rel == Outside || c.exclude == nil {
+		return rel
+	}
+	switch c.exclude.Relate(r) {
+	case Inside:
+		return Outside
+	case Boundary:
+		return Boundary
+	}
+	return rel
+}
+
+//Relate computes the position of the tile relative to the area
+func (c *Coverage) Relate(level, x, y int) Relation {
+	key := tileKey{level, x, y}
+	c.mu.Lock()
+	rel, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok {
+		return rel
+	}
+
+	if level > 0 {
+		//A tile inherits the relation of a parent fully inside or outside
+		if parent := c.Relate(level-1, x>>1, y>>1); parent != Boundary {
+			return parent
+		}
+	}
+
+	rel = c.relate(TileRect(level, x, y))
+	c.mu.Lock()
+	if len(c.cache) >= maxCacheSize {
+		c.cache = make(map[tileKey]Relation)
+	}
+	c.cache[key] = rel
+	c.mu.Unlock()
+	return rel
+}
+
+//Intersects returns true if the tile has at least one point in common with the area
+func (c *Coverage) Intersects(level, x, y int) bool {
+	return c.Relate(level, x, y) != Outside
+}
+
+//Filter creates a Filter allowing to skip tiles outside of the area
+func (c *Coverage) Filter() raster.Filter {
+	return func(level, x, y int) (bool, error) {
+		return !c.Intersects(level, x, y), nil
+	}
+}
+
+//Blocks returns the tile blocks covering the area at a given level, so that only the tiles intersecting the area are iterated.
+//
+//Descendants of tiles fully inside the area are returned as a single block. Tiles crossed by the boundary
+//are returned as blocks of blockSize x blockSize tiles aligned on multiples of blockSize (e.g. to match metatiles),
+//so these blocks may contain some tiles outside the area: use Filter to skip them. blockSize must be a power of 2.
+//
+//A Coverage is a raster.Area: a raster.Copier with the Coverage as Area only iterates these blocks.
+func (c *Coverage) Blocks(level int, blockSize int) []raster.TileBlock {
+	var shift uint
+	for 1<<(shift+1) <= blockSize && int(shift) < level {
+		shift++
+	}
+
+	var blocks []raster.TileBlock
+	var visit func(l, x, y int)
+	visit = func(l, x, y int) {
+		rel := c.Relate(l, x, y)
+		if rel == Outside {
+			return
+		}
+		if rel == Inside || l == level-int(shift) {
+			d := uint(level - l)
+			blocks = append(blocks, raster.TileBlock{
+				Level: level,
+				Xmin:  x << d,
+				Xmax:  (x+1)<<d - 1,
+				Ymin:  y << d,
+				Ymax:  (y+1)<<d - 1,
+			})
+			return
+		}
+		for dx := 0; dx < 2; dx++ {
+			for dy := 0; dy < 2; dy++ {
+				visit(l+1, 2*x+dx, 2*y+dy)
+			}
+		}
+	}
+	visit(0, 0, 0)
+
+	return blocks
+}
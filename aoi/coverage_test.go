@@ -0,0 +1,161 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package aoi
+
+import (
+	"math"
+	"testing"
+)
+
+//testArea is a multipolygon with a hole, an isolated island and polygons sharing an edge
+const testArea = `MULTIPOLYGON(
+	((2 40, 12 40, 12 52, 2 52, 2 40), (5 44, 9 44, 9 48, 5 48, 5 44)),
+	((-60 -20, -50 -20, -55 -10, -60 -20)),
+	((12 40, 20 40, 20 45, 12 45, 12 40)))`
+
+func TestCoverage(t *testing.T) {
+	m, err := ParseWKT(testArea)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCoverage(m)
+
+	const level = 7
+	for l := 0; l <= level; l++ {
+		for x := 0; x < 1<<uint(l); x++ {
+			for y := 0; y < 1<<uint(l); y++ {
+				r := TileRect(l, x, y)
+
+				expected := Outside
+				if m.ContainsRect(r) {
+					expected = Inside
+				} else if m.IntersectsRect(r) {
+					expected = Boundary
+				}
+				//Inside tiles may be reported as Boundary when crossed by an edge shared by two polygons
+				if got := c.Relate(l, x, y); got != expected && !(got == Boundary && expected == Inside) {
+					t.Fatalf("Relate(%d, %d, %d) = %v, expected %v", l, x, y, got, expected)
+				}
+			}
+		}
+	}
+
+	//Blocks of single tiles cover exactly the intersecting tiles
+	for _, blockSize := range []int{1, 4} {
+		covered := make(map[[2]int]int)
+		for _, b := range c.Blocks(level, blockSize) {
+			if b.Level != level {
+				t.Fatalf("Blocks(%d, %d): unexpected block %v", level, blockSize, b)
+			}
+			if b.Count() < blockSize*blockSize || b.Xmin%blockSize != 0 || b.Ymin%blockSize != 0 {
+				t.Errorf("Blocks(%d, %d): unaligned block %v", level, blockSize, b)
+			}
+			for x := b.Xmin; x <= b.Xmax; x++ {
+				for y := b.Ymin; y <= b.Ymax; y++ {
+					covered[[2]int{x, y}]++
+				}
+			}
+		}
+
+		for x := 0; x < 1<<level; x++ {
+			for y := 0; y < 1<<level; y++ {
+				intersects := m.IntersectsRect(TileRect(level, x, y))
+				count := covered[[2]int{x, y}]
+				if count > 1 || (intersects && count == 0) || (blockSize == 1 && !intersects && count > 0) {
+					t.Fatalf("Blocks(%d, %d): tile %d/%d covered %d times (intersects: %v)", level, blockSize, x, y, count, intersects)
+				}
+			}
+		}
+	}
+}
+
+//circle returns a polygon approximating a circle with n vertices
+func circle(lon, lat, radius float64, n int) MultiPolygon {
+	r := make(Ring, n)
+	for i := range r {
+		a := 2 * math.Pi * float64(i) / float64(n)
+		r[i] = Point{X: lon + radius*math.Cos(a), Y: lat + radius*math.Sin(a)}
+	}
+	return MultiPolygon{Polygon{r}}
+}
+
+func BenchmarkIntersectsRect(b *testing.B) {
+	m := circle(2.35, 48.85, 0.5, 5000)
+	for i := 0; i < b.N; i++ {
+		m.IntersectsRect(TileRect(14, 8250+i%100, 10600+i%100))
+	}
+}
+
+func BenchmarkCoverage(b *testing.B) {
+	c := NewCoverage(circle(2.35, 48.85, 0.5, 5000))
+	for i := 0; i < b.N; i++ {
+		c.Relate(14, 8250+i%100, 10600+i%100)
+	}
+}
+
+func TestCoverageExcluding(t *testing.T) {
+	include, err := ParseWKT("POLYGON((0 0, 40 0, 40 40, 0 40, 0 0))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exclude, err := ParseWKT("POLYGON((10 10, 30 10, 30 30, 10 30, 10 10))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCoverageExcluding(include, exclude)
+
+	testCases := []struct {
+		level, x, y int
+		expected    Relation
+	}{
+		{0, 0, 0, Boundary},
+		{7, 65, 66, Inside},   //between the included and the excluded areas
+		{7, 71, 71, Outside},  //in the excluded area
+		{7, 67, 71, Boundary}, //crossed by the excluded area boundary
+		{7, 10, 10, Outside},  //outside of the included area
+	}
+	for _, tc := range testCases {
+		r := TileRect(tc.level, tc.x, tc.y)
+		if got := c.Relate(tc.level, tc.x, tc.y); got != tc.expected {
+			t.Errorf("Relate(%d, %d, %d) = %v (%v), expected %v", tc.level, tc.x, tc.y, got, r, tc.expected)
+		}
+	}
+}
+
+func TestCoverageCacheSize(t *testing.T) {
+	m, err := ParseWKT(testArea)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(size int) { maxCacheSize = size }(maxCacheSize)
+	maxCacheSize = 16
+
+	expected, c := NewCoverage(m), NewCoverage(m)
+	const level = 7
+	for x := 0; x < 1<<level; x++ {
+		for y := 0; y < 1<<level; y++ {
+			if got, want := c.Relate(level, x, y), expected.Relate(level, x, y); got != want {
+				t.Fatalf("Relate(%d, %d, %d) = %v with a small cache, expected %v", level, x, y, got, want)
+			}
+			if len(c.cache) > maxCacheSize {
+				t.Fatalf("%d relations cached, want at most %d", len(c.cache), maxCacheSize)
+			}
+		}
+	}
+}
+
+func TestContainsPointAllocs(t *testing.T) {
+	m, err := ParseWKT(testArea)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Prepare(m)
+	if !p.ContainsPoint(Point{X: 15, Y: 42}) || p.ContainsPoint(Point{X: 7, Y: 46}) {
+		t.Error("ContainsPoint() => wrong result in the second polygon or in the hole")
+	}
+	if allocs := testing.AllocsPerRun(100, func() { p.ContainsPoint(Point{X: 3, Y: 41}) }); allocs != 0 {
+		t.Errorf("ContainsPoint() => %.0f allocations, want none", allocs)
+	}
+}
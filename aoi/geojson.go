@@ -0,0 +1,104 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package aoi
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+//geoJSONObject is the subset of a GeoJSON object (geometry, feature or feature collection) used to read polygons
+type geoJSONObject struct {
+	Type        string            `json:"type"`
+	Coordinates json.RawMessage   `json:"coordinates"`
+	Geometries  []json.RawMessage `json:"geometries"`
+	Geometry    json.RawMessage   `json:"geometry"`
+	Features    []json.RawMessage `json:"features"`
+}
+
+//toRing converts GeoJSON positions into a Ring
+func toRing(positions [][]float64) (Ring, error) {
+	r := make(Ring, 0, len(positions))
+	for _, pos := range positions {
+		if len(pos) < 2 {
+			return nil, errors.New("Invalid GeoJSON: position with less than 2 values")
+		}
+		r = append(r, Point{X: pos[0], Y: pos[1]})
+	}
+	return r, nil
+}
+
+//toPolygon converts GeoJSON polygon coordinates into a Polygon
+func toPolygon(rings [][][]float64) (Polygon, error) {
+	p := make(Polygon, 0, len(rings))
+	for _, positions := range rings {
+		r, err := toRing(positions)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, r)
+	}
+	return p, nil
+}
+
+//ParseGeoJSON parses a GeoJSON object: a Polygon, MultiPolygon or GeometryCollection of them,
+//a Feature or a FeatureCollection. The result is the union of all the polygons. Features without geometry are ignored.
+func ParseGeoJSON(b []byte) (MultiPolygon, error) {
+	var g geoJSONObject
+	if err := json.Unmarshal(b, &g); err != nil {
+		return nil, err
+	}
+
+	switch g.Type {
+	case "Polygon":
+		var coordinates [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &coordinates); err != nil {
+			return nil, err
+		}
+		p, err := toPolygon(coordinates)
+		if err != nil {
+			return nil, err
+		}
+		return MultiPolygon{p}, nil
+	case "MultiPolygon":
+		var coordinates [][][][]float64
+		if err := json.Unmarshal(g.Coordinates, &coordinates); err != nil {
+			return nil, err
+		}
+		var m MultiPolygon
+		for _, c := range coordinates {
+			p, err := toPolygon(c)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, p)
+		}
+		return m, nil
+	case "GeometryCollection":
+		return parseGeoJSONList(g.Geometries)
+	case "Feature":
+		if len(g.Geometry) == 0 || string(g.Geometry) == "null" {
+			return nil, nil
+		}
+		return ParseGeoJSON(g.Geometry)
+	case "FeatureCollection":
+		return parseGeoJSONList(g.Features)
+	}
+
+	return nil, errors.New("Invalid GeoJSON: only Polygon and MultiPolygon geometries are supported, found '" + g.Type + "'")
+}
+
+//parseGeoJSONList parses a list of GeoJSON objects and returns the union of their polygons
+func parseGeoJSONList(objects []json.RawMessage) (MultiPolygon, error) {
+	var m MultiPolygon
+	for _, o := range objects {
+		g, err := ParseGeoJSON(o)
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, g...)
+	}
+	return m, nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package aoi
+
+import (
+	"math"
+)
+
+//Relation is the position of a rectangle relative to an area of interest
+type Relation int
+
+const (
+	//Outside means that the rectangle and the area have no point in common
+	Outside Relation = iota
+	//Boundary means that the boundary of the area crosses or touches the rectangle
+	Boundary
+	//Inside means that the rectangle is fully inside the area
+	Inside
+)
+
+//maxGridSize is the maximum number of rows and columns of the edge index of a Prepared
+const maxGridSize = 256
+
+//edge is a segment of a ring, with the index of its polygon
+type edge struct {
+	a, b    Point
+	polygon int
+}
+
+//Prepared is a MultiPolygon indexed for fast repeated rectangle tests.
+//The edges are indexed in a regular grid covering the bounding box of the area.
+type Prepared struct {
+	bounds Rect
+	edges  []edge
+
+	gridSize int
+	cellW    float64
+	cellH    float64
+	cells    [][]int //edges overlapping each cell, row by row
+	rows     [][]int //edges overlapping each row
+}
+
+//Prepare indexes the multipolygon for fast repeated rectangle tests
+func Prepare(m MultiPolygon) *Prepared {
+	bbox := m.BoundingBox()
+	p := &Prepared{
+		bounds: Rect{
+			Min: Point{X: bbox.LongitudeMinDeg, Y: bbox.LatitudeMinDeg},
+			Max: Point{X: bbox.LongitudeMaxDeg, Y: bbox.LatitudeMaxDeg},
+		},
+	}
+
+	for i, poly := range m {
+		for _, r := range poly {
+			r.edges(func(a, b Point) bool {
+				p.edges = append(p.edges, edge{a: a, b: b, polygon: i})
+				return false
+			})
+		}
+	}
+	if len(p.edges) == 0 {
+		return p
+	}
+
+	p.gridSize = int(math.Ceil(math.Sqrt(float64(len(p.edges)) / 2)))
+	if p.gridSize > maxGridSize {
+		p.gridSize = maxGridSize
+	}
+	p.cellW = (p.bounds.Max.X - p.bounds.Min.X) / float64(p.gridSize)
+	p.cellH = (p.bounds.Max.Y - p.bounds.Min.Y) / float64(p.gridSize)
+	p.cells = make([][]int, p.gridSize*p.gridSize)
+	p.rows = make([][]int, p.gridSize)
+
+	for i, e := range p.edges {
+		c0, r0 := p.cell(Point{X: math.Min(e.a.X, e.b.X), Y: math.Min(e.a.Y, e.b.Y)})
+		c1, r1 := p.cell(Point{X: math.Max(e.a.X, e.b.X), Y: math.Max(e.a.Y, e.b.Y)})
+		for row := r0; row <= r1; row++ {
+			p.rows[row] = append(p.rows[row], i)
+			for col := c0; col <= c1; col++ {
+				p.cells[row*p.gridSize+col] = append(p.cells[row*p.gridSize+col], i)
+			}
+		}
+	}
+	return p
+}
+
+//index returns the grid index of a coordinate, clamped to the grid
+func index(v, min, size float64, n int) int {
+	if size <= 0 {
+		return 0
+	}
+	i := int((v - min) / size)
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+//cell returns the column and row of the grid cell containing pt
+func (p *Prepared) cell(pt Point) (int, int) {
+	return index(pt.X, p.bounds.Min.X, p.cellW, p.gridSize), index(pt.Y, p.bounds.Min.Y, p.cellH, p.gridSize)
+}
+
+//overlaps returns true if two rectangles have at least one point in common
+func (r Rect) overlaps(o Rect) bool {
+	return r.Min.X <= o.Max.X && o.Min.X <= r.Max.X && r.Min.Y <= o.Max.Y && o.Min.Y <= r.Max.Y
+}
+
+//ContainsPoint returns true if the point is inside one of the polygons
+func (p *Prepared) ContainsPoint(pt Point) bool {
+	if len(p.edges) == 0 || !p.bounds.overlaps(Rect{Min: pt, Max: pt}) {
+		return false
+	}
+
+	//Even-odd rule, per polygon. The edges of a row are sorted by index, so the ones of a polygon are consecutive.
+	polygon, inside := -1, false
+	_, row := p.cell(pt)
+	for _, i := range p.rows[row] {
+		e := p.edges[i]
+		if e.polygon != polygon {
+			if inside {
+				return true
+			}
+			polygon, inside = e.polygon, false
+		}
+		a, b := e.a, e.b
+		if (a.Y > pt.Y) != (b.Y > pt.Y) && pt.X < (b.X-a.X)*(pt.Y-a.Y)/(b.Y-a.Y)+a.X {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+//Relate computes the position of the rectangle relative to the area
+func (p *Prepared) Relate(r Rect) Relation {
+	if len(p.edges) == 0 || !p.bounds.overlaps(r) {
+		return Outside
+	}
+
+	c0, r0 := p.cell(r.Min)
+	c1, r1 := p.cell(r.Max)
+	for row := r0; row <= r1; row++ {
+		for col := c0; col <= c1; col++ {
+			for _, i := range p.cells[row*p.gridSize+col] {
+				if segmentIntersectsRect(p.edges[i].a, p.edges[i].b, r) {
+					return Boundary
+				}
+			}
+		}
+	}
+
+	//No boundary in the rectangle: it is either fully inside or fully outside
+	if p.ContainsPoint(r.Min) {
+		return Inside
+	}
+	return Outside
+}
@@ -0,0 +1,202 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package aoi
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//wktParser is a recursive descent parser for the polygonal WKT geometries
+type wktParser struct {
+	s   string
+	pos int
+}
+
+func (p *wktParser) skipSpaces() {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+//peek returns the next non space character, or 0 at the end of the input
+func (p *wktParser) peek() byte {
+	p.skipSpaces()
+	if p.pos >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *wktParser) expect(c byte) error {
+	if p.peek() != c {
+		return fmt.Errorf("Invalid WKT: '%c' expected at position %d", c, p.pos)
+	}
+	p.pos++
+	return nil
+}
+
+//word reads a keyword
+func (p *wktParser) word() string {
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.s) && unicode.IsLetter(rune(p.s[p.pos])) {
+		p.pos++
+	}
+	return strings.ToUpper(p.s[start:p.pos])
+}
+
+//number reads a floating point number
+func (p *wktParser) number() (float64, error) {
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.s) && strings.IndexByte("+-.0123456789eE", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+	v, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid WKT: number expected at position %d", start)
+	}
+	return v, nil
+}
+
+//list parses a parenthesized comma separated list, calling item for each element
+func (p *wktParser) list(item func() error) error {
+	if err := p.expect('('); err != nil {
+		return err
+	}
+	for {
+		if err := item(); err != nil {
+			return err
+		}
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+	return p.expect(')')
+}
+
+//ring parses '(x y, x y, ...)'. Z and M values are ignored.
+func (p *wktParser) ring() (Ring, error) {
+	var r Ring
+	err := p.list(func() error {
+		x, err := p.number()
+		if err != nil {
+			return err
+		}
+		y, err := p.number()
+		if err != nil {
+			return err
+		}
+		for c := p.peek(); c != ',' && c != ')' && c != 0; c = p.peek() {
+			if _, err := p.number(); err != nil {
+				return err
+			}
+		}
+		r = append(r, Point{X: x, Y: y})
+		return nil
+	})
+	return r, err
+}
+
+//polygon parses '((x y, ...), (x y, ...))'
+func (p *wktParser) polygon() (Polygon, error) {
+	var poly Polygon
+	err := p.list(func() error {
+		r, err := p.ring()
+		poly = append(poly, r)
+		return err
+	})
+	return poly, err
+}
+
+//dimension skips the optional Z, M or ZM qualifier and reports EMPTY geometries
+func (p *wktParser) dimension() bool {
+	save := p.pos
+	switch p.word() {
+	case "Z", "M", "ZM":
+		save = p.pos
+		if p.word() == "EMPTY" {
+			return true
+		}
+	case "EMPTY":
+		return true
+	}
+	p.pos = save
+	return false
+}
+
+//geometry parses a POLYGON, MULTIPOLYGON or a GEOMETRYCOLLECTION of them
+func (p *wktParser) geometry() (MultiPolygon, error) {
+	var m MultiPolygon
+	switch p.word() {
+	case "POLYGON":
+		if p.dimension() {
+			break
+		}
+		poly, err := p.polygon()
+		if err != nil {
+			return nil, err
+		}
+		m = MultiPolygon{poly}
+	case "MULTIPOLYGON":
+		if p.dimension() {
+			break
+		}
+		err := p.list(func() error {
+			poly, err := p.polygon()
+			m = append(m, poly)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	case "GEOMETRYCOLLECTION":
+		if p.dimension() {
+			break
+		}
+		err := p.list(func() error {
+			g, err := p.geometry()
+			m = append(m, g...)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("Invalid WKT: only POLYGON, MULTIPOLYGON and GEOMETRYCOLLECTION are supported")
+	}
+	return m, nil
+}
+
+//ParseWKT parses a POLYGON, MULTIPOLYGON or GEOMETRYCOLLECTION of polygons in WKT format, with longitudes and latitudes in degrees.
+//Several geometries may follow each other (e.g. one per line): the result is their union.
+func ParseWKT(s string) (MultiPolygon, error) {
+	p := &wktParser{s: s}
+
+	var m MultiPolygon
+	for {
+		g, err := p.geometry()
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, g...)
+
+		if p.peek() == ';' {
+			p.pos++
+		}
+		if p.peek() == 0 {
+			break
+		}
+		if !unicode.IsLetter(rune(p.peek())) {
+			return nil, fmt.Errorf("Invalid WKT: unexpected content at position %d", p.pos)
+		}
+	}
+	return m, nil
+}
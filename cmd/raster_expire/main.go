@@ -0,0 +1,254 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Command raster_expire provides a CLI tool to expire the tiles of a tile data source (such as MBTiles database or tile folder)
+//listed in an expiry list, such as the ones produced by osm2pgsql.
+//
+//You can run it using
+//		raster_expire -expire="expire.list" -dst="world.mbtiles" -levelmin=10 -levelmax=18
+//
+//The expired tiles are expanded to the zoom range: their ancestors and descendants are expired too.
+//They are deleted from the destination, and re-fetched if a source is given. Alternatively, they can be marked
+//by writing them into a tile list, which can be re-seeded later with raster_init -tilelist.
+//
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+
+	"github.com/cheggaaa/pb"
+	_ "github.com/mattn/go-sqlite3"
+
+	_ "github.com/xeonx/raster/formats/archive"
+	_ "github.com/xeonx/raster/formats/mbtiles"
+	_ "github.com/xeonx/raster/formats/tilefolder"
+	_ "github.com/xeonx/raster/formats/tilejson"
+	_ "github.com/xeonx/raster/formats/wms"
+	_ "github.com/xeonx/raster/formats/wmts"
+	"github.com/xeonx/raster/formats/zxyserver"
+
+	"github.com/xeonx/raster"
+)
+
+var expire = flag.String("expire", "", "Expiry list: one level/x/y per line, optionally gzip compressed")
+var expireTMS = flag.Bool("expiretms", false, "y of the expiry list follows the TMS convention (default is XYZ: y=0 at the top)")
+
+var lvlmin = flag.Int("levelmin", 0, "minimum zoom level of the expired tiles")
+var lvlmax = flag.Int("levelmax", 18, "maximum zoom level of the expired tiles")
+
+var dst = flag.String("dst", "", "Destination data source name")
+var dstDriver = flag.String("dstdriver", "", "Destination driver")
+var dstLayer = flag.String("dstlayer", "data", "Destination data source layer name")
+
+var src = flag.String("src", "", "Source data source name, used to re-fetch the expired tiles (default is to only delete them)")
+var srcDriver = flag.String("srcdriver", "", "Source driver")
+var srcLayer = flag.String("srclayer", "", "Source data source layer name")
+
+var userAgent = flag.String("useragent", "", "User-Agent of the requests sent to zxy servers (default is "+zxyserver.DefaultUserAgent+")")
+var referer = flag.String("referer", "", "Referer of the requests sent to zxy servers")
+var rps = flag.Float64("rps", 0, "maximum number of requests per second sent to each zxy server host (default is no limit)")
+var maxConn = flag.Int("maxconn", 0, "maximum number of concurrent connections to each zxy server host (default is no limit)")
+var credentials = flag.String("credentials", "", "JSON file with the credentials of each zxy server host (default is the file named by $"+zxyserver.CredentialsEnv+")")
+
+var mark = flag.String("mark", "", "Tile list file where the expired tiles are written, instead of deleting them")
+
+type closer interface {
+	Close() error
+}
+
+//closeOutput closes the destination. Some destinations, such as archives, write the tiles when closed.
+var closeOutput = func() error { return nil }
+
+//fatal closes the destination before logging v and exiting, so that the tiles already processed are kept
+func fatal(v ...interface{}) {
+	if err := closeOutput(); err != nil {
+		log.Print(err)
+	}
+	log.Fatal(v...)
+}
+
+//readExpiryList reads and expands the expiry list
+func readExpiryList(filename string) ([]raster.TileID, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	it, err := raster.NewTileListReader(f, !*expireTMS)
+	if err != nil {
+		return nil, err
+	}
+	return raster.ExpandTiles(it, *lvlmin, *lvlmax)
+}
+
+//writeMarks writes the expired tiles into a tile list file
+func writeMarks(filename string, tiles []raster.TileID) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	err = raster.WriteTileList(f, raster.NewTileSliceIterator(tiles), !*expireTMS)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//openSource opens the source layer used to re-fetch the expired tiles
+func openSource() (raster.TileReader, closer, error) {
+	if len(*srcDriver) == 0 {
+		*srcDriver = raster.FindDriverName(*src)
+	}
+	input, err := raster.Open(*srcDriver, *src, zxyserver.WithSettings(zxyserver.Settings{
+		UserAgent:         *userAgent,
+		Referer:           *referer,
+		RequestsPerSecond: *rps,
+		MaxConnections:    *maxConn,
+		CredentialsFile:   *credentials,
+	}))
+	if err != nil {
+		return nil, nil, err
+	}
+	c, _ := input.(closer)
+	var inputReader raster.TileReader
+	if len(*srcLayer) > 0 {
+		inputReader, err = input.OpenTileLayer(*srcLayer)
+	} else {
+		inputReader, err = raster.OpenTileLayerAt(input, 0)
+	}
+	if err != nil {
+		if c != nil {
+			c.Close()
+		}
+		return nil, nil, err
+	}
+	return inputReader, c, nil
+}
+
+//refetch copies the tiles from the source, overwriting the stored ones. The tiles missing in the source are deleted.
+//It returns the number of tiles re-fetched and the tiles which could not be.
+func refetch(copier *raster.Copier, tiles []raster.TileID, progressFct func()) (int, []raster.TileID) {
+	fetched := 0
+	var failed []raster.TileID
+	for _, t := range tiles {
+		processed, err := copier.Copy(t.Level, t.X, t.Y)
+		if err != nil {
+			log.Printf("Tile %d/%d/%d: %s", t.Level, t.X, t.Y, err)
+			failed = append(failed, t)
+		} else if processed {
+			fetched++
+		}
+		progressFct()
+	}
+	return fetched, failed
+}
+
+func main() {
+	flag.Parse()
+
+	tiles, err := readExpiryList(*expire)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Print("Nb expired tiles: ", len(tiles))
+
+	if len(*mark) > 0 {
+		if err := writeMarks(*mark, tiles); err != nil {
+			log.Fatal(err)
+		}
+		log.Print("Expired tiles written to ", *mark)
+		return
+	}
+
+	//Source
+	var inputReader raster.TileReader
+	if len(*src) > 0 {
+		var c closer
+		inputReader, c, err = openSource()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if c != nil {
+			defer c.Close()
+		}
+	}
+
+	//Destination
+	if len(*dstDriver) == 0 {
+		*dstDriver = raster.FindDriverName(*dst)
+	}
+	outputReader, err := raster.Open(*dstDriver, *dst)
+	if err != nil {
+		log.Fatal(err)
+	}
+	output, ok := outputReader.(raster.WritableTileSource)
+	if !ok {
+		log.Fatal("Output driver does not allow writing")
+	}
+	if c, ok := output.(closer); ok {
+		var once sync.Once
+		closeOutput = func() error {
+			var err error
+			once.Do(func() { err = c.Close() })
+			return err
+		}
+	}
+	defer func() {
+		if err := closeOutput(); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	//An interrupted run keeps the tiles already processed
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		fatal("Interrupted")
+	}()
+
+	outputWriter, err := output.CreateTileLayer(*dstLayer)
+	if err != nil {
+		fatal(err)
+	}
+	deleter, ok := outputWriter.(raster.TileDeleter)
+	if !ok {
+		fatal("Output driver does not allow deleting tiles")
+	}
+
+	//Re-fetch from the source: only the tiles missing in the source or which could not be re-fetched are deleted
+	expired := tiles
+	if inputReader != nil {
+		copier, err := raster.NewCopier(inputReader, outputWriter)
+		if err != nil {
+			fatal(err)
+		}
+		copier.DeleteMissing = true
+
+		bar := pb.StartNew(len(tiles))
+		var fetched int
+		fetched, expired = refetch(copier, tiles, func() { bar.Increment() })
+		bar.FinishPrint("End")
+		log.Print("Nb tiles re-fetched: ", fetched)
+	}
+
+	if len(expired) == 0 {
+		return
+	}
+	bar := pb.StartNew(len(expired))
+	deleted, err := raster.DeleteTiles(deleter, raster.NewTileSliceIterator(expired), func(level, x, y int, processed bool) {
+		bar.Increment()
+	})
+	if err != nil {
+		fatal(err)
+	}
+	bar.FinishPrint("End")
+	log.Print("Nb tiles deleted: ", deleted)
+}
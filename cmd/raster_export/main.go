@@ -0,0 +1,192 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Command raster_export provides a CLI tool to render an area of a tile data source (such as MBTiles or Geopackage database) into a single georeferenced image.
+//
+//You can run it using
+//		raster_export -src="world.mbtiles" -bbox="5.8,45.8,10.5,47.8" -level=8 -out="switzerland.png"
+//
+//PNG and JPEG images are written with an accompanying world file (.pgw or .jgw) in web mercator (EPSG:3857) coordinates.
+//GeoTIFF images (.tif) embed their georeferencing, in EPSG:3857 or EPSG:4326.
+//
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/xeonx/geographic"
+
+	_ "github.com/xeonx/raster/formats/archive"
+	_ "github.com/xeonx/raster/formats/gpkg"
+	_ "github.com/xeonx/raster/formats/mbtiles"
+	_ "github.com/xeonx/raster/formats/tilefolder"
+	_ "github.com/xeonx/raster/formats/tilejson"
+	_ "github.com/xeonx/raster/formats/wms"
+	_ "github.com/xeonx/raster/formats/wmts"
+	_ "github.com/xeonx/raster/formats/zxyserver"
+
+	"github.com/xeonx/raster"
+	"github.com/xeonx/raster/geotiff"
+)
+
+var src = flag.String("src", "", "Source data source name")
+var srcDriver = flag.String("srcdriver", "", "Source driver")
+var srcLayer = flag.String("srclayer", "", "Source data source layer name")
+
+var bboxFlag = flag.String("bbox", "", "Area to export, as 'lonmin,latmin,lonmax,latmax' in degrees")
+var level = flag.Int("level", -1, "zoom level of the exported tiles (default is computed from -size)")
+var size = flag.Int("size", 1024, "minimal width or height in pixels of the exported image, used when -level is not set")
+var maxLevel = flag.Int("levelmax", 18, "maximum zoom level used when computing the level from -size")
+
+var out = flag.String("out", "", "Output image file (.png, .jpg or .tif)")
+var jpegQuality = flag.Int("jpegquality", 0, "JPEG quality, from 1 to 100 (default is the encoder default)")
+
+var epsg = flag.Int("epsg", geotiff.EPSG3857, "Coordinate reference system of GeoTIFF output (3857 or 4326)")
+var tiffTileSize = flag.Int("tifftilesize", 0, "Internal tile size of GeoTIFF output, multiple of 16 (default is stripped)")
+var deflate = flag.Bool("deflate", false, "compress GeoTIFF output with deflate")
+
+type closer interface {
+	Close() error
+}
+
+//parseBoundingBox parses a bounding box given as 'lonmin,latmin,lonmax,latmax'
+func parseBoundingBox(s string) (geographic.BoundingBox, error) {
+	var bbox geographic.BoundingBox
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return bbox, errors.New("Invalid bounding box: 'lonmin,latmin,lonmax,latmax' expected")
+	}
+
+	var values [4]float64
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return bbox, err
+		}
+		values[i] = v
+	}
+
+	bbox.LongitudeMinDeg = values[0]
+	bbox.LatitudeMinDeg = values[1]
+	bbox.LongitudeMaxDeg = values[2]
+	bbox.LatitudeMaxDeg = values[3]
+	return bbox, nil
+}
+
+//outputFormat returns the image format matching the extension of the output file
+func outputFormat(filename string) (string, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".png":
+		return "png", nil
+	case ".jpg", ".jpeg":
+		return "jpg", nil
+	case ".tif", ".tiff":
+		return "tif", nil
+	}
+	return "", errors.New("Unsupported output file extension: '" + filepath.Ext(filename) + "'. Only '.png', '.jpg' or '.tif' allowed.")
+}
+
+//writeFile writes data into a file, using fct to produce the content
+func writeFile(filename string, fct func(f *os.File) error) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := fct(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func main() {
+	flag.Parse()
+
+	bbox, err := parseBoundingBox(*bboxFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	format, err := outputFormat(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//Source
+	if len(*srcDriver) == 0 {
+		*srcDriver = raster.FindDriverName(*src)
+	}
+	input, err := raster.Open(*srcDriver, *src)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if c, ok := input.(closer); ok {
+		defer c.Close()
+	}
+	var inputReader raster.TileReader
+	if len(*srcLayer) > 0 {
+		inputReader, err = input.OpenTileLayer(*srcLayer)
+	} else {
+		inputReader, err = raster.OpenTileLayerAt(input, 0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//Build the mosaic
+	if *level < 0 {
+		*level = raster.MosaicLevel(bbox, *size, raster.TileSize(inputReader), *maxLevel)
+	}
+	log.Print("Level: ", *level)
+
+	mosaic, err := raster.NewMosaic(inputReader, bbox, *level)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Print("Image size: ", mosaic.Image.Bounds().Dx(), "x", mosaic.Image.Bounds().Dy())
+
+	//GeoTIFF embeds its georeferencing
+	if format == "tif" {
+		err = writeFile(*out, func(f *os.File) error {
+			return geotiff.EncodeMosaic(f, mosaic, *epsg, &geotiff.Options{
+				TileSize: *tiffTileSize,
+				Deflate:  *deflate,
+			})
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Print("Exported ", *out)
+		return
+	}
+
+	//Write the image and its world file
+	b, err := raster.EncodeWithOptions(mosaic.Image, format, &raster.EncodeOptions{JPEGQuality: *jpegQuality})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = writeFile(*out, func(f *os.File) error {
+		_, err := f.Write(b)
+		return err
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	worldFile := strings.TrimSuffix(*out, filepath.Ext(*out)) + raster.WorldFileExt(format)
+	err = writeFile(worldFile, func(f *os.File) error {
+		return mosaic.WriteWorldFile(f)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Print("Exported ", *out, " and ", worldFile)
+}
@@ -0,0 +1,178 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/xeonx/geographic"
+	"github.com/xeonx/raster"
+	"github.com/xeonx/raster/aoi"
+)
+
+//zoomRange is a range of levels copied within its own area of interest
+type zoomRange struct {
+	LevelMin  int      `json:"levelmin"`
+	LevelMax  int      `json:"levelmax"`
+	AOI       string   `json:"aoi"`       //WKT, GeoJSON or path to a WKT, GeoJSON or GeoPackage file
+	AOILayer  string   `json:"aoilayer"`  //Feature table of a GeoPackage AOI
+	AOIBuffer float64  `json:"aoibuffer"` //Buffer distance in meters
+	Exclude   []string `json:"exclude"`   //Areas to skip, in the same forms as AOI
+}
+
+//job is a copy made of several zoom ranges, each one with its own area of interest
+type job struct {
+	Ranges []zoomRange `json:"ranges"`
+}
+
+//readJob reads a job definition from a JSON file
+func readJob(filename string) (*job, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var j job
+	if err := json.NewDecoder(f).Decode(&j); err != nil {
+		return nil, fmt.Errorf("Invalid job definition '%s': %s", filename, err)
+	}
+	return &j, nil
+}
+
+//validate checks that the zoom ranges are valid and do not overlap
+func (j *job) validate() error {
+	if len(j.Ranges) == 0 {
+		return fmt.Errorf("Invalid job definition: no zoom range")
+	}
+	defined := make(map[int]bool)
+	for _, r := range j.Ranges {
+		if r.LevelMin < 0 || r.LevelMin > r.LevelMax {
+			return fmt.Errorf("Invalid zoom range: %d-%d", r.LevelMin, r.LevelMax)
+		}
+		if len(r.AOI) == 0 {
+			return fmt.Errorf("Invalid zoom range %d-%d: no area of interest", r.LevelMin, r.LevelMax)
+		}
+		for level := r.LevelMin; level <= r.LevelMax; level++ {
+			if defined[level] {
+				return fmt.Errorf("Invalid job definition: level %d is defined by several zoom ranges", level)
+			}
+			defined[level] = true
+		}
+	}
+	return nil
+}
+
+//levelPlan is the set of tiles to copy at a given level
+type levelPlan struct {
+	Level    int
+	Bbox     geographic.BoundingBox //Bounding box of the area of interest, restricted to the source
+	Coverage *aoi.Coverage
+	Tiles    raster.TileBlock //Tiles of the bounding box, of which only the blocks of the coverage are iterated
+	Count    int              //Number of tiles in the blocks of the coverage
+}
+
+//plan computes the tiles to copy for each level of the job.
+//If srcBbox is not nil, the areas of interest are restricted to it.
+//Blocks are aligned on multiples of blockSize to match metatiles.
+func (j *job) plan(srcBbox *geographic.BoundingBox, blockSize int) ([]levelPlan, error) {
+	var plans []levelPlan
+	for _, r := range j.Ranges {
+		area, err := loadAOI(r.AOI, r.AOILayer, r.AOIBuffer)
+		if err != nil {
+			return nil, err
+		}
+		var exclude aoi.MultiPolygon
+		for _, e := range r.Exclude {
+			area, err := loadAOI(e, "", 0)
+			if err != nil {
+				return nil, err
+			}
+			exclude = append(exclude, area...)
+		}
+		coverage := aoi.NewCoverageExcluding(area, exclude)
+
+		bbox := aoi.GetBoundingBox(area)
+		if srcBbox != nil {
+			var ok bool
+			bbox, ok = raster.IntersectBoundingBox(bbox, *srcBbox)
+			if !ok {
+				continue
+			}
+		}
+
+		for level := r.LevelMin; level <= r.LevelMax; level++ {
+			tiles, err := raster.GetTileBlock(bbox, level)
+			if err != nil {
+				return nil, err
+			}
+
+			//Only the blocks intersecting the area of interest are iterated
+			p := levelPlan{Level: level, Bbox: bbox, Coverage: coverage, Tiles: tiles}
+			for _, b := range coverage.Blocks(level, blockSize) {
+				if b, ok := b.Intersect(tiles); ok {
+					p.Count += b.Count()
+				}
+			}
+			plans = append(plans, p)
+		}
+	}
+	return plans, nil
+}
+
+//describeCopy returns the description of a destination where the plans are copied from a source described by src.
+//The zoom range and the bounds are the ones of the copied tiles, merged with the ones of the tiles already described by stored.
+func describeCopy(stored, src raster.TileJSON, plans []levelPlan) raster.TileJSON {
+	tj := src
+	if len(tj.Name) == 0 {
+		tj.Name = stored.Name
+	}
+
+	tj.MinZoom, tj.MaxZoom = plans[0].Level, plans[0].Level
+	bbox := plans[0].Bbox
+	for _, p := range plans {
+		if p.Level < tj.MinZoom {
+			tj.MinZoom = p.Level
+		}
+		if p.Level > tj.MaxZoom {
+			tj.MaxZoom = p.Level
+		}
+		bbox = unionBoundingBox(bbox, p.Bbox)
+	}
+
+	//Tiles already described (an empty description has a zero zoom range and no bounds)
+	if stored.MinZoom > 0 || stored.MaxZoom > 0 {
+		if stored.MinZoom < tj.MinZoom {
+			tj.MinZoom = stored.MinZoom
+		}
+		if stored.MaxZoom > tj.MaxZoom {
+			tj.MaxZoom = stored.MaxZoom
+		}
+	}
+	if b := stored.Bounds; len(b) == 4 {
+		bbox = unionBoundingBox(bbox, geographic.BoundingBox{LongitudeMinDeg: b[0], LatitudeMinDeg: b[1], LongitudeMaxDeg: b[2], LatitudeMaxDeg: b[3]})
+	}
+
+	tj.Bounds = []float64{bbox.LongitudeMinDeg, bbox.LatitudeMinDeg, bbox.LongitudeMaxDeg, bbox.LatitudeMaxDeg}
+	tj.Center = []float64{
+		(bbox.LongitudeMinDeg + bbox.LongitudeMaxDeg) / 2,
+		(bbox.LatitudeMinDeg + bbox.LatitudeMaxDeg) / 2,
+		float64(tj.MinZoom),
+	}
+	return tj
+}
+
+//unionBoundingBox returns the smallest bounding box containing a and b
+func unionBoundingBox(a, b geographic.BoundingBox) geographic.BoundingBox {
+	return geographic.BoundingBox{
+		LatitudeMinDeg:  math.Min(a.LatitudeMinDeg, b.LatitudeMinDeg),
+		LatitudeMaxDeg:  math.Max(a.LatitudeMaxDeg, b.LatitudeMaxDeg),
+		LongitudeMinDeg: math.Min(a.LongitudeMinDeg, b.LongitudeMinDeg),
+		LongitudeMaxDeg: math.Max(a.LongitudeMaxDeg, b.LongitudeMaxDeg),
+	}
+}
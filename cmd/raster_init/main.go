@@ -13,19 +13,35 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
+	"image/png"
 	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/cheggaaa/pb"
 	_ "github.com/mattn/go-sqlite3"
-	"github.com/paulsmith/gogeos/geos"
+	"github.com/xeonx/geographic"
 
-	_ "github.com/xeonx/raster/formats/gpkg"
+	_ "github.com/xeonx/raster/formats/archive"
+	_ "github.com/xeonx/raster/formats/georefimage"
+	"github.com/xeonx/raster/formats/gpkg"
 	_ "github.com/xeonx/raster/formats/mbtiles"
-	_ "github.com/xeonx/raster/formats/zxyserver"
+	_ "github.com/xeonx/raster/formats/tilefolder"
+	_ "github.com/xeonx/raster/formats/tilejson"
+	_ "github.com/xeonx/raster/formats/wms"
+	_ "github.com/xeonx/raster/formats/wmts"
+	"github.com/xeonx/raster/formats/zxyserver"
 
 	"github.com/xeonx/raster"
-	"github.com/xeonx/raster/geosconverter"
+	"github.com/xeonx/raster/aoi"
 )
 
 var lvlmin = flag.Int("levelmin", 0, "zoom level")
@@ -35,23 +51,242 @@ var src = flag.String("src", "", "Source data source name")
 var srcDriver = flag.String("srcdriver", "", "Source driver")
 var srcLayer = flag.String("srclayer", "", "Source data source layer name")
 
+var userAgent = flag.String("useragent", "", "User-Agent of the requests sent to zxy servers (default is "+zxyserver.DefaultUserAgent+")")
+var referer = flag.String("referer", "", "Referer of the requests sent to zxy servers")
+var rps = flag.Float64("rps", 0, "maximum number of requests per second sent to each zxy server host (default is no limit)")
+var maxConn = flag.Int("maxconn", 0, "maximum number of concurrent connections to each zxy server host (default is no limit)")
+var credentials = flag.String("credentials", "", "JSON file with the credentials of each zxy server host (default is the file named by $"+zxyserver.CredentialsEnv+")")
+
 var dst = flag.String("dst", "", "Destination data source name")
 var dstDriver = flag.String("dstdriver", "", "Destination driver")
 var dstLayer = flag.String("dstlayer", "data", "Destination data source layer name")
+var tileSize = flag.Int("tilesize", 0, "Destination tile size in pixels, e.g. 512 (default is the source tile size)")
 
-var aoi = flag.String("aoi", "POLYGON((-180 -85.0511, 180 -85.0511, 180 85.0511, -180 85.0511, -180 -85.0511))", "Area of interest (in WKT)")
+var aoiFlag = flag.String("aoi", "POLYGON((-180 -85.0511, 180 -85.0511, 180 85.0511, -180 85.0511, -180 -85.0511))", "Area of interest: polygons in WKT or GeoJSON, or path to a WKT, GeoJSON or GeoPackage file")
+var aoiLayer = flag.String("aoilayer", "", "Feature table of the GeoPackage area of interest (default is the first feature table)")
+var aoiBuffer = flag.Float64("aoibuffer", 0, "Buffer distance in meters extending the area of interest")
+var tileList = flag.String("tilelist", "", "File listing the tiles to copy, one level/x/y per line, optionally gzip compressed. Replaces levelmin, levelmax, aoi and job")
+var tileListTMS = flag.Bool("tilelisttms", false, "y of the tile list follows the TMS convention (default is XYZ: y=0 at the top)")
+var jobFile = flag.String("job", "", "Job definition file (JSON) with an area of interest per zoom range, replacing levelmin, levelmax, aoi, aoilayer and aoibuffer")
 var replace = flag.Bool("replace", false, "force replace of existing tiles")
+var olderThan = flag.String("older-than", "", "refresh only the existing tiles older than the given age, e.g. 30d, 2w or 12h (default is to skip all existing tiles)")
+
+var jpegQuality = flag.Int("jpegquality", 0, "JPEG quality of re-encoded tiles, from 1 to 100 (default is the encoder default)")
+var pngCompression = flag.String("pngcompression", "default", "PNG compression level of re-encoded tiles (default, none, speed or best)")
+var palette = flag.Bool("palette", false, "quantize re-encoded PNG tiles to a 8-bit palette")
+var dither = flag.Bool("dither", false, "apply dithering when quantizing PNG tiles")
+
+var metatile = flag.Int("metatile", 1, "number of tiles per side requested at once from sources supporting metatiles (e.g. 8 for 8x8 metatiles)")
 
 type closer interface {
 	Close() error
 }
 
+//closeOutput closes the destination. Some destinations, such as archives, write the tiles when closed.
+var closeOutput = func() error { return nil }
+
+//fatal closes the destination before logging v and exiting, so that the tiles already copied are kept
+func fatal(v ...interface{}) {
+	if err := closeOutput(); err != nil {
+		log.Print(err)
+	}
+	log.Fatal(v...)
+}
+
+//tileJSONWriter is implemented by the destinations storing a description of their tiles, such as tile folders
+type tileJSONWriter interface {
+	TileJSON() raster.TileJSON
+	SetTileJSON(tj raster.TileJSON) error
+}
+
+//encodeOptions builds the encoding options from the command line flags.
+//It returns nil if no option is set, meaning that tiles are re-encoded only on format change.
+func encodeOptions() (*raster.EncodeOptions, error) {
+	opts := raster.EncodeOptions{
+		JPEGQuality: *jpegQuality,
+		Palette:     *palette,
+		Dither:      *dither,
+	}
+
+	switch *pngCompression {
+	case "default":
+		opts.PNGCompression = png.DefaultCompression
+	case "none":
+		opts.PNGCompression = png.NoCompression
+	case "speed":
+		opts.PNGCompression = png.BestSpeed
+	case "best":
+		opts.PNGCompression = png.BestCompression
+	default:
+		return nil, fmt.Errorf("Invalid PNG compression level: '%s'", *pngCompression)
+	}
+
+	if opts == (raster.EncodeOptions{}) {
+		return nil, nil
+	}
+	return &opts, nil
+}
+
+//loadAOI reads the area of interest given on the command line.
+//The value is either a file path (GeoPackage, GeoJSON or WKT) or the area itself in WKT or GeoJSON.
+func loadAOI(value string, layer string, buffer float64) (aoi.MultiPolygon, error) {
+	var area aoi.MultiPolygon
+	var err error
+
+	if _, statErr := os.Stat(value); statErr != nil {
+		area, err = aoi.Parse(value)
+	} else if strings.ToLower(filepath.Ext(value)) == ".gpkg" {
+		area, err = readGeoPackageAOI(value, layer)
+	} else {
+		area, err = aoi.ReadFile(value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(area) == 0 {
+		return nil, fmt.Errorf("Empty area of interest: '%s'", value)
+	}
+
+	return aoi.Buffer(area, buffer), nil
+}
+
+//readGeoPackageAOI reads the union of the polygons of a GeoPackage feature table.
+//Geometries are expected in longitude/latitude (EPSG:4326).
+func readGeoPackageAOI(filename string, table string) (aoi.MultiPolygon, error) {
+	h, err := gpkg.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer h.Close()
+
+	if len(table) == 0 {
+		columns, err := h.ListGeometryColumns()
+		if err != nil {
+			return nil, err
+		}
+		if len(columns) == 0 {
+			return nil, fmt.Errorf("No feature table in '%s'", filename)
+		}
+		table = columns[0].TableName
+	}
+
+	features, err := h.ListFeatures(table)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(features)
+	if err != nil {
+		return nil, err
+	}
+	return aoi.ParseGeoJSON(b)
+}
+
+//parseAge parses an age given as a Go duration (e.g. 12h) or as a number of days (e.g. 30d) or weeks (e.g. 2w)
+func parseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(s, suffix) {
+			v, err := strconv.ParseFloat(strings.TrimSuffix(s, suffix), 64)
+			if err != nil {
+				return 0, fmt.Errorf("Invalid age '%s'", s)
+			}
+			return time.Duration(v * float64(unit)), nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+//existingFilter creates the Filter skipping the tiles already in the destination.
+//With -older-than, only the tiles written recently are skipped.
+func existingFilter(outputWriter raster.TileReadWriter) (raster.Filter, error) {
+	if len(*olderThan) == 0 {
+		return outputWriter.Contains, nil
+	}
+
+	age, err := parseAge(*olderThan)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := outputWriter.(raster.TileInfoReader); !ok {
+		log.Print("Destination does not record tile modification times: all existing tiles are refreshed")
+	}
+	return raster.ModifiedSinceFilter(outputWriter, time.Now().Add(-age)), nil
+}
+
+//countTileList counts the tiles of a tile list file, validating its content
+func countTileList(filename string) (int, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	it, err := raster.NewTileListReader(f, !*tileListTMS)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for it.Next() {
+		count++
+	}
+	return count, it.Err()
+}
+
+//copyTileList copies the tiles of a tile list file.
+//Unless replace is true, tiles already in the destination are skipped. No level is cleared.
+func copyTileList(copier *raster.Copier, outputWriter raster.TileReadWriter, filename string, replace bool) (int, error) {
+	dstFilter, err := existingFilter(outputWriter)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := countTileList(filename)
+	if err != nil {
+		return 0, err
+	}
+	log.Print("Nb tiles in list: ", count)
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	it, err := raster.NewTileListReader(f, !*tileListTMS)
+	if err != nil {
+		return 0, err
+	}
+
+	copier.Filter = nil
+	if !replace {
+		copier.Filter = dstFilter
+	}
+
+	bar := pb.StartNew(count)
+	processed, err := copier.CopyTiles(it, func(level, x, y int, processed bool) {
+		bar.Increment()
+	})
+	bar.FinishPrint("End")
+	return processed, err
+}
+
 func main() {
 	flag.Parse()
 
-	poly := geos.Must(geos.FromWKT(*aoi))
-	bbox, err := geosconverter.GetBoundingBox(poly)
-	if err != nil {
+	//Job definition
+	j := &job{Ranges: []zoomRange{{
+		LevelMin:  *lvlmin,
+		LevelMax:  *lvlmax,
+		AOI:       *aoiFlag,
+		AOILayer:  *aoiLayer,
+		AOIBuffer: *aoiBuffer,
+	}}}
+	if len(*jobFile) > 0 {
+		var err error
+		j, err = readJob(*jobFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := j.validate(); err != nil {
 		log.Fatal(err)
 	}
 
@@ -59,7 +294,13 @@ func main() {
 	if len(*srcDriver) == 0 {
 		*srcDriver = raster.FindDriverName(*src)
 	}
-	input, err := raster.Open(*srcDriver, *src)
+	input, err := raster.Open(*srcDriver, *src, zxyserver.WithSettings(zxyserver.Settings{
+		UserAgent:         *userAgent,
+		Referer:           *referer,
+		RequestsPerSecond: *rps,
+		MaxConnections:    *maxConn,
+		CredentialsFile:   *credentials,
+	}))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -75,6 +316,24 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	var srcBbox *geographic.BoundingBox
+	if b, ok := inputReader.(raster.Bounded); ok {
+		bounds, err := b.Bounds()
+		if err != nil {
+			log.Fatal(err)
+		}
+		srcBbox = &bounds
+	}
+	srcTileJSON, err := raster.NewTileJSON(inputReader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *tileSize > 0 {
+		inputReader, err = raster.NewTileSizeConverter(inputReader, *tileSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	//Destination
 	if len(*dstDriver) == 0 {
@@ -89,57 +348,118 @@ func main() {
 		log.Fatal("Output driver does not allow writing")
 	}
 	if c, ok := output.(closer); ok {
-		defer c.Close()
+		var once sync.Once
+		closeOutput = func() error {
+			var err error
+			once.Do(func() { err = c.Close() })
+			return err
+		}
 	}
+	defer func() {
+		if err := closeOutput(); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	//An interrupted copy keeps the tiles already copied
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		fatal("Interrupted")
+	}()
+
 	outputWriter, err := output.CreateTileLayer(*dstLayer)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+	//The destination records the size of the copied tiles, so that they are read back at this size
+	if w, ok := outputWriter.(raster.TileSizeWriter); ok && raster.TileSize(inputReader) != raster.TileSize(outputWriter) {
+		if err := w.SetTileSize(raster.TileSize(inputReader)); err != nil {
+			fatal(err)
+		}
 	}
 
 	//Initialize the copy
 	copier, err := raster.NewCopier(inputReader, outputWriter)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+	copier.EncodeOptions, err = encodeOptions()
+	if err != nil {
+		fatal(err)
 	}
+	copier.MetatileSize = *metatile
 
-	polygonFilter := geosconverter.IntersectsFilter(poly)
-	if *replace {
-		copier.Filter = polygonFilter
-	} else {
-		copier.Filter = raster.Any(outputWriter.Contains, polygonFilter)
+	if len(*tileList) > 0 {
+		processed, err := copyTileList(copier, outputWriter, *tileList, *replace)
+		if err != nil {
+			fatal(err)
+		}
+		log.Print("Nb tiles processed: ", processed)
+		return
 	}
 
-	//Iterate on each requested level and performs the copy
-	for level := *lvlmin; level <= *lvlmax; level++ {
-		log.Print("Level: ", level)
+	//Compute the tiles to copy
+	plans, err := j.plan(srcBbox, *metatile)
+	if err != nil {
+		fatal(err)
+	}
+	total := 0
+	for _, p := range plans {
+		log.Print("Level ", p.Level, ": ", p.Count, " tiles in area of interest")
+		total += p.Count
+	}
+	if total == 0 {
+		fatal("Area of interest does not intersect the source")
+	}
 
-		if *replace {
-			log.Print("Level ", level, " clearing in database")
-			err := outputWriter.Clear(level)
-			if err != nil {
-				log.Fatal(err)
-			}
-			log.Print("Level ", level, " cleared in database")
+	if w, ok := outputWriter.(tileJSONWriter); ok {
+		if err := w.SetTileJSON(describeCopy(w.TileJSON(), srcTileJSON, plans)); err != nil {
+			fatal(err)
 		}
+	}
 
-		tiles, err := raster.GetTileBlock(bbox, level)
-		if err != nil {
-			log.Fatal(err)
+	if *replace {
+		for _, p := range plans {
+			log.Print("Level ", p.Level, " clearing in database")
+			err := outputWriter.Clear(p.Level)
+			if err != nil {
+				fatal(err)
+			}
+			log.Print("Level ", p.Level, " cleared in database")
 		}
-		log.Print("BBOX (tiles): ", tiles)
+	}
 
-		log.Print("Nb tiles in BBOX: ", tiles.Count())
+	dstFilter, err := existingFilter(outputWriter)
+	if err != nil {
+		fatal(err)
+	}
 
-		bar := pb.StartNew(tiles.Count())
+	if !*replace {
+		copier.Filter = dstFilter
+	}
 
-		processed, err := copier.CopyBlock(tiles, func(level, x, y int, processed bool) {
+	//Iterate on each planned level and performs the copy: only the tiles in the area of interest are iterated
+	start := time.Now()
+	bar := pb.StartNew(total)
+	processed := make([]int, len(plans))
+	for i, p := range plans {
+		copier.Area = p.Coverage
+		n, err := copier.CopyBlock(p.Tiles, func(level, x, y int, processed bool) {
 			bar.Increment()
 		})
+		processed[i] = n
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
-		bar.FinishPrint("End")
-		log.Print("Nb tiles processed: ", processed)
 	}
+	bar.FinishPrint("End")
 
+	//Report
+	totalProcessed := 0
+	for i, p := range plans {
+		log.Printf("Level %d: %d tiles in area of interest, %d tiles processed", p.Level, p.Count, processed[i])
+		totalProcessed += processed[i]
+	}
+	log.Printf("Total: %d tiles in area of interest, %d tiles processed in %s", total, totalProcessed, time.Since(start))
 }
@@ -8,27 +8,41 @@
 //	raster_server -db="mydb.db" -http=":8085"
 //
 //Tiles are served at http://localhost:8085/tiles/level/x/y.png and an OpenLayers map is available at http://localhost:8085/
+//The TileJSON document describing the tiles is served at http://localhost:8085/tiles.json
 //
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"html/template"
 	"log"
 	"net/http"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/xeonx/raster"
+	_ "github.com/xeonx/raster/formats/archive"
 	_ "github.com/xeonx/raster/formats/gpkg"
 	_ "github.com/xeonx/raster/formats/mbtiles"
-	_ "github.com/xeonx/raster/formats/zxyserver"
+	_ "github.com/xeonx/raster/formats/tilefolder"
+	_ "github.com/xeonx/raster/formats/tilejson"
+	_ "github.com/xeonx/raster/formats/wms"
+	_ "github.com/xeonx/raster/formats/wmts"
+	"github.com/xeonx/raster/formats/zxyserver"
 )
 
 var src = flag.String("src", "", "Source data source name")
 var srcDriver = flag.String("srcdriver", "", "Source driver")
 var srcLayer = flag.String("srclayer", "", "Source data source layer name")
 
+var userAgent = flag.String("useragent", "", "User-Agent of the requests sent to zxy servers (default is "+zxyserver.DefaultUserAgent+")")
+var referer = flag.String("referer", "", "Referer of the requests sent to zxy servers")
+var rps = flag.Float64("rps", 0, "maximum number of requests per second sent to each zxy server host (default is no limit)")
+var maxConn = flag.Int("maxconn", 0, "maximum number of concurrent connections to each zxy server host (default is no limit)")
+var credentials = flag.String("credentials", "", "JSON file with the credentials of each zxy server host (default is the file named by $"+zxyserver.CredentialsEnv+")")
+
 var addr = flag.String("http", ":8085", "HTTP service address (e.g., '127.0.0.1:8085' or just ':8085')")
 
 var page = `<!doctype html>
@@ -80,10 +94,59 @@ func (h mapPageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+//tileJSONHandler serves the TileJSON document describing the tiles served at /tiles/
+type tileJSONHandler struct {
+	Name   string
+	Reader raster.TileReader
+}
+
+func (h tileJSONHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	ext := h.Reader.TileFormat()
+	if ext == "jpg" {
+		ext = "jpeg"
+	}
+
+	tj, err := raster.NewTileJSON(h.Reader, scheme+"://"+r.Host+"/tiles/{z}/{x}/{y}."+ext)
+	if err != nil {
+		log.Print("Error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	tj.Name = h.Name
+	tj.Scheme = "tms"
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tj); err != nil {
+		log.Print("Error: ", err)
+	}
+}
+
 type closer interface {
 	Close() error
 }
 
+//displayName returns a data source name without its options fragment nor the credentials of its URL,
+//as they may hold secrets (such as passwords or API keys) that must not be logged or published.
+func displayName(dataSourceName string) string {
+	if i := strings.LastIndex(dataSourceName, "#"); i >= 0 {
+		dataSourceName = dataSourceName[:i]
+	}
+	if i := strings.Index(dataSourceName, "://"); i >= 0 {
+		host := dataSourceName[i+3:]
+		if j := strings.IndexAny(host, "/?"); j >= 0 {
+			host = host[:j]
+		}
+		if j := strings.LastIndex(host, "@"); j >= 0 {
+			dataSourceName = dataSourceName[:i+3] + dataSourceName[i+3+j+1:]
+		}
+	}
+	return dataSourceName
+}
+
 func main() {
 	flag.Parse()
 
@@ -91,7 +154,13 @@ func main() {
 	if len(*srcDriver) == 0 {
 		*srcDriver = raster.FindDriverName(*src)
 	}
-	input, err := raster.Open(*srcDriver, *src)
+	input, err := raster.Open(*srcDriver, *src, zxyserver.WithSettings(zxyserver.Settings{
+		UserAgent:         *userAgent,
+		Referer:           *referer,
+		RequestsPerSecond: *rps,
+		MaxConnections:    *maxConn,
+		CredentialsFile:   *credentials,
+	}))
 	if err != nil {
 		log.Fatal("Open data source: ", err)
 	}
@@ -108,15 +177,24 @@ func main() {
 		log.Fatal("Open layer: ", err)
 	}
 
-	log.Print("Connected to data set '", *src, "'")
+	log.Print("Connected to data set '", displayName(*src), "'")
 
 	//Configure HTTP handlers
 	http.Handle("/tiles/", &raster.Server{
 		TileReader: tileReader,
 		ZeroIsTop:  false,
 	})
+	//The TileJSON document is public: it is named after the layer, or the source name without its credentials
+	name := *srcLayer
+	if len(name) == 0 {
+		name = displayName(*src)
+	}
+	http.Handle("/tiles.json", tileJSONHandler{
+		Name:   name,
+		Reader: tileReader,
+	})
 	http.Handle("/", mapPageHandler{
-		Name:   *src,
+		Name:   displayName(*src),
 		Reader: tileReader,
 	})
 
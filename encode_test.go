@@ -0,0 +1,201 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package raster
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"testing"
+)
+
+//newTestTile creates a 256x256 tile looking like a rendered map: flat areas, gradients and thin lines.
+func newTestTile() image.Image {
+	m := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			c := color.NRGBA{242, 239, 233, 255}
+			if x > 128 && y > 96 {
+				c = color.NRGBA{uint8(100 + x/2), uint8(211 - y/3), uint8(180 + (x+y)/16), 255}
+			}
+			if x%64 == 0 || (x+y)%97 == 0 {
+				c = color.NRGBA{255, 255, 255, 255}
+			}
+			if (x-y)%151 == 0 {
+				c = color.NRGBA{232, 146, 162, 255}
+			}
+			m.SetNRGBA(x, y, c)
+		}
+	}
+	return m
+}
+
+//psnr computes the peak signal-to-noise ratio between two images of the same size.
+func psnr(a, b image.Image) float64 {
+	var mse float64
+	bounds := a.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r1, g1, b1, _ := a.At(x, y).RGBA()
+			r2, g2, b2, _ := b.At(x, y).RGBA()
+			for _, d := range []float64{
+				float64(r1>>8) - float64(r2>>8),
+				float64(g1>>8) - float64(g2>>8),
+				float64(b1>>8) - float64(b2>>8),
+			} {
+				mse += d * d
+			}
+		}
+	}
+	mse /= float64(3 * bounds.Dx() * bounds.Dy())
+	if mse == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/mse)
+}
+
+func TestEncodeWithOptions(t *testing.T) {
+	img := newTestTile()
+
+	for _, tt := range []struct {
+		format  string
+		opts    *EncodeOptions
+		minPSNR float64
+	}{
+		{"png", nil, math.Inf(1)},
+		{"png", &EncodeOptions{PNGCompression: png.BestCompression}, math.Inf(1)},
+		{"png", &EncodeOptions{Palette: true}, 45},
+		{"png", &EncodeOptions{Palette: true, Dither: true}, 45},
+		{"jpg", nil, 30},
+		{"jpg", &EncodeOptions{JPEGQuality: 50}, 25},
+	} {
+		b, err := EncodeWithOptions(img, tt.format, tt.opts)
+		if err != nil {
+			t.Errorf("EncodeWithOptions(%s, %+v) => %v", tt.format, tt.opts, err)
+			continue
+		}
+		decoded, err := Decode(b, tt.format)
+		if err != nil {
+			t.Errorf("Decode(%s, %+v) => %v", tt.format, tt.opts, err)
+			continue
+		}
+		if decoded.Bounds() != img.Bounds() {
+			t.Errorf("Decode(%s, %+v) => bounds %v, want %v", tt.format, tt.opts, decoded.Bounds(), img.Bounds())
+			continue
+		}
+		if p := psnr(img, decoded); p < tt.minPSNR {
+			t.Errorf("EncodeWithOptions(%s, %+v) => PSNR %.1f dB, want at least %.1f dB", tt.format, tt.opts, p, tt.minPSNR)
+		}
+
+		paletted, ok := decoded.(*image.Paletted)
+		if tt.opts != nil && tt.opts.Palette {
+			if !ok || len(paletted.Palette) > 256 {
+				t.Errorf("EncodeWithOptions(%s, %+v) => %T, want an 8-bit paletted image", tt.format, tt.opts, decoded)
+			}
+		} else if ok {
+			t.Errorf("EncodeWithOptions(%s, %+v) => unexpected paletted image", tt.format, tt.opts)
+		}
+	}
+
+	if _, err := EncodeWithOptions(img, "jpg", &EncodeOptions{JPEGQuality: 101}); err == nil {
+		t.Errorf("EncodeWithOptions(jpg, quality 101) => no error")
+	}
+}
+
+func TestEncodePaletteReproducible(t *testing.T) {
+	img := newTestTile()
+	for _, opts := range []*EncodeOptions{{Palette: true}, {Palette: true, Dither: true}} {
+		first, err := EncodeWithOptions(img, "png", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5; i++ {
+			b, err := EncodeWithOptions(img, "png", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, first) {
+				t.Fatalf("EncodeWithOptions(%+v) => different bytes for the same image", opts)
+			}
+		}
+	}
+}
+
+func TestCopierTransform(t *testing.T) {
+	img := newTestTile()
+	jpg, err := Encode(img, "jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		opts      *EncodeOptions
+		unchanged bool
+	}{
+		{nil, true},
+		{&EncodeOptions{}, true},
+		{&EncodeOptions{Palette: true, Dither: true, PNGCompression: png.BestCompression}, true},
+		{&EncodeOptions{JPEGQuality: 50}, false},
+	} {
+		c, err := NewCopier(newMemTiles("jpg", 256), newMemTiles("jpg", 256))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.EncodeOptions = tt.opts
+		b, err := c.transform(jpg)
+		if err != nil {
+			t.Errorf("transform(%+v) => %v", tt.opts, err)
+			continue
+		}
+		if unchanged := bytes.Equal(b, jpg); unchanged != tt.unchanged {
+			t.Errorf("transform(%+v) => tile unchanged: %v, want %v", tt.opts, unchanged, tt.unchanged)
+		}
+	}
+}
+
+func benchmarkEncode(b *testing.B, format string, opts *EncodeOptions) {
+	img := newTestTile()
+
+	var encoded []byte
+	var err error
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encoded, err = EncodeWithOptions(img, format, opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	decoded, err := Decode(encoded, format)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(len(encoded)), "bytes/tile")
+	b.ReportMetric(psnr(img, decoded), "dB-PSNR")
+}
+
+func BenchmarkEncodePNGDefault(b *testing.B) { benchmarkEncode(b, "png", nil) }
+func BenchmarkEncodePNGBestSpeed(b *testing.B) {
+	benchmarkEncode(b, "png", &EncodeOptions{PNGCompression: png.BestSpeed})
+}
+func BenchmarkEncodePNGBestCompression(b *testing.B) {
+	benchmarkEncode(b, "png", &EncodeOptions{PNGCompression: png.BestCompression})
+}
+func BenchmarkEncodePNGPalette(b *testing.B) {
+	benchmarkEncode(b, "png", &EncodeOptions{Palette: true, PNGCompression: png.BestCompression})
+}
+func BenchmarkEncodePNGPaletteDither(b *testing.B) {
+	benchmarkEncode(b, "png", &EncodeOptions{Palette: true, Dither: true, PNGCompression: png.BestCompression})
+}
+func BenchmarkEncodeJPEGDefault(b *testing.B) { benchmarkEncode(b, "jpg", nil) }
+func BenchmarkEncodeJPEGQuality90(b *testing.B) {
+	benchmarkEncode(b, "jpg", &EncodeOptions{JPEGQuality: 90})
+}
+func BenchmarkEncodeJPEGQuality50(b *testing.B) {
+	benchmarkEncode(b, "jpg", &EncodeOptions{JPEGQuality: 50})
+}
@@ -0,0 +1,102 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package raster
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+)
+
+//TileDeleter is the interface implemented by a TileReadWriter able to remove a single tile.
+type TileDeleter interface {
+	//Delete removes the tile for a given level/x/y. Deleting a missing tile is not an error.
+	Delete(level, x, y int) error
+}
+
+//ExpandTiles computes the tiles from levelMin to levelMax covering a list of tiles, such as an expiry list:
+//the ancestors of each tile at the lower levels and all its descendants at the higher levels.
+//The result has no duplicate and is sorted by level, x and y.
+func ExpandTiles(tiles TileIterator, levelMin, levelMax int) ([]TileID, error) {
+	if levelMin < 0 || levelMin > levelMax {
+		return nil, fmt.Errorf("Invalid level range: %d-%d", levelMin, levelMax)
+	}
+
+	expanded := make(map[TileID]bool)
+	for tiles.Next() {
+		t := tiles.Tile()
+		for level := levelMin; level <= levelMax; level++ {
+			if level <= t.Level {
+				d := uint(t.Level - level)
+				expanded[TileID{Level: level, X: t.X >> d, Y: t.Y >> d}] = true
+				continue
+			}
+
+			d := uint(level - t.Level)
+			for x := t.X << d; x < (t.X+1)<<d; x++ {
+				for y := t.Y << d; y < (t.Y+1)<<d; y++ {
+					expanded[TileID{Level: level, X: x, Y: y}] = true
+				}
+			}
+		}
+	}
+	if err := tiles.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]TileID, 0, len(expanded))
+	for t := range expanded {
+		result = append(result, t)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Level != b.Level {
+			return a.Level < b.Level
+		}
+		if a.X != b.X {
+			return a.X < b.X
+		}
+		return a.Y < b.Y
+	})
+	return result, nil
+}
+
+//DeleteTiles removes each tile provided by an iterator.
+//If progressFct is not nil, it is called during the iteration after each tile.
+//It returns the count of tiles deleted and the first error encountered, if any.
+func DeleteTiles(d TileDeleter, tiles TileIterator, progressFct func(level, x, y int, processed bool)) (int, error) {
+	count := 0
+	for tiles.Next() {
+		t := tiles.Tile()
+		if err := d.Delete(t.Level, t.X, t.Y); err != nil {
+			return count, err
+		}
+		count++
+		if progressFct != nil {
+			progressFct(t.Level, t.X, t.Y, true)
+		}
+	}
+	return count, tiles.Err()
+}
+
+//WriteTileList writes each tile provided by an iterator as a "level/x/y" line, in the format read by NewTileListReader.
+//If xyz is true, y is converted to the XYZ convention (y=0 at the top).
+func WriteTileList(w io.Writer, tiles TileIterator, xyz bool) error {
+	bw := bufio.NewWriter(w)
+	for tiles.Next() {
+		t := tiles.Tile()
+		if xyz {
+			t = t.FlipY()
+		}
+		if _, err := fmt.Fprintln(bw, t); err != nil {
+			return err
+		}
+	}
+	if err := tiles.Err(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,79 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package raster
+
+import (
+	"bytes"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestExpandTiles(t *testing.T) {
+	tiles := NewTileSliceIterator([]TileID{{2, 1, 2}, {2, 1, 3}})
+	got, err := ExpandTiles(tiles, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []TileID{
+		{1, 0, 1},
+		{2, 1, 2}, {2, 1, 3},
+		{3, 2, 4}, {3, 2, 5}, {3, 2, 6}, {3, 2, 7},
+		{3, 3, 4}, {3, 3, 5}, {3, 3, 6}, {3, 3, 7},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("ExpandTiles() => %v, want %v", got, expected)
+	}
+
+	if _, err := ExpandTiles(NewTileSliceIterator(nil), 3, 1); err == nil {
+		t.Error("ExpandTiles() with an invalid level range should fail")
+	}
+}
+
+func TestDeleteTiles(t *testing.T) {
+	m := newMemTiles("png", 256)
+	tile := uniformTile(t, 256, color.RGBA{255, 0, 0, 255})
+	m.SetRaw(1, 0, 0, tile)
+	m.SetRaw(1, 1, 0, tile)
+
+	count, err := DeleteTiles(m, NewTileSliceIterator([]TileID{{1, 0, 0}, {1, 0, 1}}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("DeleteTiles() => %d, want 2", count)
+	}
+	if ok, _ := m.Contains(1, 0, 0); ok {
+		t.Error("DeleteTiles() did not delete 1/0/0")
+	}
+	if ok, _ := m.Contains(1, 1, 0); !ok {
+		t.Error("DeleteTiles() deleted 1/1/0")
+	}
+}
+
+func TestWriteTileList(t *testing.T) {
+	tiles := []TileID{{1, 0, 0}, {2, 3, 1}}
+
+	var b bytes.Buffer
+	if err := WriteTileList(&b, NewTileSliceIterator(tiles), true); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "1/0/1\n2/3/2\n" {
+		t.Errorf("WriteTileList() => %q", b.String())
+	}
+
+	it, err := NewTileListReader(&b, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []TileID
+	for it.Next() {
+		got = append(got, it.Tile())
+	}
+	if !reflect.DeepEqual(got, tiles) {
+		t.Errorf("NewTileListReader(WriteTileList()) => %v, want %v", got, tiles)
+	}
+}
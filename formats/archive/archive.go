@@ -0,0 +1,695 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+/*
+Package archive provides tile sources stored in zip and tar archives, such as offline map packages.
+
+Each top-level folder of an archive is a layer, storing its tiles as a tile folder (see the tilefolder package):
+its format and layout are read from its metadata.json file, or detected from the stored tiles.
+
+The files of the archive are indexed when it is opened, for random access to the tiles.
+Tiles written to an archive are staged in a temporary folder next to it, and the archive is rewritten when closed.
+The staging folders left by interrupted writes are taken over when the archive is next opened for writing.
+An archive must not be written by several processes at once.
+*/
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xeonx/geographic"
+	"github.com/xeonx/raster"
+	"github.com/xeonx/raster/formats/tilefolder"
+)
+
+//tempPrefix starts the name of the temporary files and folders created next to the archive
+const tempPrefix = ".tmp-"
+
+//entry locates a file of the archive
+type entry struct {
+	zip     *zip.File //File of a zip archive
+	offset  int64     //Offset of the content of a file of a tar archive
+	size    int64     //Size of the content of a file of a tar archive
+	modTime time.Time
+}
+
+//Archive is the TileSource of a zip or tar archive. It's safe for concurrent use by multiple goroutines.
+//
+//Close must be called to write the tiles stored in the archive.
+type Archive struct {
+	path   string
+	kind   string            //zip or tar
+	format string            //Format of the layers, or empty to read it from the metadata files
+	layout tilefolder.Layout //Layout of the layers, or nil to read it from the metadata files
+
+	mu       sync.RWMutex
+	index    map[string]entry //Files of the archive, by path
+	zip      *zip.ReadCloser
+	tar      *os.File
+	staging  string //Folder of the written tiles, empty until a layer is created
+	modified bool
+}
+
+//kindOf returns the kind of archive (zip or tar) from the extension of its path, or an empty string.
+func kindOf(archivePath string) string {
+	switch strings.ToLower(filepath.Ext(archivePath)) {
+	case ".zip":
+		return "zip"
+	case ".tar":
+		return "tar"
+	}
+	return ""
+}
+
+//Open opens the zip or tar archive given by its extension, creating an empty archive if the file does not exist.
+//
+//The format and the layout of the layers can be given as a fragment: area.zip#format=jpg&layout=xyz
+func Open(dataSourceName string) (*Archive, error) {
+	archivePath := dataSourceName
+	if i := strings.LastIndex(archivePath, "#"); i >= 0 {
+		archivePath = archivePath[:i]
+	}
+	kind := kindOf(archivePath)
+	if len(kind) == 0 {
+		return nil, fmt.Errorf("Unknown archive type of %s: only .zip and .tar are supported", archivePath)
+	}
+	return open(dataSourceName, kind)
+}
+
+//open opens an archive of the given kind
+func open(dataSourceName string, kind string) (*Archive, error) {
+	a := &Archive{path: dataSourceName, kind: kind, index: make(map[string]entry)}
+
+	if i := strings.LastIndex(dataSourceName, "#"); i >= 0 {
+		a.path = dataSourceName[:i]
+		options, err := url.ParseQuery(dataSourceName[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid archive options: %s", err)
+		}
+		for key, values := range options {
+			value := values[len(values)-1]
+			switch key {
+			case "format":
+				if value != "png" && value != "jpg" {
+					return nil, fmt.Errorf("Unknown archive tile format %s", value)
+				}
+				a.format = value
+			case "layout":
+				if a.layout, err = tilefolder.LayoutByName(value); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, fmt.Errorf("Unknown archive option %s", key)
+			}
+		}
+	}
+
+	if _, err := os.Stat(a.path); os.IsNotExist(err) {
+		return a, nil
+	}
+
+	var err error
+	if kind == "zip" {
+		err = a.readZipIndex()
+	} else {
+		err = a.readTarIndex()
+	}
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+//readZipIndex indexes the files of a zip archive from its central directory
+func (a *Archive) readZipIndex() error {
+	r, err := zip.OpenReader(a.path)
+	if err != nil {
+		return err
+	}
+	a.zip = r
+
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		a.index[path.Clean(f.Name)] = entry{zip: f, modTime: f.Modified}
+	}
+	return nil
+}
+
+//readTarIndex indexes the files of a tar archive by reading all its headers
+func (a *Archive) readTarIndex() error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	a.tar = f
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid tar archive %s: %s", a.path, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		//The content of the file starts after its header(s), i.e. at the current position
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		a.index[path.Clean(hdr.Name)] = entry{offset: offset, size: hdr.Size, modTime: hdr.ModTime}
+	}
+}
+
+//read reads the content of a file of the archive
+func (a *Archive) read(e entry) ([]byte, error) {
+	if e.zip != nil {
+		rc, err := e.zip.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+
+	b := make([]byte, e.size)
+	if _, err := a.tar.ReadAt(b, e.offset); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+//stagedPath returns the path of a file in the staging folder, or an empty string if no layer has been created
+func (a *Archive) stagedPath(name string) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if len(a.staging) == 0 {
+		return ""
+	}
+	return filepath.Join(a.staging, filepath.FromSlash(name))
+}
+
+//readFile reads a file of the archive, as written in the staging folder or as stored in the archive.
+//It returns nil if the file does not exist.
+func (a *Archive) readFile(name string) ([]byte, error) {
+	if p := a.stagedPath(name); len(p) > 0 {
+		b, err := ioutil.ReadFile(p)
+		if err == nil {
+			return b, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	a.mu.RLock()
+	e, ok := a.index[name]
+	a.mu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	return a.read(e)
+}
+
+//containsFile returns true if a file exists in the staging folder or in the archive
+func (a *Archive) containsFile(name string) (bool, error) {
+	if p := a.stagedPath(name); len(p) > 0 {
+		_, err := os.Stat(p)
+		if err == nil {
+			return true, nil
+		}
+		if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+
+	a.mu.RLock()
+	_, ok := a.index[name]
+	a.mu.RUnlock()
+	return ok, nil
+}
+
+//ListTileLayers lists the top-level folders of the archive, including the layers created since it was opened
+func (a *Archive) ListTileLayers() ([]string, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	names := make(map[string]bool)
+	for p := range a.index {
+		if i := strings.Index(p, "/"); i > 0 {
+			names[p[:i]] = true
+		}
+	}
+	if len(a.staging) > 0 {
+		files, err := ioutil.ReadDir(a.staging)
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range files {
+			if fi.IsDir() {
+				names[fi.Name()] = true
+			}
+		}
+	}
+
+	var layers []string
+	for name := range names {
+		layers = append(layers, name)
+	}
+	sort.Strings(layers)
+	return layers, nil
+}
+
+//OpenTileLayer opens the layer stored in the folder name of the archive for reading
+func (a *Archive) OpenTileLayer(name string) (raster.TileReader, error) {
+	layers, err := a.ListTileLayers()
+	if err != nil {
+		return nil, err
+	}
+	i := sort.SearchStrings(layers, name)
+	if i >= len(layers) || layers[i] != name {
+		return nil, raster.ErrLayerNotFund
+	}
+	return a.openLayer(name)
+}
+
+//CreateTileLayer creates a layer in the folder name of the archive, or opens it for writing
+func (a *Archive) CreateTileLayer(name string) (raster.TileReadWriter, error) {
+	if len(name) == 0 || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return nil, fmt.Errorf("Invalid archive layer name %q", name)
+	}
+
+	a.mu.Lock()
+	if len(a.staging) == 0 {
+		if err := a.recoverStaging(); err != nil {
+			a.mu.Unlock()
+			return nil, err
+		}
+	}
+	if len(a.staging) == 0 {
+		dir, err := ioutil.TempDir(filepath.Dir(a.path), tempPrefix+filepath.Base(a.path)+"-")
+		if err != nil {
+			a.mu.Unlock()
+			return nil, err
+		}
+		a.staging = dir
+	}
+	a.modified = true
+	a.mu.Unlock()
+
+	l, err := a.openLayer(name)
+	if err != nil {
+		return nil, err
+	}
+	folder, err := tilefolder.NewTileFolderLayout(a.stagedPath(name), l.format, l.layout)
+	if err != nil {
+		return nil, err
+	}
+	l.folder = &folder
+
+	if ok, err := a.containsFile(name + "/" + tilefolder.MetadataFile); err != nil || ok {
+		return l, err
+	}
+	if err := folder.WriteMetadata(); err != nil {
+		return nil, err
+	}
+	l.metadata = folder.Metadata()
+	return l, nil
+}
+
+//recoverStaging takes over the staging folders left next to the archive by interrupted writes, so that their tiles
+//are written when the archive is closed, and removes the temporary archives left by interrupted rewrites.
+//Several staging folders are merged into the first one.
+func (a *Archive) recoverStaging() error {
+	dir := filepath.Dir(a.path)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	prefix := tempPrefix + filepath.Base(a.path) + "-"
+	for _, fi := range files {
+		if !strings.HasPrefix(fi.Name(), prefix) {
+			continue
+		}
+		p := filepath.Join(dir, fi.Name())
+		switch {
+		case !fi.IsDir():
+			err = os.Remove(p)
+		case len(a.staging) == 0:
+			a.staging = p
+		default:
+			err = mergeDir(p, a.staging)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//mergeDir moves the files of the folder src missing from the folder dst into dst, then removes src
+func mergeDir(src, dst string) error {
+	err := filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if _, err := os.Stat(target); !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return os.Rename(p, target)
+	})
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+//openLayer opens the layer stored in the folder name, with the format and layout given as options,
+//read from its metadata file or detected from its tiles.
+func (a *Archive) openLayer(name string) (*Layer, error) {
+	l := &Layer{archive: a, name: name, format: a.format, layout: a.layout}
+
+	b, err := a.readFile(name + "/" + tilefolder.MetadataFile)
+	if err != nil {
+		return nil, err
+	}
+	if b != nil {
+		if err := json.Unmarshal(b, &l.metadata); err != nil {
+			return nil, fmt.Errorf("Invalid metadata of archive layer %s: %s", name, err)
+		}
+		if len(l.format) == 0 {
+			l.format = l.metadata.Format
+		}
+		if l.layout == nil && len(l.metadata.Layout) > 0 {
+			if l.layout, err = tilefolder.LayoutByName(l.metadata.Layout); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(l.format) == 0 || l.layout == nil {
+		a.mu.RLock()
+		for p := range a.index {
+			if !strings.HasPrefix(p, name+"/") {
+				continue
+			}
+			if format, layout, ok := tilefolder.DetectLayout(p[len(name)+1:]); ok {
+				if len(l.format) == 0 {
+					l.format = format
+				}
+				if l.layout == nil {
+					l.layout = layout
+				}
+				break
+			}
+		}
+		a.mu.RUnlock()
+	}
+
+	if len(l.format) == 0 {
+		l.format = "png"
+	}
+	if l.layout == nil {
+		l.layout = tilefolder.DefaultLayout
+	}
+	return l, nil
+}
+
+//Close writes the tiles stored since the archive was opened, if any, and closes the archive.
+//
+//The archive is rewritten to a temporary file renamed once complete, so that an interrupted write leaves the previous archive unchanged.
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var err error
+	if a.modified {
+		err = a.rewrite()
+		a.modified = false
+	}
+	if len(a.staging) > 0 {
+		os.RemoveAll(a.staging)
+		a.staging = ""
+	}
+	if closeErr := a.closeReaders(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+//closeReaders closes the readers of the archive
+func (a *Archive) closeReaders() error {
+	var err error
+	if a.zip != nil {
+		err = a.zip.Close()
+		a.zip = nil
+	}
+	if a.tar != nil {
+		err = a.tar.Close()
+		a.tar = nil
+	}
+	return err
+}
+
+//rewrite writes the files of the archive not replaced in the staging folder, then the staged files, to a new archive replacing the previous one
+func (a *Archive) rewrite() error {
+	tmp, err := ioutil.TempFile(filepath.Dir(a.path), tempPrefix+filepath.Base(a.path)+"-")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	err = a.write(tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		//Readers must be closed before replacing the file on some systems
+		err = a.closeReaders()
+	}
+	if err == nil {
+		err = os.Rename(tmpName, a.path)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+	}
+	return err
+}
+
+//write writes the content of the archive to w
+func (a *Archive) write(w io.Writer) error {
+	var aw archiveWriter
+	if a.kind == "zip" {
+		aw = zipWriter{zip.NewWriter(w)}
+	} else {
+		aw = tarWriter{tar.NewWriter(w)}
+	}
+
+	var names []string
+	for name := range a.index {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(a.staging, filepath.FromSlash(name))); err == nil {
+			continue
+		}
+		e := a.index[name]
+		b, err := a.read(e)
+		if err != nil {
+			return err
+		}
+		if err := aw.add(name, b, e.modTime); err != nil {
+			return err
+		}
+	}
+
+	if len(a.staging) > 0 {
+		err := filepath.Walk(a.staging, func(p string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || strings.HasPrefix(info.Name(), tempPrefix) {
+				return err
+			}
+			rel, err := filepath.Rel(a.staging, p)
+			if err != nil {
+				return err
+			}
+			b, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			return aw.add(filepath.ToSlash(rel), b, info.ModTime())
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return aw.Close()
+}
+
+//Layer is a tile folder of an Archive. It's safe for concurrent use by multiple goroutines.
+type Layer struct {
+	archive  *Archive
+	name     string
+	format   string
+	layout   tilefolder.Layout
+	metadata tilefolder.Metadata
+	folder   *tilefolder.TileFolder //Staging folder of the written tiles, nil if the layer is not opened for writing
+}
+
+//TileFormat exposes the image format of the source (png or jpg)
+func (l *Layer) TileFormat() string {
+	return l.format
+}
+
+//tilePath returns the path in the archive of the tile for a given level/x/y, or an empty string if the tile cannot be stored with the layout of the layer.
+func (l *Layer) tilePath(level, x, y int) string {
+	p, ok := l.layout.Path(level, x, y)
+	if !ok {
+		return ""
+	}
+	return l.name + "/" + p + "." + l.format
+}
+
+//GetRaw retrieves the tile for a given level/x/y.
+func (l *Layer) GetRaw(level, x, y int) ([]byte, error) {
+	p := l.tilePath(level, x, y)
+	if len(p) == 0 {
+		return nil, nil
+	}
+	return l.archive.readFile(p)
+}
+
+//Contains returns true if the reader already contains the tile for a given level/x/y
+func (l *Layer) Contains(level int, x, y int) (bool, error) {
+	p := l.tilePath(level, x, y)
+	if len(p) == 0 {
+		return false, nil
+	}
+	return l.archive.containsFile(p)
+}
+
+//writable returns an error if the layer was not opened with CreateTileLayer
+func (l *Layer) writable() error {
+	if l.folder == nil {
+		return fmt.Errorf("Archive layer %s is not opened for writing", l.name)
+	}
+	return nil
+}
+
+//SetRaw stores the tile for a given level/x/y. No check is performed on the image format.
+func (l *Layer) SetRaw(level, x, y int, img []byte) error {
+	if err := l.writable(); err != nil {
+		return err
+	}
+	return l.folder.SetRaw(level, x, y, img)
+}
+
+//Delete removes the tile for a given level/x/y.
+func (l *Layer) Delete(level, x, y int) error {
+	if err := l.writable(); err != nil {
+		return err
+	}
+	p := l.tilePath(level, x, y)
+	if len(p) == 0 {
+		return nil
+	}
+
+	l.archive.mu.Lock()
+	delete(l.archive.index, p)
+	l.archive.mu.Unlock()
+
+	return l.folder.Delete(level, x, y)
+}
+
+//Clear removes all stored tiles at a given level.
+func (l *Layer) Clear(level int) error {
+	if err := l.writable(); err != nil {
+		return err
+	}
+
+	prefix := l.name + "/"
+	dir := l.layout.LevelDir(level)
+	l.archive.mu.Lock()
+	for p := range l.archive.index {
+		if !strings.HasPrefix(p, prefix) || path.Ext(p) != "."+l.format {
+			continue
+		}
+		rel := p[len(prefix):]
+		if len(dir) > 0 && strings.HasPrefix(rel, dir+"/") {
+			delete(l.archive.index, p)
+		}
+		//Tiles of all levels in the same directory (quadkey layout): the level is the length of the file name
+		if len(dir) == 0 && !strings.Contains(rel, "/") && len(rel)-len(path.Ext(rel)) == level {
+			delete(l.archive.index, p)
+		}
+	}
+	l.archive.mu.Unlock()
+
+	return l.folder.Clear(level)
+}
+
+//TileJSON returns the description of the tiles of the metadata
+func (l *Layer) TileJSON() raster.TileJSON {
+	return l.metadata.TileJSON
+}
+
+//SetTileJSON writes the name, description, attribution, zoom range, bounds and center of tj to the metadata file of the layer.
+func (l *Layer) SetTileJSON(tj raster.TileJSON) error {
+	if err := l.writable(); err != nil {
+		return err
+	}
+	if err := l.folder.SetTileJSON(tj); err != nil {
+		return err
+	}
+	l.metadata = l.folder.Metadata()
+	return nil
+}
+
+//Attribution returns the attribution of the metadata
+func (l *Layer) Attribution() string {
+	return l.metadata.Attribution
+}
+
+//Bounds returns the bounds of the metadata, or the whole world if the metadata has no bounds
+func (l *Layer) Bounds() (geographic.BoundingBox, error) {
+	b := l.metadata.Bounds
+	if len(b) != 4 {
+		b = []float64{-180, -85.0511, 180, 85.0511}
+	}
+	return geographic.BoundingBox{
+		LongitudeMinDeg: b[0],
+		LatitudeMinDeg:  b[1],
+		LongitudeMaxDeg: b[2],
+		LatitudeMaxDeg:  b[3],
+	}, nil
+}
@@ -0,0 +1,232 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package archive
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xeonx/raster"
+)
+
+//openLayer opens an archive and one of its layers for writing
+func openLayer(t *testing.T, dataSourceName string, layer string) (*Archive, raster.TileReadWriter) {
+	source, err := raster.Open(raster.FindDriverName(dataSourceName), dataSourceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := source.(*Archive)
+	w, err := a.CreateTileLayer(layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, w
+}
+
+func TestArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"area.zip", "area.tar"} {
+		p := filepath.Join(dir, name)
+
+		//Creation
+		a, w := openLayer(t, p+"#layout=xyz", "osm")
+		for _, tile := range [][3]int{{1, 0, 0}, {1, 1, 1}, {2, 3, 1}} {
+			if err := w.SetRaw(tile[0], tile[1], tile[2], []byte("v1")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if b, err := w.GetRaw(2, 3, 1); err != nil || string(b) != "v1" {
+			t.Errorf("%s: GetRaw() before Close() => %s, %v", name, b, err)
+		}
+		if err := a.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		//Update: the layout is read from the metadata file
+		a, w = openLayer(t, p, "osm")
+		if err := w.Clear(1); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetRaw(1, 1, 1, []byte("v2")); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetRaw(2, 3, 1, []byte("v2")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.CreateTileLayer("other"); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		//Reading
+		source, err := raster.Open(raster.FindDriverName(p), p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		layers, err := source.ListTileLayers()
+		if err != nil || len(layers) != 2 || layers[0] != "osm" || layers[1] != "other" {
+			t.Errorf("%s: ListTileLayers() => %v, %v", name, layers, err)
+		}
+		r, err := source.OpenTileLayer("osm")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tc := range []struct {
+			level, x, y int
+			expected    string
+		}{
+			{1, 0, 0, ""},
+			{1, 1, 1, "v2"},
+			{2, 3, 1, "v2"},
+			{2, 0, 0, ""},
+		} {
+			b, err := r.GetRaw(tc.level, tc.x, tc.y)
+			ok, containsErr := r.Contains(tc.level, tc.x, tc.y)
+			if err != nil || containsErr != nil || string(b) != tc.expected || ok != (len(tc.expected) > 0) {
+				t.Errorf("%s: GetRaw(%d, %d, %d) => %s, %v, %v", name, tc.level, tc.x, tc.y, b, ok, err)
+			}
+		}
+		if _, err := source.OpenTileLayer("missing"); err != raster.ErrLayerNotFund {
+			t.Errorf("%s: OpenTileLayer() on a missing layer => %v", name, err)
+		}
+		if err := source.(*Archive).Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		//No temporary file left
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fi := range files {
+			if fi.Name() != "area.zip" && fi.Name() != "area.tar" {
+				t.Errorf("Unexpected file %s", fi.Name())
+			}
+		}
+	}
+}
+
+func TestDetectLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//Archive made by an other tool, without metadata file
+	p := filepath.Join(dir, "arcgis.zip")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, name := range []string{"layers/", "layers/conf.xml", "layers/L01/R00000000/C00000001.jpg"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(name))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	a, err := Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	r, err := raster.OpenTileLayerAt(a, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.TileFormat() != "jpg" {
+		t.Errorf("TileFormat() => %s", r.TileFormat())
+	}
+	if b, err := r.GetRaw(1, 1, 1); err != nil || string(b) != "layers/L01/R00000000/C00000001.jpg" {
+		t.Errorf("GetRaw() => %s, %v", b, err)
+	}
+	if err := r.(raster.TileReadWriter).SetRaw(1, 0, 0, nil); err == nil {
+		t.Error("SetRaw() should fail on a layer opened for reading")
+	}
+}
+
+func TestCanOpen(t *testing.T) {
+	for dsn, expected := range map[string]string{
+		"area.zip":            "zip",
+		"/data/AREA.TAR":      "tar",
+		"area.zip#format=jpg": "zip",
+		"area.tar.gz":         "",
+	} {
+		if got := raster.FindDriverName(dsn); got != expected {
+			t.Errorf("FindDriverName(%s) => %s, want %s", dsn, got, expected)
+		}
+	}
+	if _, err := Open("area.7z"); err == nil {
+		t.Error("Open() should fail on an unknown extension")
+	}
+}
+
+func TestRecoverStaging(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//Staging folders and temporary archive left by interrupted writes
+	for _, name := range []string{
+		tempPrefix + "area.zip-1/osm/1/0/0.png",
+		tempPrefix + "area.zip-2/osm/1/1/1.png",
+		tempPrefix + "area.zip-3",
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, _ := openLayer(t, filepath.Join(dir, "area.zip"), "osm")
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err = Open(filepath.Join(dir, "area.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	r, err := a.OpenTileLayer("osm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tile := range [][3]int{{1, 0, 0}, {1, 1, 1}} {
+		if ok, err := r.Contains(tile[0], tile[1], tile[2]); !ok || err != nil {
+			t.Errorf("Contains(%v) => %v, %v, want the recovered tile", tile, ok, err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("%d files left, want the archive only", len(files))
+	}
+}
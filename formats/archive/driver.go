@@ -0,0 +1,32 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package archive
+
+import (
+	"strings"
+
+	"github.com/xeonx/raster"
+)
+
+func init() {
+	raster.Register("zip", archiveDriver{kind: "zip"})
+	raster.Register("tar", archiveDriver{kind: "tar"})
+}
+
+type archiveDriver struct {
+	kind string
+}
+
+//CanOpen returns true for paths with the extension of the archive kind, even if the file does not exist yet
+func (d archiveDriver) CanOpen(dataSourceName string) bool {
+	if i := strings.LastIndex(dataSourceName, "#"); i >= 0 {
+		dataSourceName = dataSourceName[:i]
+	}
+	return kindOf(dataSourceName) == d.kind
+}
+
+func (d archiveDriver) OpenTileSource(dataSourceName string) (raster.TileSource, error) {
+	return open(dataSourceName, d.kind)
+}
@@ -0,0 +1,58 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"path"
+	"time"
+)
+
+//archiveWriter adds files to a new archive
+type archiveWriter interface {
+	add(name string, b []byte, modTime time.Time) error
+	Close() error
+}
+
+type zipWriter struct {
+	*zip.Writer
+}
+
+//add adds a file to the zip archive. Tiles, already compressed, are stored without compression for faster reads.
+func (w zipWriter) add(name string, b []byte, modTime time.Time) error {
+	method := zip.Deflate
+	switch path.Ext(name) {
+	case ".png", ".jpg":
+		method = zip.Store
+	}
+
+	f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: modTime})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	return err
+}
+
+type tarWriter struct {
+	*tar.Writer
+}
+
+//add adds a file to the tar archive
+func (w tarWriter) add(name string, b []byte, modTime time.Time) error {
+	err := w.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(b)),
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
@@ -0,0 +1,33 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package georefimage
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/xeonx/raster"
+)
+
+func init() {
+	raster.Register("image", imageDriver{})
+}
+
+type imageDriver struct {
+}
+
+func (d imageDriver) OpenTileSource(dataSourceName string) (raster.TileSource, error) {
+	return Open(dataSourceName)
+}
+
+func (d imageDriver) CanOpen(dataSourceName string) bool {
+	switch strings.ToLower(filepath.Ext(dataSourceName)) {
+	case ".tif", ".tiff":
+		return true
+	case ".png", ".jpg", ".jpeg":
+		return findWorldFile(dataSourceName) != ""
+	}
+	return false
+}
@@ -0,0 +1,410 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+/*
+Package georefimage provides a tile source cutting a large georeferenced image into web mercator tiles on demand.
+
+Supported images are GeoTIFF files, and PNG or JPEG files having a world file (.pgw, .jgw, .pngw, .jpgw or .wld).
+The coordinate reference system of the image must be EPSG:3857 (web mercator) or EPSG:4326 (WGS 84). For world files,
+it is read from the .prj file if available, and guessed from the magnitude of the coordinates otherwise.
+
+The whole image is loaded in memory.
+*/
+package georefimage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg" //Register image decoder
+	_ "image/png"  //Register image decoder
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/xeonx/geographic"
+	"github.com/xeonx/raster"
+	"github.com/xeonx/raster/geotiff"
+)
+
+//maxSamples is the maximum number of samples per side of an output pixel when downsampling
+const maxSamples = 4
+
+//WorldFile contains the 6 parameters of the affine transformation from pixel (column, row) to map coordinates (x, y):
+//
+//	x = A*column + B*row + C
+//	y = D*column + E*row + F
+//
+//where C and F are the coordinates of the center of the top-left pixel.
+type WorldFile struct {
+	A, D, B, E, C, F float64
+}
+
+//ReadWorldFile reads a world file
+func ReadWorldFile(filename string) (WorldFile, error) {
+	var w WorldFile
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return w, err
+	}
+	defer f.Close()
+
+	var values []float64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return w, fmt.Errorf("Invalid world file %s: %s", filename, err)
+		}
+		values = append(values, v)
+	}
+	if err := scanner.Err(); err != nil {
+		return w, err
+	}
+	if len(values) != 6 {
+		return w, fmt.Errorf("Invalid world file %s: 6 values expected, %d found", filename, len(values))
+	}
+
+	w.A, w.D, w.B, w.E, w.C, w.F = values[0], values[1], values[2], values[3], values[4], values[5]
+	return w, nil
+}
+
+//worldFileExts lists the world file extensions for each image extension
+var worldFileExts = map[string][]string{
+	".png":  {".pgw", ".pngw", ".wld"},
+	".jpg":  {".jgw", ".jpgw", ".wld"},
+	".jpeg": {".jgw", ".jpgw", ".wld"},
+}
+
+//findWorldFile returns the world file of an image, or an empty string if not found
+func findWorldFile(filename string) string {
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	for _, wext := range worldFileExts[strings.ToLower(ext)] {
+		for _, candidate := range []string{base + wext, base + strings.ToUpper(wext)} {
+			if _, err := os.Stat(candidate); err == nil {
+				return candidate
+			}
+		}
+	}
+	return ""
+}
+
+//guessEPSG determines the coordinate reference system of an image georeferenced by a world file,
+//using its .prj file if available, or the magnitude of the coordinates.
+func guessEPSG(filename string, w WorldFile) int {
+	prj, err := ioutil.ReadFile(strings.TrimSuffix(filename, filepath.Ext(filename)) + ".prj")
+	if err == nil {
+		wkt := strings.ToUpper(string(prj))
+		if strings.HasPrefix(strings.TrimSpace(wkt), "GEOGCS") {
+			return geotiff.EPSG4326
+		}
+		if strings.Contains(wkt, "MERCATOR") {
+			return geotiff.EPSG3857
+		}
+	}
+
+	if math.Abs(w.C) > 360 || math.Abs(w.F) > 90 {
+		return geotiff.EPSG3857
+	}
+	return geotiff.EPSG4326
+}
+
+//Image is a TileReader providing web mercator tiles cut from a georeferenced image.
+type Image struct {
+	name string
+	img  *image.NRGBA
+	epsg int
+	w    WorldFile
+
+	//Inverse of the linear part of the world file
+	invA, invB, invD, invE float64
+
+	//Extent in web mercator meters
+	minX, minY, maxX, maxY float64
+}
+
+//Open opens a GeoTIFF image, or a PNG or JPEG image having a world file.
+func Open(filename string) (*Image, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if ext == ".tif" || ext == ".tiff" {
+		img, ref, err := geotiff.Decode(f)
+		if err != nil {
+			return nil, err
+		}
+		w := WorldFile{
+			A: ref.PixelSizeX,
+			E: -ref.PixelSizeY,
+			C: ref.OriginX + ref.PixelSizeX/2,
+			F: ref.OriginY - ref.PixelSizeY/2,
+		}
+		return New(filepath.Base(filename), img, w, ref.EPSG)
+	}
+
+	worldFile := findWorldFile(filename)
+	if worldFile == "" {
+		return nil, errors.New("No world file found for " + filename)
+	}
+	w, err := ReadWorldFile(worldFile)
+	if err != nil {
+		return nil, err
+	}
+	//Check the size before allocating the pixels, as for GeoTIFF images
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	if float64(config.Width)*float64(config.Height) > float64(geotiff.MaxPixels) {
+		return nil, fmt.Errorf("Image %s too large: %dx%d pixels, the maximum is %d pixels", filename, config.Width, config.Height, geotiff.MaxPixels)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	return New(filepath.Base(filename), img, w, guessEPSG(filename, w))
+}
+
+//New creates an Image from an image georeferenced by a world file in the given coordinate reference system (3857 or 4326).
+func New(name string, img image.Image, w WorldFile, epsg int) (*Image, error) {
+	if epsg != geotiff.EPSG3857 && epsg != geotiff.EPSG4326 {
+		return nil, fmt.Errorf("Unsupported coordinate reference system EPSG:%d. Only 3857 and 4326 allowed.", epsg)
+	}
+	det := w.A*w.E - w.B*w.D
+	if det == 0 {
+		return nil, errors.New("Invalid world file: not invertible")
+	}
+
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok || nrgba.Bounds().Min != image.ZP {
+		nrgba = image.NewNRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+		draw.Draw(nrgba, nrgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+
+	m := &Image{
+		name: name,
+		img:  nrgba,
+		epsg: epsg,
+		w:    w,
+		invA: w.E / det,
+		invB: -w.B / det,
+		invD: -w.D / det,
+		invE: w.A / det,
+		minX: math.Inf(1),
+		minY: math.Inf(1),
+		maxX: math.Inf(-1),
+		maxY: math.Inf(-1),
+	}
+
+	//Extent from the corners of the image
+	width, height := float64(nrgba.Bounds().Dx()), float64(nrgba.Bounds().Dy())
+	for _, corner := range [][2]float64{{0, 0}, {width, 0}, {0, height}, {width, height}} {
+		col, row := corner[0]-0.5, corner[1]-0.5
+		x, y := m.toMeters(w.A*col+w.B*row+w.C, w.D*col+w.E*row+w.F)
+		m.minX, m.maxX = math.Min(m.minX, x), math.Max(m.maxX, x)
+		m.minY, m.maxY = math.Min(m.minY, y), math.Max(m.maxY, y)
+	}
+
+	return m, nil
+}
+
+//toMeters transforms coordinates of the image reference system into web mercator meters
+func (m *Image) toMeters(x, y float64) (float64, float64) {
+	if m.epsg == geotiff.EPSG4326 {
+		return raster.Lon2Meters(x), raster.Lat2Meters(math.Max(-85.0511287798, math.Min(85.0511287798, y)))
+	}
+	return x, y
+}
+
+//fromMeters transforms web mercator meters into coordinates of the image reference system
+func (m *Image) fromMeters(x, y float64) (float64, float64) {
+	if m.epsg == geotiff.EPSG4326 {
+		return raster.Meters2Lon(x), raster.Meters2Lat(y)
+	}
+	return x, y
+}
+
+//toPixel transforms coordinates of the image reference system into continuous pixel coordinates,
+//where (0, 0) is the top-left corner of the image.
+func (m *Image) toPixel(x, y float64) (float64, float64) {
+	dx, dy := x-m.w.C, y-m.w.F
+	return m.invA*dx + m.invB*dy + 0.5, m.invD*dx + m.invE*dy + 0.5
+}
+
+//Name returns the name of the image
+func (m *Image) Name() string {
+	return m.name
+}
+
+//TileFormat exposes the image format of the source (png or jpg)
+func (m *Image) TileFormat() string {
+	return "png"
+}
+
+//TileSize returns the width and height in pixels of the tiles
+func (m *Image) TileSize() int {
+	return raster.DefaultTileSize
+}
+
+//Bounds returns the area covered by the image
+func (m *Image) Bounds() (geographic.BoundingBox, error) {
+	return geographic.BoundingBox{
+		LongitudeMinDeg: raster.Meters2Lon(m.minX),
+		LongitudeMaxDeg: raster.Meters2Lon(m.maxX),
+		LatitudeMinDeg:  raster.Meters2Lat(m.minY),
+		LatitudeMaxDeg:  raster.Meters2Lat(m.maxY),
+	}, nil
+}
+
+//tileExtent returns the extent in web mercator meters of a tile, and the size in meters of its pixels
+func tileExtent(level, x, y int) (minX, maxY, pixelSize float64) {
+	worldSize := raster.Lon2Meters(360)
+	tileMeters := worldSize / float64(int(1)<<uint(level))
+	yosm := (1 << uint(level)) - y - 1
+	return float64(x)*tileMeters - worldSize/2, worldSize/2 - float64(yosm)*tileMeters, tileMeters / raster.DefaultTileSize
+}
+
+//Contains returns true if the tile for a given level/x/y intersects the image
+func (m *Image) Contains(level int, x, y int) (bool, error) {
+	minX, maxY, pixelSize := tileExtent(level, x, y)
+	maxX, minY := minX+pixelSize*raster.DefaultTileSize, maxY-pixelSize*raster.DefaultTileSize
+	return minX < m.maxX && maxX > m.minX && minY < m.maxY && maxY > m.minY, nil
+}
+
+//GetRaw retrieves the tile for a given level/x/y. It returns nil if the tile does not intersect the image.
+func (m *Image) GetRaw(level, x, y int) ([]byte, error) {
+	if ok, _ := m.Contains(level, x, y); !ok {
+		return nil, nil
+	}
+
+	minX, maxY, pixelSize := tileExtent(level, x, y)
+
+	//Supersample when an output pixel covers several pixels of the image
+	imagePixelSize := math.Hypot(m.w.A, m.w.D)
+	if m.epsg == geotiff.EPSG4326 {
+		imagePixelSize = raster.Lon2Meters(imagePixelSize)
+	}
+	samples := int(math.Ceil(pixelSize / imagePixelSize))
+	if samples < 1 {
+		samples = 1
+	}
+	if samples > maxSamples {
+		samples = maxSamples
+	}
+
+	size := raster.DefaultTileSize
+	tile := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for py := 0; py < size; py++ {
+		for px := 0; px < size; px++ {
+			var r, g, b, a, count float64
+			for sy := 0; sy < samples; sy++ {
+				for sx := 0; sx < samples; sx++ {
+					mx := minX + (float64(px)+(float64(sx)+0.5)/float64(samples))*pixelSize
+					my := maxY - (float64(py)+(float64(sy)+0.5)/float64(samples))*pixelSize
+					c, ok := m.sample(m.toPixel(m.fromMeters(mx, my)))
+					count++
+					if !ok {
+						continue
+					}
+					//Average premultiplied values to avoid dark borders
+					alpha := float64(c.A)
+					r += float64(c.R) * alpha
+					g += float64(c.G) * alpha
+					b += float64(c.B) * alpha
+					a += alpha
+				}
+			}
+			if a == 0 {
+				continue
+			}
+			tile.SetNRGBA(px, py, color.NRGBA{
+				R: uint8(r/a + 0.5),
+				G: uint8(g/a + 0.5),
+				B: uint8(b/a + 0.5),
+				A: uint8(a/count + 0.5),
+			})
+		}
+	}
+
+	return raster.Encode(tile, "png")
+}
+
+//sample returns the color of the image at continuous pixel coordinates, using bilinear interpolation.
+//It returns false if the coordinates are outside of the image.
+func (m *Image) sample(col, row float64) (color.NRGBA, bool) {
+	bounds := m.img.Bounds()
+	if col < 0 || row < 0 || col >= float64(bounds.Dx()) || row >= float64(bounds.Dy()) {
+		return color.NRGBA{}, false
+	}
+
+	//Pixel centers are at .5
+	fx, fy := col-0.5, row-0.5
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := fx-float64(x0), fy-float64(y0)
+
+	clamp := func(v, max int) int {
+		if v < 0 {
+			return 0
+		}
+		if v >= max {
+			return max - 1
+		}
+		return v
+	}
+	x1, y1 := clamp(x0+1, bounds.Dx()), clamp(y0+1, bounds.Dy())
+	x0, y0 = clamp(x0, bounds.Dx()), clamp(y0, bounds.Dy())
+
+	c00, c10 := m.img.NRGBAAt(x0, y0), m.img.NRGBAAt(x1, y0)
+	c01, c11 := m.img.NRGBAAt(x0, y1), m.img.NRGBAAt(x1, y1)
+	lerp := func(v00, v10, v01, v11 uint8) uint8 {
+		top := float64(v00)*(1-tx) + float64(v10)*tx
+		bottom := float64(v01)*(1-tx) + float64(v11)*tx
+		return uint8(top*(1-ty) + bottom*ty + 0.5)
+	}
+
+	return color.NRGBA{
+		R: lerp(c00.R, c10.R, c01.R, c11.R),
+		G: lerp(c00.G, c10.G, c01.G, c11.G),
+		B: lerp(c00.B, c10.B, c01.B, c11.B),
+		A: lerp(c00.A, c10.A, c01.A, c11.A),
+	}, true
+}
+
+//ListTileLayers list all available tile layers
+//
+//As an image consist of a single layer, the returned array wil have a lenght of 1.
+func (m *Image) ListTileLayers() ([]string, error) {
+	return []string{m.name}, nil
+}
+
+//OpenTileLayer opens the tile layer for reading
+//
+//As an image consist of a single layer, the same layer will be returned or raster.ErrLayerNotFund will be raised.
+func (m *Image) OpenTileLayer(name string) (raster.TileReader, error) {
+	if name == m.name {
+		return m, nil
+	}
+	return nil, raster.ErrLayerNotFund
+}
@@ -0,0 +1,142 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package georefimage
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xeonx/raster"
+	"github.com/xeonx/raster/geotiff"
+)
+
+func TestImageWebMercator(t *testing.T) {
+	//World at level 1: red top-left quarter, blue elsewhere
+	img := image.NewNRGBA(image.Rect(0, 0, 512, 512))
+	for y := 0; y < 512; y++ {
+		for x := 0; x < 512; x++ {
+			c := color.NRGBA{0, 0, 255, 255}
+			if x < 256 && y < 256 {
+				c = color.NRGBA{255, 0, 0, 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	half := raster.Lon2Meters(180)
+	ps := 2 * half / 512
+	m, err := New("world", img, WorldFile{A: ps, E: -ps, C: -half + ps/2, F: half - ps/2}, geotiff.EPSG3857)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		x, y int
+		c    color.NRGBA
+	}{
+		{0, 1, color.NRGBA{255, 0, 0, 255}},
+		{1, 1, color.NRGBA{0, 0, 255, 255}},
+		{0, 0, color.NRGBA{0, 0, 255, 255}},
+	} {
+		raw, err := m.GetRaw(1, tt.x, tt.y)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tile, err := raster.Decode(raw, "png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tile.Bounds().Dx() != 256 {
+			t.Errorf("GetRaw(1, %d, %d) => %v, want 256x256", tt.x, tt.y, tile.Bounds())
+		}
+		if got := color.NRGBAModel.Convert(tile.At(128, 128)); got != tt.c {
+			t.Errorf("GetRaw(1, %d, %d) => %v, want %v", tt.x, tt.y, got, tt.c)
+		}
+	}
+
+	bbox, _ := m.Bounds()
+	if math.Abs(bbox.LongitudeMinDeg+180) > 1e-9 || math.Abs(bbox.LatitudeMaxDeg-85.0511287798) > 1e-6 {
+		t.Errorf("Bounds() => %+v", bbox)
+	}
+}
+
+func TestImageGeographic(t *testing.T) {
+	//10 x 10 degrees green square at 0,0
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{0, 255, 0, 255})
+		}
+	}
+	m, err := New("square", img, WorldFile{A: 0.1, E: -0.1, C: 0.05, F: 9.95}, geotiff.EPSG4326)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bbox, _ := m.Bounds()
+	if math.Abs(bbox.LongitudeMinDeg) > 1e-9 || math.Abs(bbox.LongitudeMaxDeg-10) > 1e-9 ||
+		math.Abs(bbox.LatitudeMinDeg) > 1e-9 || math.Abs(bbox.LatitudeMaxDeg-10) > 1e-9 {
+		t.Errorf("Bounds() => %+v", bbox)
+	}
+
+	level := 6
+	x, y := raster.Lon2X(level, 5), raster.Lat2Y(level, 5)
+	if ok, _ := m.Contains(level, x, y); !ok {
+		t.Errorf("Contains(%d, %d, %d) => false, want true", level, x, y)
+	}
+	raw, err := m.GetRaw(level, x, y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tile, err := raster.Decode(raw, "png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := color.NRGBAModel.Convert(tile.At(128, 128)); got != (color.NRGBA{0, 255, 0, 255}) {
+		t.Errorf("GetRaw(%d, %d, %d) => %v, want green", level, x, y, got)
+	}
+
+	x, y = raster.Lon2X(level, -50), raster.Lat2Y(level, 5)
+	if ok, _ := m.Contains(level, x, y); ok {
+		t.Errorf("Contains(%d, %d, %d) => true, want false", level, x, y)
+	}
+	if raw, err := m.GetRaw(level, x, y); raw != nil || err != nil {
+		t.Errorf("GetRaw(%d, %d, %d) => %v, %v, want nil", level, x, y, raw, err)
+	}
+}
+
+func TestOpenMaxPixels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "georefimage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b, err := raster.Encode(image.NewNRGBA(image.Rect(0, 0, 64, 32)), "png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "image.png")
+	if err := ioutil.WriteFile(filename, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "image.pgw"), []byte("0.1\n0\n0\n-0.1\n2.05\n48.95\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(maxPixels int) { geotiff.MaxPixels = maxPixels }(geotiff.MaxPixels)
+	geotiff.MaxPixels = 64*32 - 1
+	if _, err := Open(filename); err == nil {
+		t.Error("Open() should fail on an image larger than MaxPixels")
+	}
+}
@@ -296,6 +296,16 @@ func (t tileContent) TileFormat() string {
 	return "jpg"
 }
 
+//TileSize returns the width and height in pixels of the tiles, as defined in the tile matrix of the lowest zoom level
+func (t tileContent) TileSize() int {
+	var width int
+	err := t.h.db.QueryRow("SELECT tile_width FROM gpkg_tile_matrix WHERE table_name=? ORDER BY zoom_level LIMIT 1", t.name).Scan(&width)
+	if err != nil {
+		return raster.DefaultTileSize
+	}
+	return width
+}
+
 //GetRaw retrieves the tile for a given level/x/y.
 func (t tileContent) GetRaw(level, x, y int) ([]byte, error) {
 
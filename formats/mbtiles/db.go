@@ -7,6 +7,8 @@ package mbtiles
 import (
 	"database/sql"
 	"image"
+	"sync"
+	"time"
 
 	"github.com/xeonx/raster"
 )
@@ -18,6 +20,7 @@ type Metadata struct {
 	Version     int
 	Description string
 	Format      string //png or jpg
+	TileSize    int    //Width and height in pixels of the tiles, stored in the non standard tilesize item (256 if not set)
 	//TODO Bounds
 	Attribution string
 	//TODO UTFGrid keys
@@ -27,8 +30,19 @@ type Metadata struct {
 type DB struct {
 	db       *sql.DB
 	metadata Metadata
+
+	infoMu  sync.Mutex
+	hasInfo bool //true if the tiles_info table exists
 }
 
+//tilesInfoSchema creates the table recording the modification time (in Unix seconds) and the ETag of each tile.
+//This table is an extension to the MBTiles specification, only created when tile information is first written
+//so that databases opened for reading are never modified.
+const tilesInfoSchema = `
+	CREATE TABLE IF NOT EXISTS tiles_info (zoom_level integer, tile_column integer, tile_row integer, mtime integer, etag text);
+	CREATE UNIQUE INDEX IF NOT EXISTS tiles_info_idx ON tiles_info (zoom_level, tile_column, tile_row);
+`
+
 func hasTable(db *sql.DB, tableName string) bool {
 	var count int
 	err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE name=?", tableName).Scan(&count)
@@ -68,6 +82,8 @@ func Open(filepath string) (*DB, error) {
 		}
 	}
 
+	mbtilesDb.hasInfo = hasTable(db, "tiles_info")
+
 	//Read metadata
 	m := map[string]interface{}{
 		"name":        &mbtilesDb.metadata.Name,
@@ -76,6 +92,7 @@ func Open(filepath string) (*DB, error) {
 		"description": &mbtilesDb.metadata.Description,
 		"format":      &mbtilesDb.metadata.Format,
 		"attribution": &mbtilesDb.metadata.Attribution,
+		"tilesize":    &mbtilesDb.metadata.TileSize,
 	}
 	for k, v := range m {
 		err = mbtilesDb.readMetadata(k, v)
@@ -92,6 +109,9 @@ func Open(filepath string) (*DB, error) {
 	if mbtilesDb.metadata.Format == "" {
 		mbtilesDb.metadata.Format = "png"
 	}
+	if mbtilesDb.metadata.TileSize <= 0 {
+		mbtilesDb.metadata.TileSize = raster.DefaultTileSize
+	}
 
 	return mbtilesDb, nil
 }
@@ -111,7 +131,7 @@ func Create(filepath string, metadata Metadata) (*DB, error) {
 		CREATE TABLE metadata (name text, value text);
 		CREATE TABLE tiles (zoom_level integer, tile_column integer, tile_row integer, tile_data blob);
 		CREATE INDEX tiles_idx ON tiles (zoom_level, tile_column, tile_row);
-	`)
+	` + tilesInfoSchema)
 	if err != nil {
 		mbtilesDb.Close()
 		return nil, err
@@ -126,6 +146,9 @@ func Create(filepath string, metadata Metadata) (*DB, error) {
 		"format":      metadata.Format,
 		"attribution": metadata.Attribution,
 	}
+	if metadata.TileSize > 0 && metadata.TileSize != raster.DefaultTileSize {
+		m["tilesize"] = metadata.TileSize
+	}
 	for k, v := range m {
 		err = mbtilesDb.saveMetadata(k, v)
 		if err != nil {
@@ -134,7 +157,11 @@ func Create(filepath string, metadata Metadata) (*DB, error) {
 		}
 	}
 
+	if metadata.TileSize <= 0 {
+		metadata.TileSize = raster.DefaultTileSize
+	}
 	mbtilesDb.metadata = metadata
+	mbtilesDb.hasInfo = true
 
 	return mbtilesDb, nil
 }
@@ -154,6 +181,23 @@ func (m *DB) Close() error {
 	return nil
 }
 
+//TileSize returns the width and height in pixels of the tiles
+func (m *DB) TileSize() int {
+	return m.metadata.TileSize
+}
+
+//SetTileSize stores the width and height in pixels of the tiles in the tilesize metadata item
+func (m *DB) SetTileSize(size int) error {
+	if _, err := m.db.Exec("DELETE FROM metadata WHERE name = 'tilesize'"); err != nil {
+		return err
+	}
+	if err := m.saveMetadata("tilesize", size); err != nil {
+		return err
+	}
+	m.metadata.TileSize = size
+	return nil
+}
+
 //Metadata retrieves the metadata stored into a MBTiles database.
 func (m *DB) Metadata() Metadata {
 	return m.metadata
@@ -217,10 +261,95 @@ func (m *DB) Contains(level int, x, y int) (bool, error) {
 	return true, nil
 }
 
-//SetRaw stores the tile for a given level/x/y. No check is performed on the image format.
+//SetRaw stores the tile for a given level/x/y, replacing the existing one. No check is performed on the image format.
 func (m *DB) SetRaw(level int, x, y int, img []byte) error {
+	return m.SetRawInfo(level, x, y, img, raster.TileInfo{ETag: raster.ContentETag(img)})
+}
+
+//SetRawInfo stores the tile for a given level/x/y with its ETag, replacing the existing one. A zero ModTime means the current time.
+func (m *DB) SetRawInfo(level int, x, y int, img []byte, info raster.TileInfo) error {
+	mtime := info.ModTime
+	if mtime.IsZero() {
+		mtime = time.Now()
+	}
+	if err := m.createInfo(); err != nil {
+		return err
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM tiles WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?", level, x, y)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO tiles (zoom_level, tile_column, tile_row, tile_data) VALUES ( ? , ? , ? , ? )", level, x, y, img)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("INSERT OR REPLACE INTO tiles_info (zoom_level, tile_column, tile_row, mtime, etag) VALUES ( ? , ? , ? , ? , ? )", level, x, y, mtime.Unix(), info.ETag)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
-	_, err := m.db.Exec("INSERT INTO tiles (zoom_level, tile_column, tile_row, tile_data) VALUES ( ? , ? , ? , ? )", level, x, y, img)
+	return tx.Commit()
+}
+
+//infoExists returns true if the tiles_info table exists
+func (m *DB) infoExists() bool {
+	m.infoMu.Lock()
+	defer m.infoMu.Unlock()
+	return m.hasInfo
+}
+
+//createInfo creates the tiles_info table if it does not exist yet
+func (m *DB) createInfo() error {
+	m.infoMu.Lock()
+	defer m.infoMu.Unlock()
+	if m.hasInfo {
+		return nil
+	}
+	if _, err := m.db.Exec(tilesInfoSchema); err != nil {
+		return err
+	}
+	m.hasInfo = true
+	return nil
+}
+
+//TileInfo retrieves the modification time and ETag of the tile for a given level/x/y.
+//It returns false for tiles written without information, such as the ones written by other tools.
+func (m *DB) TileInfo(level int, x, y int) (raster.TileInfo, bool, error) {
+	if !m.infoExists() {
+		return raster.TileInfo{}, false, nil
+	}
+	var mtime int64
+	var etag string
+	err := m.db.QueryRow("SELECT mtime, etag FROM tiles_info WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?", level, x, y).Scan(&mtime, &etag)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return raster.TileInfo{}, false, nil
+		}
+		return raster.TileInfo{}, false, err
+	}
+
+	return raster.TileInfo{ModTime: time.Unix(mtime, 0), ETag: etag}, true, nil
+}
+
+//Delete removes the tile for a given level/x/y
+func (m *DB) Delete(level int, x, y int) error {
+	_, err := m.db.Exec("DELETE FROM tiles WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?", level, x, y)
+	if err != nil {
+		return err
+	}
+	if !m.infoExists() {
+		return nil
+	}
+	_, err = m.db.Exec("DELETE FROM tiles_info WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?", level, x, y)
 
 	return err
 }
@@ -239,6 +368,13 @@ func (m *DB) Set(level int, x, y int, img image.Image) error {
 //Clear removes all tiles for a given layer
 func (m *DB) Clear(level int) error {
 	_, err := m.db.Exec("DELETE FROM tiles WHERE zoom_level = ?;", level)
+	if err != nil {
+		return err
+	}
+	if !m.infoExists() {
+		return nil
+	}
+	_, err = m.db.Exec("DELETE FROM tiles_info WHERE zoom_level = ?;", level)
 
 	return err
 }
@@ -271,3 +407,18 @@ func (m *DB) CreateTileLayer(name string) (raster.TileReadWriter, error) {
 	}
 	return nil, raster.ErrLayerNotFund
 }
+
+//Attribution returns the attribution of the metadata
+func (m *DB) Attribution() string {
+	return m.metadata.Attribution
+}
+
+//LevelRange returns the minimum and maximum zoom levels of the stored tiles, or 0 and 0 if no tile is stored.
+func (m *DB) LevelRange() (int, int, error) {
+	var levelMin, levelMax sql.NullInt64
+	err := m.db.QueryRow("SELECT MIN(zoom_level), MAX(zoom_level) FROM tiles").Scan(&levelMin, &levelMax)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(levelMin.Int64), int(levelMax.Int64), nil
+}
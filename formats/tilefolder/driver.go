@@ -5,24 +5,82 @@
 package tilefolder
 
 import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
 	"os"
 	"path"
-	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/xeonx/raster"
 )
 
 func init() {
-	raster.Register("folder", tileFolderDriver{format: "png"})
+	raster.Register("folder", tileFolderDriver{})
 	raster.Register("jpgfolder", tileFolderDriver{format: "jpg"})
 }
 
+//tileFolderDriver opens folders of tiles. An empty format is read from the metadata file of each layer, or detected.
 type tileFolderDriver struct {
 	format string
 }
 
+//parseDataSourceName separates the folder from the options given as a fragment: /path/to/cache#layout=xyz&format=jpg&sync=file&recover
+//The format and the layout are left empty when not given.
+func parseDataSourceName(dataSourceName string) (tileFolderSource, error) {
+	s := tileFolderSource{dataSourceName: dataSourceName}
+	i := strings.LastIndex(dataSourceName, "#")
+	if i < 0 {
+		return s, nil
+	}
+	s.dataSourceName = dataSourceName[:i]
+
+	options, err := url.ParseQuery(dataSourceName[i+1:])
+	if err != nil {
+		return s, fmt.Errorf("Invalid tile folder options: %s", err)
+	}
+	for key, values := range options {
+		value := values[len(values)-1]
+		switch key {
+		case "layout":
+			s.layout, err = LayoutByName(value)
+			if err != nil {
+				return s, err
+			}
+		case "format":
+			if _, ok := tileFormats["."+value]; !ok {
+				return s, fmt.Errorf("Unknown tile folder format %s", value)
+			}
+			s.format = value
+		case "sync":
+			sync, ok := syncModes[value]
+			if !ok {
+				return s, fmt.Errorf("Unknown tile folder sync mode %s", value)
+			}
+			s.sync = sync
+		case "recover":
+			s.recover = len(value) == 0
+			if !s.recover {
+				s.recover, err = strconv.ParseBool(value)
+				if err != nil {
+					return s, fmt.Errorf("Invalid tile folder recover option %s", value)
+				}
+			}
+		default:
+			return s, fmt.Errorf("Unknown tile folder option %s", key)
+		}
+	}
+	return s, nil
+}
+
 func (d tileFolderDriver) CanOpen(dataSourceName string) bool {
-	s, err := os.Stat(dataSourceName)
+	source, err := parseDataSourceName(dataSourceName)
+	if err != nil {
+		return false
+	}
+
+	s, err := os.Stat(source.dataSourceName)
 	if err != nil {
 		return false
 	}
@@ -34,36 +92,61 @@ func (d tileFolderDriver) CanOpen(dataSourceName string) bool {
 	return true
 }
 func (d tileFolderDriver) OpenTileSource(dataSourceName string) (raster.TileSource, error) {
-	return tileFolderSource{dataSourceName: dataSourceName, format: d.format}, nil
+	s, err := parseDataSourceName(dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.format) > 0 {
+		s.format = d.format
+	}
+	return s, nil
 }
 
+//tileFolderSource is a folder of layers. An empty format or a nil layout is read from the metadata file of each layer, or detected.
 type tileFolderSource struct {
 	dataSourceName string
 	format         string
+	layout         Layout
+	sync           Sync
+	recover        bool //Remove the temporary files left over by interrupted writes when a layer is opened for writing
 }
 
+//ListTileLayers lists the sub-folders
 func (s tileFolderSource) ListTileLayers() ([]string, error) {
-	var layers []string
-
-	err := filepath.Walk(s.dataSourceName, func(path string, info os.FileInfo, err error) error {
-
-		if info.IsDir() {
-			layers = append(layers, info.Name())
-			return filepath.SkipDir
-		}
-
-		return nil
-	})
-
+	files, err := ioutil.ReadDir(s.dataSourceName)
 	if err != nil {
 		return nil, err
 	}
 
+	var layers []string
+	for _, fi := range files {
+		if fi.IsDir() && !strings.HasPrefix(fi.Name(), ".") {
+			layers = append(layers, fi.Name())
+		}
+	}
 	return layers, nil
 }
+
+//OpenTileLayer opens the sub-folder name for reading
 func (s tileFolderSource) OpenTileLayer(name string) (raster.TileReader, error) {
-	return NewTileFolder(path.Join(s.dataSourceName, name), s.format)
+	return openTileFolder(path.Join(s.dataSourceName, name), s.format, s.layout)
 }
+
+//CreateTileLayer opens the sub-folder name for writing, writing its metadata file if it has none.
+//With the recover option, the temporary files left over by interrupted writes are removed first.
 func (s tileFolderSource) CreateTileLayer(name string) (raster.TileReadWriter, error) {
-	return NewTileFolder(path.Join(s.dataSourceName, name), s.format)
+	f, err := openTileFolder(path.Join(s.dataSourceName, name), s.format, s.layout)
+	if err != nil {
+		return nil, err
+	}
+	f = f.WithSync(s.sync)
+	if s.recover {
+		if _, err := f.RemoveTemp(TempMaxAge); err != nil {
+			return nil, err
+		}
+	}
+	if err := f.WriteMetadata(); err != nil {
+		return nil, err
+	}
+	return f, nil
 }
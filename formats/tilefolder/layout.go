@@ -0,0 +1,142 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package tilefolder
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//Layout describes where the tiles are stored in the folder of a layer.
+type Layout interface {
+	//Path returns the path of the tile for a given level/x/y relative to the folder, without extension.
+	//It returns false if the tile cannot be stored with this layout.
+	Path(level, x, y int) (string, bool)
+	//LevelDir returns the directory relative to the folder containing all the tiles of a level,
+	//or an empty string if the tiles of a level are not grouped in a directory.
+	LevelDir(level int) string
+}
+
+//Layouts are the available layouts, by name:
+//
+//	tms:     level/x/y with y=0 at the bottom (the default)
+//	xyz:     level/x/y with y=0 at the top
+//	zyx:     level/y/x with y=0 at the top
+//	quadkey: quadkey, all levels in the same directory (level 0 cannot be stored)
+//	tc:      TileCache sharded layout, 00/000/000/000/000/000/000 (level, x and y split in groups of 3 digits), with y=0 at the bottom
+//	mp:      MapProxy sharded layout, 00/0000/0000/0000/0000 (level, x and y split in groups of 4 digits), with y=0 at the bottom
+//	hashed:  level/ab/cd/x_y where abcd starts the MD5 hash of level/x/y, spreading the tiles evenly, with y=0 at the bottom
+//	arcgis:  ArcGIS exploded cache, L05/R0000abcd/C0000ef01 (row and column in hexadecimal), with y=0 at the top
+var Layouts = map[string]Layout{
+	"tms":     tmsLayout{},
+	"xyz":     xyzLayout{},
+	"zyx":     zyxLayout{},
+	"quadkey": quadkeyLayout{},
+	"tc":      tcLayout{},
+	"mp":      mpLayout{},
+	"hashed":  hashedLayout{},
+	"arcgis":  arcgisLayout{},
+}
+
+//DefaultLayout is the layout used when none is specified
+var DefaultLayout Layout = tmsLayout{}
+
+//LayoutByName returns the layout of a given name (see Layouts)
+func LayoutByName(name string) (Layout, error) {
+	l, ok := Layouts[strings.ToLower(name)]
+	if !ok {
+		var names []string
+		for n := range Layouts {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("Unknown tile folder layout %s (available layouts: %s)", name, strings.Join(names, ", "))
+	}
+	return l, nil
+}
+
+//flipY converts y between the TMS and the XYZ conventions
+func flipY(level, y int) int {
+	return (1 << uint(level)) - y - 1
+}
+
+type tmsLayout struct{}
+
+func (tmsLayout) Path(level, x, y int) (string, bool) {
+	return fmt.Sprintf("%d/%d/%d", level, x, y), true
+}
+func (tmsLayout) LevelDir(level int) string { return fmt.Sprintf("%d", level) }
+
+type xyzLayout struct{}
+
+func (xyzLayout) Path(level, x, y int) (string, bool) {
+	return fmt.Sprintf("%d/%d/%d", level, x, flipY(level, y)), true
+}
+func (xyzLayout) LevelDir(level int) string { return fmt.Sprintf("%d", level) }
+
+type zyxLayout struct{}
+
+func (zyxLayout) Path(level, x, y int) (string, bool) {
+	return fmt.Sprintf("%d/%d/%d", level, flipY(level, y), x), true
+}
+func (zyxLayout) LevelDir(level int) string { return fmt.Sprintf("%d", level) }
+
+type quadkeyLayout struct{}
+
+func (quadkeyLayout) Path(level, x, y int) (string, bool) {
+	if level == 0 {
+		return "", false
+	}
+	y = flipY(level, y)
+	q := make([]byte, 0, level)
+	for i := level; i > 0; i-- {
+		digit := byte('0')
+		mask := 1 << uint(i-1)
+		if x&mask != 0 {
+			digit++
+		}
+		if y&mask != 0 {
+			digit += 2
+		}
+		q = append(q, digit)
+	}
+	return string(q), true
+}
+func (quadkeyLayout) LevelDir(level int) string { return "" }
+
+type tcLayout struct{}
+
+func (tcLayout) Path(level, x, y int) (string, bool) {
+	return fmt.Sprintf("%02d/%03d/%03d/%03d/%03d/%03d/%03d", level,
+		x/1000000, (x/1000)%1000, x%1000,
+		y/1000000, (y/1000)%1000, y%1000), true
+}
+func (tcLayout) LevelDir(level int) string { return fmt.Sprintf("%02d", level) }
+
+type mpLayout struct{}
+
+func (mpLayout) Path(level, x, y int) (string, bool) {
+	return fmt.Sprintf("%02d/%04d/%04d/%04d/%04d", level, x/10000, x%10000, y/10000, y%10000), true
+}
+func (mpLayout) LevelDir(level int) string { return fmt.Sprintf("%02d", level) }
+
+type hashedLayout struct{}
+
+func (hashedLayout) Path(level, x, y int) (string, bool) {
+	sum := md5.Sum([]byte(fmt.Sprintf("%d/%d/%d", level, x, y)))
+	h := hex.EncodeToString(sum[:2])
+	return fmt.Sprintf("%d/%s/%s/%d_%d", level, h[:2], h[2:], x, y), true
+}
+func (hashedLayout) LevelDir(level int) string { return fmt.Sprintf("%d", level) }
+
+type arcgisLayout struct{}
+
+func (arcgisLayout) Path(level, x, y int) (string, bool) {
+	return fmt.Sprintf("L%02d/R%08x/C%08x", level, flipY(level, y), x), true
+}
+func (arcgisLayout) LevelDir(level int) string { return fmt.Sprintf("L%02d", level) }
@@ -0,0 +1,110 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package tilefolder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLayouts(t *testing.T) {
+	//Level 5, x=10, y=20 in TMS (y=11 in XYZ)
+	for name, expected := range map[string]string{
+		"tms":     "5/10/20",
+		"xyz":     "5/10/11",
+		"zyx":     "5/11/10",
+		"quadkey": "03032",
+		"tc":      "05/000/000/010/000/000/020",
+		"mp":      "05/0000/0010/0000/0020",
+		"arcgis":  "L05/R0000000b/C0000000a",
+	} {
+		l, err := LayoutByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := l.Path(5, 10, 20); !ok || got != expected {
+			t.Errorf("%s.Path() => %s, %v, want %s", name, got, ok, expected)
+		}
+	}
+
+	p, ok := Layouts["hashed"].Path(5, 10, 20)
+	if parts := strings.Split(p, "/"); !ok || len(parts) != 4 || parts[0] != "5" || len(parts[1]) != 2 || len(parts[2]) != 2 || parts[3] != "10_20" {
+		t.Errorf("hashed.Path() => %s, %v", p, ok)
+	}
+
+	if _, ok := Layouts["quadkey"].Path(0, 0, 0); ok {
+		t.Error("quadkey.Path() should fail at level 0")
+	}
+	if _, err := LayoutByName("unknown"); err == nil {
+		t.Error("LayoutByName() should fail on an unknown layout")
+	}
+}
+
+func TestTileFolderLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tilefolder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, l := range Layouts {
+		f, err := NewTileFolderLayout(filepath.Join(dir, name), "png", l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tile := range [][3]int{{1, 0, 1}, {2, 3, 0}} {
+			if err := f.SetRaw(tile[0], tile[1], tile[2], []byte(name)); err != nil {
+				t.Fatalf("%s.SetRaw(%v) => %s", name, tile, err)
+			}
+		}
+		if b, err := f.GetRaw(2, 3, 0); err != nil || string(b) != name {
+			t.Errorf("%s.GetRaw() => %s, %v", name, b, err)
+		}
+
+		if err := f.Clear(2); err != nil {
+			t.Fatal(err)
+		}
+		if ok, _ := f.Contains(2, 3, 0); ok {
+			t.Errorf("%s.Clear() did not remove the tile", name)
+		}
+		if ok, _ := f.Contains(1, 0, 1); !ok {
+			t.Errorf("%s.Clear() removed a tile of an other level", name)
+		}
+
+		//Only the tiles are removed, not the metadata file whose name has 8 characters
+		if err := f.WriteMetadata(); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Clear(8); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, name, MetadataFile)); err != nil {
+			t.Errorf("%s.Clear(8) removed the metadata file: %v", name, err)
+		}
+	}
+
+	//Layout selection from the data source name
+	d := tileFolderDriver{format: "png"}
+	if !d.CanOpen(dir+"#layout=arcgis") || d.CanOpen(dir+"#layout=unknown") {
+		t.Error("CanOpen() failed")
+	}
+	s, err := d.OpenTileSource(dir + "#layout=arcgis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.OpenTileLayer("arcgis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := r.GetRaw(1, 0, 1); err != nil || string(b) != "arcgis" {
+		t.Errorf("GetRaw() => %s, %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "arcgis", "L01", "R00000000", "C00000000.png")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package tilefolder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/xeonx/raster"
+)
+
+//MetadataFile is the name of the file describing the tiles of a folder
+const MetadataFile = "metadata.json"
+
+//Metadata is the content of the metadata file of a folder: a TileJSON, with the layout of the folder and the size of its tiles as extensions.
+type Metadata struct {
+	raster.TileJSON
+	Layout   string `json:"layout,omitempty"`   //Name of the layout (see Layouts)
+	TileSize int    `json:"tilesize,omitempty"` //Width and height in pixels of the tiles (256 if not set)
+}
+
+//ReadMetadata reads the metadata file of the folder at basePath. It returns false if the folder has no metadata file.
+func ReadMetadata(basePath string) (Metadata, bool, error) {
+	var m Metadata
+	b, err := ioutil.ReadFile(filepath.Join(basePath, MetadataFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, false, nil
+		}
+		return m, false, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, false, fmt.Errorf("Invalid tile folder metadata %s: %s", filepath.Join(basePath, MetadataFile), err)
+	}
+	return m, true, nil
+}
+
+//writeMetadata writes the metadata file of the folder at basePath
+func writeMetadata(basePath string, m Metadata, sync Sync) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(basePath, MetadataFile), b, sync)
+}
+
+//layoutName returns the name of a layout in Layouts, or an empty string for other layouts
+func layoutName(layout Layout) string {
+	for name, l := range Layouts {
+		if l == layout {
+			return name
+		}
+	}
+	return ""
+}
+
+//newMetadata creates the metadata describing a folder of the given format and layout.
+//Tile URL templates, relative to the folder, are only given for the layouts following the TMS and XYZ schemes.
+func newMetadata(name string, format string, layout Layout) Metadata {
+	m := Metadata{
+		TileJSON: raster.TileJSON{
+			TileJSON: raster.TileJSONVersion,
+			Name:     name,
+			Format:   format,
+			Tiles:    []string{},
+		},
+		Layout: layoutName(layout),
+	}
+	switch m.Layout {
+	case "tms", "xyz":
+		m.Scheme = m.Layout
+		m.Tiles = []string{"{z}/{x}/{y}." + format}
+	}
+	return m
+}
+
+//tileFormats are the formats recognized when detecting the format of a folder, by file extension
+var tileFormats = map[string]string{
+	".png": "png",
+	".jpg": "jpg",
+}
+
+//errDetected stops the walk of a folder once a tile is found
+var errDetected = errors.New("Tile found")
+
+//DetectLayout guesses the format and the layout of a folder from the path of one of its tiles, relative to the folder and using slashes.
+//It returns false if the path is not the one of a tile.
+//
+//The layouts storing the tiles as level/x/y (tms, xyz and zyx) cannot be told apart: DefaultLayout is returned for them.
+func DetectLayout(tilePath string) (string, Layout, bool) {
+	format, ok := tileFormats[strings.ToLower(path.Ext(tilePath))]
+	if !ok || isTemp(path.Base(tilePath)) {
+		return "", nil, false
+	}
+	parts := strings.Split(strings.TrimSuffix(tilePath, path.Ext(tilePath)), "/")
+
+	switch {
+	case len(parts) == 1 && strings.Trim(parts[0], "0123") == "":
+		return format, Layouts["quadkey"], true
+	case len(parts) == 3 && strings.HasPrefix(parts[0], "L"):
+		return format, Layouts["arcgis"], true
+	case len(parts) == 3:
+		return format, DefaultLayout, true
+	case len(parts) == 4 && strings.Contains(parts[3], "_"):
+		return format, Layouts["hashed"], true
+	case len(parts) == 5:
+		return format, Layouts["mp"], true
+	case len(parts) == 7:
+		return format, Layouts["tc"], true
+	}
+	return "", nil, false
+}
+
+//detect guesses the format and the layout of the folder at basePath from the path of its first tile (see DetectLayout).
+//It returns false if the folder contains no tile.
+func detect(basePath string) (string, Layout, bool, error) {
+	var format string
+	var layout Layout
+
+	err := filepath.Walk(basePath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(basePath, p)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		if format, layout, ok = DetectLayout(filepath.ToSlash(rel)); ok {
+			return errDetected
+		}
+		return nil
+	})
+
+	if err == errDetected {
+		return format, layout, true, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", nil, false, err
+	}
+	return "", nil, false, nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package tilefolder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xeonx/raster"
+)
+
+func TestDetect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tilefolder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, l := range Layouts {
+		for _, format := range []string{"png", "jpg"} {
+			basePath := filepath.Join(dir, name+format)
+			f, err := NewTileFolderLayout(basePath, format, l)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := f.SetRaw(3, 2, 5, []byte("tile")); err != nil {
+				t.Fatal(err)
+			}
+
+			expected := l
+			if name == "xyz" || name == "zyx" {
+				expected = DefaultLayout
+			}
+			gotFormat, gotLayout, ok, err := detect(basePath)
+			if err != nil || !ok || gotFormat != format || gotLayout != expected {
+				t.Errorf("detect(%s, %s) => %s, %v, %v, %v", name, format, gotFormat, gotLayout, ok, err)
+			}
+		}
+	}
+
+	if _, _, ok, err := detect(filepath.Join(dir, "missing")); ok || err != nil {
+		t.Errorf("detect() on a missing folder => %v, %v", ok, err)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tilefolder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source, err := raster.Open("folder", dir+"#layout=arcgis&format=jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := source.(raster.WritableTileSource).CreateTileLayer("layer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetRaw(4, 1, 2, []byte("tile")); err != nil {
+		t.Fatal(err)
+	}
+
+	m, found, err := ReadMetadata(filepath.Join(dir, "layer"))
+	if err != nil || !found || m.Name != "layer" || m.Format != "jpg" || m.Layout != "arcgis" || m.TileJSON.TileJSON != raster.TileJSONVersion {
+		t.Errorf("ReadMetadata() => %+v, %v, %v", m, found, err)
+	}
+
+	if size := raster.TileSize(w); size != raster.DefaultTileSize {
+		t.Errorf("TileSize() => %d, want the default", size)
+	}
+	if err := w.(raster.TileSizeWriter).SetTileSize(512); err != nil {
+		t.Fatal(err)
+	}
+	err = w.(TileFolder).SetTileJSON(raster.TileJSON{
+		Attribution: "© Contributors",
+		MinZoom:     2,
+		MaxZoom:     6,
+		Bounds:      []float64{1, 2, 3, 4},
+		Format:      "png",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Format and layout are read from the metadata file by the automatic driver
+	source, err = raster.Open("folder", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := source.ListTileLayers()
+	if err != nil || len(layers) != 1 || layers[0] != "layer" {
+		t.Fatalf("ListTileLayers() => %v, %v", layers, err)
+	}
+	r, err := source.OpenTileLayer("layer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.TileFormat() != "jpg" {
+		t.Errorf("TileFormat() => %s", r.TileFormat())
+	}
+	if b, err := r.GetRaw(4, 1, 2); err != nil || string(b) != "tile" {
+		t.Errorf("GetRaw() => %s, %v", b, err)
+	}
+	if a := r.(raster.Attributed).Attribution(); a != "© Contributors" {
+		t.Errorf("Attribution() => %s", a)
+	}
+	if levelMin, levelMax, err := r.(raster.LevelRanger).LevelRange(); err != nil || levelMin != 2 || levelMax != 6 {
+		t.Errorf("LevelRange() => %d, %d, %v", levelMin, levelMax, err)
+	}
+	if b, err := r.(raster.Bounded).Bounds(); err != nil || b.LongitudeMinDeg != 1 || b.LatitudeMaxDeg != 4 {
+		t.Errorf("Bounds() => %+v, %v", b, err)
+	}
+	if size := raster.TileSize(r); size != 512 {
+		t.Errorf("TileSize() => %d, want 512", size)
+	}
+}
+
+func TestOpenTileFolder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tilefolder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//Folder written by an other tool, without metadata file
+	f, err := NewTileFolderLayout(dir, "jpg", Layouts["quadkey"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, level := range []int{3, 5} {
+		if err := f.SetRaw(level, 1, 1, []byte("tile")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err = OpenTileFolder(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.TileFormat() != "jpg" || f.Metadata().Layout != "quadkey" {
+		t.Errorf("OpenTileFolder() => %+v", f.Metadata())
+	}
+	if levelMin, levelMax, err := f.LevelRange(); err != nil || levelMin != 3 || levelMax != 5 {
+		t.Errorf("LevelRange() => %d, %d, %v", levelMin, levelMax, err)
+	}
+
+	//Empty folder
+	f, err = OpenTileFolder(filepath.Join(dir, "empty"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.TileFormat() != "png" || f.Metadata().Layout != "tms" || f.Metadata().Tiles[0] != "{z}/{x}/{y}.png" {
+		t.Errorf("OpenTileFolder() => %+v", f.Metadata())
+	}
+	if levelMin, levelMax, err := f.LevelRange(); err != nil || levelMin != 0 || levelMax != 0 {
+		t.Errorf("LevelRange() => %d, %d, %v", levelMin, levelMax, err)
+	}
+}
@@ -9,36 +9,121 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
-	"strconv"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xeonx/geographic"
+	"github.com/xeonx/raster"
 )
 
-//TileFolder is a folder of tiles stored as .../level/x/y.format
+//maxLevel is the highest level looked for when listing the levels of a folder
+const maxLevel = 30
+
+//TileFolder is a folder of tiles stored as .../level/x/y.format, or according to an other Layout
 type TileFolder struct {
 	basePath   string
 	tileFormat string
+	layout     Layout
+	sync       Sync
+	metadata   *Metadata
 }
 
-//NewTileFolder creates a new TileFolder based on the given configuration
+//NewTileFolder creates a new TileFolder based on the given configuration, with the DefaultLayout
 func NewTileFolder(basePath string, tileFormat string) (TileFolder, error) {
+	return NewTileFolderLayout(basePath, tileFormat, DefaultLayout)
+}
+
+//NewTileFolderLayout creates a new TileFolder storing the tiles according to layout
+func NewTileFolderLayout(basePath string, tileFormat string, layout Layout) (TileFolder, error) {
+	m := newMetadata(path.Base(basePath), tileFormat, layout)
 	return TileFolder{
 		basePath:   basePath,
 		tileFormat: tileFormat,
+		layout:     layout,
+		metadata:   &m,
 	}, nil
 }
 
+//OpenTileFolder opens the folder at basePath with the format and the layout given by its metadata file.
+//Without metadata file, they are detected from the stored tiles, defaulting to png and DefaultLayout for an empty folder.
+func OpenTileFolder(basePath string) (TileFolder, error) {
+	return openTileFolder(basePath, "", nil)
+}
+
+//openTileFolder opens the folder at basePath. An empty format or a nil layout is read from the metadata file or detected.
+func openTileFolder(basePath string, format string, layout Layout) (TileFolder, error) {
+	m, found, err := ReadMetadata(basePath)
+	if err != nil {
+		return TileFolder{}, err
+	}
+	if found {
+		if len(format) == 0 {
+			format = m.Format
+		}
+		if layout == nil && len(m.Layout) > 0 {
+			if layout, err = LayoutByName(m.Layout); err != nil {
+				return TileFolder{}, err
+			}
+		}
+	}
+
+	if len(format) == 0 || layout == nil {
+		detectedFormat, detectedLayout, ok, err := detect(basePath)
+		if err != nil {
+			return TileFolder{}, err
+		}
+		if ok && len(format) == 0 {
+			format = detectedFormat
+		}
+		if ok && layout == nil {
+			layout = detectedLayout
+		}
+	}
+
+	if len(format) == 0 {
+		format = "png"
+	}
+	if layout == nil {
+		layout = DefaultLayout
+	}
+
+	f, err := NewTileFolderLayout(basePath, format, layout)
+	if err != nil {
+		return f, err
+	}
+	if found {
+		*f.metadata = m
+	}
+	return f, nil
+}
+
+//WithSync returns a copy of the folder flushing its writes according to sync. The default is SyncNone.
+func (f TileFolder) WithSync(sync Sync) TileFolder {
+	f.sync = sync
+	return f
+}
+
 //TileFormat exposes the image format of the source (png or jpg)
 func (f TileFolder) TileFormat() string {
 	return f.tileFormat
 }
 
-//GetPath returns the path for the given level/x/y.
+//GetPath returns the path for the given level/x/y, or an empty string if the tile cannot be stored with the layout of the folder.
 func (f TileFolder) GetPath(level, x, y int) string {
-	return path.Join(f.basePath, strconv.Itoa(level), strconv.Itoa(x), fmt.Sprintf("%d.%s", y, f.tileFormat))
+	p, ok := f.layout.Path(level, x, y)
+	if !ok {
+		return ""
+	}
+	return path.Join(f.basePath, p+"."+f.tileFormat)
 }
 
 //GetRaw retrieves the tile for a given level/x/y.
 func (f TileFolder) GetRaw(level, x, y int) ([]byte, error) {
 	path := f.GetPath(level, x, y)
+	if len(path) == 0 {
+		return nil, nil
+	}
 
 	return ioutil.ReadFile(path)
 }
@@ -46,6 +131,9 @@ func (f TileFolder) GetRaw(level, x, y int) ([]byte, error) {
 //Contains returns true if the reader already contains the tile for a given level/x/y
 func (f TileFolder) Contains(level int, x, y int) (bool, error) {
 	path := f.GetPath(level, x, y)
+	if len(path) == 0 {
+		return false, nil
+	}
 
 	_, err := os.Stat(path)
 	if err != nil {
@@ -58,21 +146,241 @@ func (f TileFolder) Contains(level int, x, y int) (bool, error) {
 	return true, nil
 }
 
+//TileInfo retrieves the modification time of the tile file for a given level/x/y, and the ETag computed from its content.
+func (f TileFolder) TileInfo(level, x, y int) (raster.TileInfo, bool, error) {
+	modTime, found, err := f.TileModTime(level, x, y)
+	if err != nil || !found {
+		return raster.TileInfo{}, found, err
+	}
+	b, err := ioutil.ReadFile(f.GetPath(level, x, y))
+	if err != nil {
+		return raster.TileInfo{}, false, err
+	}
+
+	return raster.TileInfo{ModTime: modTime, ETag: raster.ContentETag(b)}, true, nil
+}
+
+//TileModTime retrieves the modification time of the tile file for a given level/x/y, without reading the tile.
+func (f TileFolder) TileModTime(level, x, y int) (time.Time, bool, error) {
+	path := f.GetPath(level, x, y)
+	if len(path) == 0 {
+		return time.Time{}, false, nil
+	}
+
+	s, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, err
+	}
+	return s.ModTime(), true, nil
+}
+
 //SetRaw stores the tile for a given level/x/y. No check is performed on the image format.
+//
+//The tile is written to a temporary file renamed once complete, so that an interrupted write never leaves a truncated tile.
 func (f TileFolder) SetRaw(level, x, y int, img []byte) error {
+	path := f.GetPath(level, x, y)
+	if len(path) == 0 {
+		return fmt.Errorf("Tile %d/%d/%d cannot be stored with the layout of %s", level, x, y, f.basePath)
+	}
 
-	basePath := path.Join(f.basePath, strconv.Itoa(level), strconv.Itoa(x))
-	if err := os.MkdirAll(basePath, 0666); err != nil {
+	return writeFile(path, img, f.sync)
+}
+
+//SetRawInfo stores the tile for a given level/x/y, with the modification time of info. A zero ModTime means the current time.
+//The ETag is not stored: the one of a tile is computed from its content.
+func (f TileFolder) SetRawInfo(level, x, y int, img []byte, info raster.TileInfo) error {
+	if err := f.SetRaw(level, x, y, img); err != nil {
 		return err
 	}
+	if info.ModTime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(f.GetPath(level, x, y), info.ModTime, info.ModTime)
+}
 
+//Delete removes the tile for a given level/x/y.
+func (f TileFolder) Delete(level, x, y int) error {
 	path := f.GetPath(level, x, y)
-
-	return ioutil.WriteFile(path, img, 0666)
+	if len(path) == 0 {
+		return nil
+	}
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
 
 //Clear removes all stored tiles at a given level.
 func (f TileFolder) Clear(level int) error {
+	if dir := f.layout.LevelDir(level); len(dir) > 0 {
+		return os.RemoveAll(path.Join(f.basePath, dir))
+	}
 
-	return os.RemoveAll(path.Join(f.basePath, strconv.Itoa(level)))
+	//Tiles of all levels in the same directory (quadkey layout): the level is the length of the file name
+	files, err := ioutil.ReadDir(f.basePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, fi := range files {
+		if l, ok := f.quadkeyLevel(fi); !ok || l != level {
+			continue
+		}
+		if err := os.Remove(path.Join(f.basePath, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//quadkeyLevel returns the level of a tile file of the quadkey layout, and false if fi is not a tile file
+//(such as the metadata file or a temporary file)
+func (f TileFolder) quadkeyLevel(fi os.FileInfo) (int, bool) {
+	name := fi.Name()
+	if fi.IsDir() || filepath.Ext(name) != "."+f.tileFormat {
+		return 0, false
+	}
+	key := strings.TrimSuffix(name, filepath.Ext(name))
+	for _, c := range key {
+		if c < '0' || c > '3' {
+			return 0, false
+		}
+	}
+	return len(key), true
+}
+
+//RemoveTemp removes the temporary files left over by interrupted writes, i.e. older than maxAge, and returns their number.
+func (f TileFolder) RemoveTemp(maxAge time.Duration) (int, error) {
+	return removeTemp(f.basePath, maxAge)
+}
+
+//Metadata returns the metadata of the folder, as read from its metadata file or as it would be written
+func (f TileFolder) Metadata() Metadata {
+	return *f.metadata
+}
+
+//TileJSON returns the description of the tiles of the metadata
+func (f TileFolder) TileJSON() raster.TileJSON {
+	return f.metadata.TileJSON
+}
+
+//WriteMetadata writes the metadata file of the folder, if it does not exist yet
+func (f TileFolder) WriteMetadata() error {
+	if _, err := os.Stat(path.Join(f.basePath, MetadataFile)); !os.IsNotExist(err) {
+		return err
+	}
+	return writeMetadata(f.basePath, *f.metadata, f.sync)
+}
+
+//SetTileJSON writes the name, description, attribution, zoom range, bounds and center of tj to the metadata file of the folder.
+//The format, the layout and the tile size of the folder are kept.
+func (f TileFolder) SetTileJSON(tj raster.TileJSON) error {
+	m := *f.metadata
+	if len(tj.Name) > 0 {
+		m.Name = tj.Name
+	}
+	m.Description = tj.Description
+	m.Version = tj.Version
+	m.Attribution = tj.Attribution
+	m.MinZoom, m.MaxZoom = tj.MinZoom, tj.MaxZoom
+	m.Bounds = tj.Bounds
+	m.Center = tj.Center
+
+	if err := writeMetadata(f.basePath, m, f.sync); err != nil {
+		return err
+	}
+	*f.metadata = m
+	return nil
+}
+
+//TileSize returns the width and height in pixels of the tiles, as given by the metadata
+func (f TileFolder) TileSize() int {
+	if f.metadata.TileSize > 0 {
+		return f.metadata.TileSize
+	}
+	return raster.DefaultTileSize
+}
+
+//SetTileSize writes the width and height in pixels of the tiles to the metadata file of the folder
+func (f TileFolder) SetTileSize(size int) error {
+	m := *f.metadata
+	m.TileSize = size
+	if err := writeMetadata(f.basePath, m, f.sync); err != nil {
+		return err
+	}
+	*f.metadata = m
+	return nil
+}
+
+//Attribution returns the attribution of the metadata
+func (f TileFolder) Attribution() string {
+	return f.metadata.Attribution
+}
+
+//Bounds returns the bounds of the metadata, or the whole world if the metadata has no bounds
+func (f TileFolder) Bounds() (geographic.BoundingBox, error) {
+	b := f.metadata.Bounds
+	if len(b) != 4 {
+		b = []float64{-180, -85.0511, 180, 85.0511}
+	}
+	return geographic.BoundingBox{
+		LongitudeMinDeg: b[0],
+		LatitudeMinDeg:  b[1],
+		LongitudeMaxDeg: b[2],
+		LatitudeMaxDeg:  b[3],
+	}, nil
+}
+
+//LevelRange returns the zoom range of the metadata. Without zoom range in the metadata,
+//it returns the minimum and maximum levels found in the folder, or 0 and 0 if no tile is stored.
+func (f TileFolder) LevelRange() (int, int, error) {
+	if f.metadata.MinZoom > 0 || f.metadata.MaxZoom > 0 {
+		return f.metadata.MinZoom, f.metadata.MaxZoom, nil
+	}
+
+	levelMin, levelMax := -1, -1
+	found := func(level int) {
+		if levelMin < 0 || level < levelMin {
+			levelMin = level
+		}
+		if level > levelMax {
+			levelMax = level
+		}
+	}
+
+	for level := 0; level <= maxLevel; level++ {
+		dir := f.layout.LevelDir(level)
+		if len(dir) == 0 {
+			continue
+		}
+		if _, err := os.Stat(path.Join(f.basePath, dir)); err == nil {
+			found(level)
+		} else if !os.IsNotExist(err) {
+			return 0, 0, err
+		}
+	}
+
+	if len(f.layout.LevelDir(1)) == 0 {
+		//Tiles of all levels in the same directory (quadkey layout): the level is the length of the file name
+		files, err := ioutil.ReadDir(f.basePath)
+		if err != nil && !os.IsNotExist(err) {
+			return 0, 0, err
+		}
+		for _, fi := range files {
+			if level, ok := f.quadkeyLevel(fi); ok {
+				found(level)
+			}
+		}
+	}
+
+	if levelMin < 0 {
+		return 0, 0, nil
+	}
+	return levelMin, levelMax, nil
 }
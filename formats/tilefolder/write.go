@@ -0,0 +1,165 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package tilefolder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//dirMode is the permission of the created directories, before umask
+const dirMode = 0755
+
+//fileMode is the permission of the written tiles, before umask
+const fileMode = 0666
+
+//tempPrefix starts the name of the temporary files written before being renamed to the tile path
+const tempPrefix = ".tmp-"
+
+//tempCount numbers the temporary files created by this process
+var tempCount uint64
+
+//TempMaxAge is the age after which a temporary file is considered left over by an interrupted write.
+//Younger temporary files may belong to a write in progress in another process.
+var TempMaxAge = time.Hour
+
+//Sync defines how the writes are flushed to the disk
+type Sync int
+
+const (
+	//SyncNone lets the operating system flush the writes. A crash may lose recent tiles, but never leaves truncated ones.
+	SyncNone Sync = iota
+	//SyncFile flushes each tile file before renaming it
+	SyncFile
+	//SyncDir flushes each tile file, and its directory after the rename so that the tile survives a crash
+	SyncDir
+)
+
+//syncModes are the Sync values by name, as used in the data source names
+var syncModes = map[string]Sync{
+	"none": SyncNone,
+	"file": SyncFile,
+	"dir":  SyncDir,
+}
+
+//isTemp returns true if name is the name of a temporary file
+func isTemp(name string) bool {
+	return strings.HasPrefix(name, tempPrefix)
+}
+
+//writeFile writes data to a temporary file in the directory of path, then renames it to path.
+//Readers thus see either the previous content or the new one, but never a partially written file.
+func writeFile(path string, data []byte, sync Sync) error {
+	dir := filepath.Dir(path)
+	if err := mkdirAll(dir, sync); err != nil {
+		return err
+	}
+
+	tmp, err := createTemp(dir, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	_, err = tmp.Write(data)
+	if err == nil && sync >= SyncFile {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, path)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	if sync >= SyncDir {
+		return syncDir(dir)
+	}
+	return nil
+}
+
+//createTemp creates a new temporary file for the file name in dir, with the permission of the tiles
+func createTemp(dir, name string) (*os.File, error) {
+	for {
+		tmpName := fmt.Sprintf("%s%s-%d-%d", tempPrefix, name, os.Getpid(), atomic.AddUint64(&tempCount, 1))
+		f, err := os.OpenFile(filepath.Join(dir, tmpName), os.O_RDWR|os.O_CREATE|os.O_EXCL, fileMode)
+		if os.IsExist(err) {
+			//Left over by an earlier process with the same id
+			continue
+		}
+		return f, err
+	}
+}
+
+//mkdirAll creates dir and its missing parents.
+//With SyncDir, the parent of each created directory is flushed so that the directory survives a crash.
+func mkdirAll(dir string, sync Sync) error {
+	if sync < SyncDir {
+		return os.MkdirAll(dir, dirMode)
+	}
+
+	var created []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil || !os.IsNotExist(err) || filepath.Dir(d) == d {
+			break
+		}
+		created = append(created, d)
+	}
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return err
+	}
+	for _, d := range created {
+		if err := syncDir(filepath.Dir(d)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//syncDir flushes the entries of a directory
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+//removeTemp removes the temporary files older than maxAge in the tree rooted at root, and returns their number.
+func removeTemp(root string, maxAge time.Duration) (int, error) {
+	removed := 0
+	limit := time.Now().Add(-maxAge)
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !isTemp(info.Name()) || info.ModTime().After(limit) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Cannot remove temporary file: %s", err)
+		}
+		removed++
+		return nil
+	})
+
+	return removed, err
+}
@@ -0,0 +1,175 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package tilefolder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xeonx/raster"
+)
+
+func TestAtomicWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tilefolder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, sync := range []Sync{SyncNone, SyncFile, SyncDir} {
+		f, err := NewTileFolder(dir, "png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f = f.WithSync(sync)
+		if err := f.SetRaw(3, 1, 2, []byte("first")); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.SetRaw(3, 1, 2, []byte("second")); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := f.GetRaw(3, 1, 2); err != nil || string(b) != "second" {
+			t.Errorf("GetRaw() => %s, %v", b, err)
+		}
+	}
+
+	s, err := os.Stat(filepath.Join(dir, "3", "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Mode().Perm()&0700 != 0700 {
+		t.Errorf("Directory created with mode %s", s.Mode())
+	}
+
+	//Tiles get the permission of the files created by os.Create, which applies the umask
+	created, err := os.Create(filepath.Join(dir, "created"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	created.Close()
+	expected, err := os.Stat(created.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(created.Name())
+	s, err = os.Stat(filepath.Join(dir, "3", "1", "2.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Mode().Perm() != expected.Mode().Perm() {
+		t.Errorf("Tile written with mode %s, want %s", s.Mode(), expected.Mode())
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, "3", "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "2.png" {
+		t.Errorf("Unexpected files left after writes: %v", files)
+	}
+}
+
+func TestRemoveTemp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tilefolder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	layer := filepath.Join(dir, "layer")
+	f, err := NewTileFolder(layer, "png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetRaw(1, 0, 1, []byte("tile")); err != nil {
+		t.Fatal(err)
+	}
+	old := filepath.Join(layer, "1", "0", tempPrefix+"1.png-123")
+	recent := filepath.Join(layer, "1", "0", tempPrefix+"0.png-456")
+	for _, name := range []string{old, recent} {
+		if err := ioutil.WriteFile(name, []byte("trunc"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	past := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(old, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	//Temporary files are only removed with the recover option
+	source, err := raster.Open("folder", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.(raster.WritableTileSource).CreateTileLayer("layer"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(old); err != nil {
+		t.Error("CreateTileLayer() removed a temporary file without the recover option")
+	}
+	source, err = raster.Open("folder", dir+"#recover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.(raster.WritableTileSource).CreateTileLayer("layer"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("CreateTileLayer() did not remove the old temporary file")
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Error("CreateTileLayer() removed a recent temporary file")
+	}
+	if ok, _ := f.Contains(1, 0, 1); !ok {
+		t.Error("CreateTileLayer() removed a tile")
+	}
+
+	if n, err := f.RemoveTemp(0); err != nil || n != 1 {
+		t.Errorf("RemoveTemp() => %d, %v", n, err)
+	}
+
+	if _, err := parseDataSourceName(dir + "#sync=always"); err == nil {
+		t.Error("parseDataSourceName() should fail on an unknown sync mode")
+	}
+	if s, err := parseDataSourceName(dir + "#sync=dir&layout=xyz"); err != nil || s.sync != SyncDir || s.dataSourceName != dir || s.recover {
+		t.Errorf("parseDataSourceName() => %+v, %v", s, err)
+	}
+	if s, err := parseDataSourceName(dir + "#recover=false"); err != nil || s.recover {
+		t.Errorf("parseDataSourceName(recover=false) => %+v, %v", s, err)
+	}
+	if _, err := parseDataSourceName(dir + "#recover=maybe"); err == nil {
+		t.Error("parseDataSourceName() should fail on an invalid recover option")
+	}
+}
+
+func TestSetRawInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tilefolder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := NewTileFolder(dir, "png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	if err := f.SetRawInfo(1, 0, 1, []byte("tile"), raster.TileInfo{ModTime: mtime}); err != nil {
+		t.Fatal(err)
+	}
+	info, found, err := f.TileInfo(1, 0, 1)
+	if err != nil || !found || !info.ModTime.Equal(mtime) {
+		t.Errorf("TileInfo() => %+v, %v, %v", info, found, err)
+	}
+	if modTime, found, err := f.TileModTime(1, 0, 1); err != nil || !found || !modTime.Equal(mtime) {
+		t.Errorf("TileModTime() => %s, %v, %v", modTime, found, err)
+	}
+	if _, found, err := f.TileModTime(1, 1, 1); err != nil || found {
+		t.Errorf("TileModTime() of a missing tile => %v, %v", found, err)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package tilejson
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xeonx/raster"
+)
+
+func init() {
+	raster.Register("tilejson", tileJSONDriver{})
+}
+
+type tileJSONDriver struct {
+}
+
+func (d tileJSONDriver) OpenTileSource(dataSourceName string) (raster.TileSource, error) {
+	return Open(dataSourceName)
+}
+
+//CanOpen returns true for URLs of JSON documents, and for JSON files containing tile URLs
+func (d tileJSONDriver) CanOpen(dataSourceName string) bool {
+	location := dataSourceName
+	if i := strings.LastIndex(location, "#"); i >= 0 {
+		location = location[:i]
+	}
+
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		if i := strings.Index(location, "?"); i >= 0 {
+			location = location[:i]
+		}
+		return strings.HasSuffix(strings.ToLower(location), ".json")
+	}
+
+	if strings.ToLower(filepath.Ext(location)) != ".json" {
+		return false
+	}
+	f, err := os.Open(location)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var tj raster.TileJSON
+	return json.NewDecoder(f).Decode(&tj) == nil && len(tj.Tiles) > 0
+}
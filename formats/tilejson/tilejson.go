@@ -0,0 +1,215 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+/*
+Package tilejson provides a tile source configured by a TileJSON document (https://github.com/mapbox/tilejson-spec).
+
+The tiles are requested from the URL templates of the document with the zxyserver package,
+limited to the zoom range and the bounds of the document.
+*/
+package tilejson
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/xeonx/geographic"
+	"github.com/xeonx/raster"
+	"github.com/xeonx/raster/formats/zxyserver"
+)
+
+//Source is the TileSource of a TileJSON document. It consists of a single layer, which is the Source itself.
+type Source struct {
+	TileJSON raster.TileJSON
+
+	name    string
+	servers []*zxyserver.ZxyServer //One per URL template, used in turn
+}
+
+//Open reads a TileJSON document from a URL or a file, optionally followed by options given as a fragment.
+//The options are the ones of the zxyserver data source names (such as useragent, rps or apikey), and apply to all the URL templates:
+//
+//	https://example.com/tiles.json#useragent=MyApp/1.0&rps=2
+func Open(dataSourceName string) (*Source, error) {
+	location, options := dataSourceName, ""
+	if i := strings.LastIndex(dataSourceName, "#"); i >= 0 {
+		location, options = dataSourceName[:i], dataSourceName[i:]
+	}
+
+	var b []byte
+	var err error
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		//The document is requested with the same options as the tiles
+		var doc *zxyserver.ZxyServer
+		doc, err = zxyserver.ParseOptions(location, strings.TrimPrefix(options, "#"))
+		if err != nil {
+			return nil, err
+		}
+		b, err = doc.Fetch()
+	} else {
+		b, err = ioutil.ReadFile(location)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tj raster.TileJSON
+	if err := json.Unmarshal(b, &tj); err != nil {
+		return nil, fmt.Errorf("Invalid TileJSON %s: %s", location, err)
+	}
+
+	s, err := New(tj, location, options)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.name) == 0 {
+		s.name = location
+	}
+	return s, nil
+}
+
+//resolve resolves a tile URL template relative to the location of the TileJSON document
+func resolve(template, location string) string {
+	if strings.Contains(template, "://") || len(location) == 0 {
+		return template
+	}
+	if strings.HasPrefix(template, "/") {
+		if i := strings.Index(location, "://"); i >= 0 {
+			if j := strings.Index(location[i+3:], "/"); j >= 0 {
+				return location[:i+3+j] + template
+			}
+			return location + template
+		}
+		return template
+	}
+	return location[:strings.LastIndex(location, "/")+1] + template
+}
+
+//New creates a Source from a TileJSON document read at location, used to resolve relative tile URLs.
+//options are appended to the URL templates to configure the zxyserver readers (e.g. "#useragent=MyApp/1.0").
+func New(tj raster.TileJSON, location string, options string) (*Source, error) {
+	if len(tj.Tiles) == 0 {
+		return nil, fmt.Errorf("Invalid TileJSON %s: no tile URL", location)
+	}
+	if len(tj.Bounds) != 0 && len(tj.Bounds) != 4 {
+		return nil, fmt.Errorf("Invalid TileJSON %s: 4 bounds expected", location)
+	}
+	if tj.MaxZoom == 0 && tj.MinZoom == 0 {
+		tj.MaxZoom = 30
+	}
+
+	s := &Source{TileJSON: tj, name: tj.Name}
+	for _, template := range tj.Tiles {
+		template = resolve(template, location)
+		if tj.Scheme == "tms" {
+			template = strings.Replace(template, "{y}", "{-y}", -1)
+		}
+		server, err := zxyserver.ParseDataSourceName(template + options)
+		if err != nil {
+			return nil, err
+		}
+		s.servers = append(s.servers, server)
+	}
+	return s, nil
+}
+
+//ZxyServers returns the servers of the URL templates, so that the zxyserver options of raster.Open apply to them
+func (s *Source) ZxyServers() []*zxyserver.ZxyServer {
+	return s.servers
+}
+
+//ListTileLayers list all available tile layers
+func (s *Source) ListTileLayers() ([]string, error) {
+	return []string{s.name}, nil
+}
+
+//OpenTileLayer opens the tile layer for reading
+//
+//As a TileJSON document describes a single layer, the same layer will be returned or raster.ErrLayerNotFund will be raised.
+func (s *Source) OpenTileLayer(name string) (raster.TileReader, error) {
+	if name == s.name {
+		return s, nil
+	}
+	return nil, raster.ErrLayerNotFund
+}
+
+//server returns the server of a tile, and false if the tile is outside of the zoom range or of the bounds
+func (s *Source) server(level, x, y int) (*zxyserver.ZxyServer, bool) {
+	if level < s.TileJSON.MinZoom || level > s.TileJSON.MaxZoom {
+		return nil, false
+	}
+	if b := s.TileJSON.Bounds; len(b) == 4 {
+		if raster.X2Lon(level, x+1) <= b[0] || raster.X2Lon(level, x) >= b[2] ||
+			raster.Y2Lat(level, y+1) <= b[1] || raster.Y2Lat(level, y) >= b[3] {
+			return nil, false
+		}
+	}
+	return s.servers[(x+y)%len(s.servers)], true
+}
+
+//TileFormat exposes the image format of the source (png or jpg)
+func (s *Source) TileFormat() string {
+	if len(s.TileJSON.Format) > 0 {
+		return s.TileJSON.Format
+	}
+	return s.servers[0].TileFormat()
+}
+
+//TileSize returns the width and height in pixels of the tiles
+func (s *Source) TileSize() int {
+	return s.servers[0].TileSize()
+}
+
+//GetRaw retrieves the tile for a given level/x/y. It returns nil if the tile is outside of the zoom range or of the bounds.
+func (s *Source) GetRaw(level, x, y int) ([]byte, error) {
+	server, ok := s.server(level, x, y)
+	if !ok {
+		return nil, nil
+	}
+	return server.GetRaw(level, x, y)
+}
+
+//GetRawIfModified retrieves the tile for a given level/x/y unless it did not change (see zxyserver.ZxyServer.GetRawIfModified).
+func (s *Source) GetRawIfModified(level, x, y int, info raster.TileInfo) ([]byte, raster.TileInfo, error) {
+	server, ok := s.server(level, x, y)
+	if !ok {
+		return nil, raster.TileInfo{}, nil
+	}
+	return server.GetRawIfModified(level, x, y, info)
+}
+
+//Contains returns true if the tile for a given level/x/y is in the zoom range and the bounds, and is available on the server
+func (s *Source) Contains(level int, x, y int) (bool, error) {
+	server, ok := s.server(level, x, y)
+	if !ok {
+		return false, nil
+	}
+	return server.Contains(level, x, y)
+}
+
+//LevelRange returns the zoom range of the document
+func (s *Source) LevelRange() (int, int, error) {
+	return s.TileJSON.MinZoom, s.TileJSON.MaxZoom, nil
+}
+
+//Bounds returns the bounds of the document, or the whole world if the document has no bounds
+func (s *Source) Bounds() (geographic.BoundingBox, error) {
+	b := s.TileJSON.Bounds
+	if len(b) != 4 {
+		b = []float64{-180, -85.0511, 180, 85.0511}
+	}
+	return geographic.BoundingBox{
+		LongitudeMinDeg: b[0],
+		LatitudeMinDeg:  b[1],
+		LongitudeMaxDeg: b[2],
+		LatitudeMaxDeg:  b[3],
+	}, nil
+}
+
+//Attribution returns the attribution of the document
+func (s *Source) Attribution() string {
+	return s.TileJSON.Attribution
+}
@@ -0,0 +1,177 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package tilejson
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xeonx/raster"
+	"github.com/xeonx/raster/formats/zxyserver"
+)
+
+const document = `{
+	"tilejson": "2.2.0",
+	"name": "Test",
+	"attribution": "© Test",
+	"scheme": "tms",
+	"tiles": ["tiles/{z}/{x}/{y}.png", "/mirror/{z}/{x}/{y}.png"],
+	"minzoom": 1,
+	"maxzoom": 5,
+	"bounds": [0, 0, 180, 85]
+}`
+
+//newTestServer serves the TileJSON document to the Test User-Agent and records the requested tiles
+func newTestServer(mu *sync.Mutex, requested *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/data/tiles.json" {
+			if r.Header.Get("User-Agent") != "Test" {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(document))
+			return
+		}
+		mu.Lock()
+		*requested = append(*requested, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG\r\n\x1a\n..."))
+	}))
+}
+
+func TestOpen(t *testing.T) {
+	var mu sync.Mutex
+	var requested []string
+	ts := newTestServer(&mu, &requested)
+	defer ts.Close()
+
+	//The document is requested with the options of the data source name
+	if _, err := Open(ts.URL + "/data/tiles.json"); err == nil {
+		t.Error("Open() without User-Agent should fail")
+	}
+	s, err := Open(ts.URL + "/data/tiles.json#useragent=Test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layers, _ := s.ListTileLayers(); len(layers) != 1 || layers[0] != "Test" {
+		t.Errorf("ListTileLayers() => %v", layers)
+	}
+	r, err := s.OpenTileLayer("Test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.TileFormat() != "png" {
+		t.Errorf("TileFormat() => %s", r.TileFormat())
+	}
+
+	testCases := []struct {
+		level, x, y int
+		path        string
+	}{
+		{0, 0, 0, ""},                      //Below minzoom
+		{6, 40, 40, ""},                    //Above maxzoom
+		{1, 0, 1, ""},                      //Outside of the bounds
+		{1, 1, 0, ""},                      //Outside of the bounds
+		{1, 1, 1, "/data/tiles/1/1/1.png"}, //First template, TMS scheme
+		{2, 2, 2, "/data/tiles/2/2/2.png"},
+		{2, 3, 2, "/mirror/2/3/2.png"}, //Second template
+	}
+	for _, tc := range testCases {
+		requested = nil
+		b, err := r.GetRaw(tc.level, tc.x, tc.y)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (b != nil) != (len(tc.path) > 0) || (len(tc.path) > 0 && (len(requested) != 1 || requested[0] != tc.path)) {
+			t.Errorf("GetRaw(%d, %d, %d) => %v, requested %v, want %s", tc.level, tc.x, tc.y, b, requested, tc.path)
+		}
+	}
+
+	tj, err := raster.NewTileJSON(r, "http://example.com/{z}/{x}/{y}.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tj.MinZoom != 1 || tj.MaxZoom != 5 || tj.Attribution != "© Test" || tj.Bounds[3] != 85 {
+		t.Errorf("NewTileJSON() => %+v", tj)
+	}
+}
+
+func TestOpenOptions(t *testing.T) {
+	var mu sync.Mutex
+	var userAgents []string
+	tiles := newTestServer(&mu, new([]string))
+	defer tiles.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		userAgents = append(userAgents, r.Header.Get("User-Agent"))
+		mu.Unlock()
+		tiles.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	//The zxyserver options of raster.Open apply to the servers of the URL templates
+	source, err := raster.Open("tilejson", ts.URL+"/data/tiles.json#useragent=Test",
+		zxyserver.WithSettings(zxyserver.Settings{UserAgent: "MyApp/1.0"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := raster.OpenTileLayerAt(source, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetRaw(1, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(userAgents) != 2 || userAgents[0] != "Test" || userAgents[1] != "MyApp/1.0" {
+		t.Errorf("User-Agents of the requests => %v", userAgents)
+	}
+}
+
+func TestCanOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tilejson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := filepath.Join(dir, "tiles.json")
+	ioutil.WriteFile(valid, []byte(strings.Replace(document, "tiles/", "http://example.com/", 1)), 0644)
+	other := filepath.Join(dir, "job.json")
+	ioutil.WriteFile(other, []byte(`{"ranges": []}`), 0644)
+
+	d := tileJSONDriver{}
+	for dsn, expected := range map[string]bool{
+		"https://example.com/tiles.json":                true,
+		"https://example.com/tiles.json?key=0123#rps=2": true,
+		"https://example.com/{z}/{x}/{y}.png":           false,
+		valid:                                              true,
+		other:                                              false,
+		filepath.Join(dir, "missing.json"):                 false,
+	} {
+		if got := d.CanOpen(dsn); got != expected {
+			t.Errorf("CanOpen(%s) => %v, want %v", dsn, got, expected)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	for template, expected := range map[string]string{
+		"https://tiles.example.org/{z}/{x}/{y}.png": "https://tiles.example.org/{z}/{x}/{y}.png",
+		"tiles/{z}/{x}/{y}.png":                        "https://example.com/data/tiles/{z}/{x}/{y}.png",
+		"/tiles/{z}/{x}/{y}.png":                       "https://example.com/tiles/{z}/{x}/{y}.png",
+	} {
+		if got := resolve(template, "https://example.com/data/tiles.json"); got != expected {
+			t.Errorf("resolve(%s) => %s, want %s", template, got, expected)
+		}
+	}
+}
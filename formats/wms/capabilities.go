@@ -0,0 +1,67 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package wms
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+)
+
+//Capabilities is the subset of a WMS GetCapabilities document describing the layers.
+type Capabilities struct {
+	Version string            `xml:"version,attr"`
+	Layers  []CapabilityLayer `xml:"Capability>Layer"`
+}
+
+//CapabilityLayer is a layer of a GetCapabilities document. Layers without Name are only groups of other layers.
+type CapabilityLayer struct {
+	Name   string            `xml:"Name"`
+	Title  string            `xml:"Title"`
+	Layers []CapabilityLayer `xml:"Layer"`
+}
+
+//LayerNames returns the names of all named layers, in document order.
+func (c *Capabilities) LayerNames() []string {
+	var names []string
+	var walk func(layers []CapabilityLayer)
+	walk = func(layers []CapabilityLayer) {
+		for _, l := range layers {
+			if len(l.Name) > 0 {
+				names = append(names, l.Name)
+			}
+			walk(l.Layers)
+		}
+	}
+	walk(c.Layers)
+	return names
+}
+
+//GetCapabilities requests and parses the GetCapabilities document of the server
+func (s *Server) GetCapabilities() (*Capabilities, error) {
+	params := url.Values{}
+	params.Set("SERVICE", "WMS")
+	params.Set("REQUEST", "GetCapabilities")
+	params.Set("VERSION", s.Version)
+	u, err := s.requestURL(params)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client().Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("Unexpected HTTP status for %s: %s", s.URL, resp.Status)
+	}
+
+	var c Capabilities
+	if err := xml.NewDecoder(resp.Body).Decode(&c); err != nil {
+		return nil, fmt.Errorf("Invalid WMS capabilities: %s", err)
+	}
+	return &c, nil
+}
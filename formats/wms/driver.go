@@ -0,0 +1,39 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package wms
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/xeonx/raster"
+)
+
+func init() {
+	raster.Register("wms", wmsDriver{})
+}
+
+type wmsDriver struct {
+}
+
+func (d wmsDriver) OpenTileSource(dataSourceName string) (raster.TileSource, error) {
+	return Open(dataSourceName)
+}
+
+func (d wmsDriver) CanOpen(dataSourceName string) bool {
+	if !strings.HasPrefix(dataSourceName, "http") {
+		return false
+	}
+	u, err := url.Parse(dataSourceName)
+	if err != nil {
+		return false
+	}
+	for key, values := range u.Query() {
+		if strings.EqualFold(key, "SERVICE") && strings.EqualFold(values[0], "WMS") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,332 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+/*
+Package wms provides a tile source requesting the web mercator tiles from an OGC WMS (Web Map Service) server.
+
+Each tile (or metatile) is requested with a GetMap request covering its bounding box. Servers are requested
+either in EPSG:3857 (web mercator), or in EPSG:4326 (WGS 84) in which case the image rows are resampled to web mercator.
+*/
+package wms
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/xeonx/raster"
+)
+
+//getMapParams are the parameters of the data source name describing the GetMap request.
+//They are removed from the URL, the other parameters (such as vendor parameters) being kept.
+var getMapParams = map[string]bool{
+	"SERVICE": true, "REQUEST": true, "VERSION": true, "LAYERS": true, "STYLES": true, "CRS": true, "SRS": true,
+	"FORMAT": true, "TRANSPARENT": true, "WIDTH": true, "HEIGHT": true, "BBOX": true,
+}
+
+//Server is the TileSource of a WMS server. Each layer of the server is a TileReader.
+type Server struct {
+	URL         string       //GetMap endpoint, without the GetMap parameters
+	Version     string       //WMS version: 1.1.1 or 1.3.0
+	Layers      string       //Comma separated layers requested together. Empty means the named layers of GetCapabilities.
+	Styles      string       //Comma separated styles of the layers. Empty means the default styles.
+	CRS         string       //EPSG:3857 or EPSG:4326
+	Format      string       //MIME type of the images, e.g. image/png
+	Transparent bool         //Request images with a transparent background
+	Size        int          //Width and height in pixels of the tiles. 0 means raster.DefaultTileSize.
+	Client      *http.Client //HTTP client sending the requests. nil means http.DefaultClient.
+}
+
+//Open creates a Server from a GetMap URL, such as:
+//
+//	http://example.com/wms?SERVICE=WMS&VERSION=1.3.0&LAYERS=roads&CRS=EPSG:3857&FORMAT=image/png&TRANSPARENT=TRUE
+//
+//Parameter names are case insensitive. WIDTH (or HEIGHT) gives the tile size, and defaults to raster.DefaultTileSize.
+//VERSION defaults to 1.3.0, CRS (or SRS) to EPSG:3857 and FORMAT to image/png.
+func Open(dataSourceName string) (*Server, error) {
+	u, err := url.Parse(dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+
+	params := make(map[string]string)
+	query := u.Query()
+	for key, values := range u.Query() {
+		upper := strings.ToUpper(key)
+		if getMapParams[upper] {
+			params[upper] = values[0]
+			query.Del(key)
+		}
+	}
+	if !strings.EqualFold(params["SERVICE"], "WMS") {
+		return nil, fmt.Errorf("Invalid WMS data source %s: SERVICE=WMS expected", dataSourceName)
+	}
+	u.RawQuery = query.Encode()
+
+	s := &Server{
+		URL:         u.String(),
+		Version:     params["VERSION"],
+		Layers:      params["LAYERS"],
+		Styles:      params["STYLES"],
+		CRS:         strings.ToUpper(params["CRS"]),
+		Format:      params["FORMAT"],
+		Transparent: strings.EqualFold(params["TRANSPARENT"], "TRUE"),
+	}
+	if len(s.Version) == 0 {
+		s.Version = "1.3.0"
+	}
+	if len(s.CRS) == 0 {
+		s.CRS = strings.ToUpper(params["SRS"])
+	}
+	if len(s.CRS) == 0 {
+		s.CRS = "EPSG:3857"
+	}
+	if s.CRS != "EPSG:3857" && s.CRS != "EPSG:4326" {
+		return nil, fmt.Errorf("Unsupported WMS CRS %s: only EPSG:3857 and EPSG:4326 are supported", s.CRS)
+	}
+	if len(s.Format) == 0 {
+		s.Format = "image/png"
+	}
+	size := params["WIDTH"]
+	if len(size) == 0 {
+		size = params["HEIGHT"]
+	}
+	if len(size) > 0 {
+		s.Size, err = strconv.Atoi(size)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid WMS tile size %s", size)
+		}
+	}
+
+	return s, nil
+}
+
+//client returns the HTTP client sending the requests
+func (s *Server) client() *http.Client {
+	if s.Client == nil {
+		return http.DefaultClient
+	}
+	return s.Client
+}
+
+//ServiceURL returns the GetMap endpoint
+func (s *Server) ServiceURL() string {
+	return s.URL
+}
+
+//HTTPClient returns the client sending the requests. nil means http.DefaultClient.
+func (s *Server) HTTPClient() *http.Client {
+	return s.Client
+}
+
+//SetHTTPClient sets the client sending the requests, e.g. to apply the zxyserver options of raster.Open
+func (s *Server) SetHTTPClient(client *http.Client) {
+	s.Client = client
+}
+
+//requestURL builds the URL of a WMS request from the endpoint and the request parameters
+func (s *Server) requestURL(params url.Values) (string, error) {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+//ListTileLayers list all available tile layers: the configured Layers, or else the named layers of the GetCapabilities document.
+func (s *Server) ListTileLayers() ([]string, error) {
+	if len(s.Layers) > 0 {
+		return []string{s.Layers}, nil
+	}
+
+	c, err := s.GetCapabilities()
+	if err != nil {
+		return nil, err
+	}
+	return c.LayerNames(), nil
+}
+
+//OpenTileLayer opens the tile layer for reading. The name is used as LAYERS parameter: several layers may be combined, separated by commas.
+func (s *Server) OpenTileLayer(name string) (raster.TileReader, error) {
+	if len(name) == 0 {
+		return nil, raster.ErrLayerNotFund
+	}
+	return &Layer{Server: s, Name: name}, nil
+}
+
+//Layer is the TileReader of a WMS layer.
+type Layer struct {
+	*Server
+	Name string //LAYERS parameter of the GetMap requests
+}
+
+//TileFormat exposes the image format of the source (png or jpg)
+func (l *Layer) TileFormat() string {
+	format := strings.ToLower(l.Format)
+	if i := strings.IndexByte(format, ';'); i >= 0 {
+		format = format[:i]
+	}
+	switch format {
+	case "image/jpeg", "image/jpg":
+		return "jpg"
+	}
+	return strings.TrimPrefix(format, "image/")
+}
+
+//TileSize returns the width and height in pixels of the tiles
+func (l *Layer) TileSize() int {
+	if l.Size <= 0 {
+		return raster.DefaultTileSize
+	}
+	return l.Size
+}
+
+//Contains returns true if the reader already contains the tile for a given level/x/y.
+//As a WMS server renders any area on demand, it always returns true.
+func (l *Layer) Contains(level int, x, y int) (bool, error) {
+	return true, nil
+}
+
+//GetRaw retrieves the tile for a given level/x/y with a GetMap request.
+func (l *Layer) GetRaw(level, x, y int) ([]byte, error) {
+	return l.GetRawMetatile(raster.TileBlock{Level: level, Xmin: x, Xmax: x, Ymin: y, Ymax: y})
+}
+
+//GetRawMetatile retrieves a single image covering all the tiles of block with a GetMap request.
+func (l *Layer) GetRawMetatile(block raster.TileBlock) ([]byte, error) {
+	size := l.TileSize()
+	width := (block.Xmax - block.Xmin + 1) * size
+	height := (block.Ymax - block.Ymin + 1) * size
+
+	worldSize := raster.Lon2Meters(360)
+	tileMeters := worldSize / float64(int(1)<<uint(block.Level))
+	minX := float64(block.Xmin)*tileMeters - worldSize/2
+	maxX := float64(block.Xmax+1)*tileMeters - worldSize/2
+	minY := float64(block.Ymin)*tileMeters - worldSize/2
+	maxY := float64(block.Ymax+1)*tileMeters - worldSize/2
+
+	if l.CRS != "EPSG:4326" {
+		return l.GetMap([4]float64{minX, minY, maxX, maxY}, width, height)
+	}
+
+	//Request the same area in WGS 84, then resample the rows to web mercator
+	minLon, maxLon := raster.X2Lon(block.Level, block.Xmin), raster.X2Lon(block.Level, block.Xmax+1)
+	minLat, maxLat := raster.Y2Lat(block.Level, block.Ymin), raster.Y2Lat(block.Level, block.Ymax+1)
+	b, err := l.GetMap([4]float64{minLon, minLat, maxLon, maxLat}, width, height)
+	if err != nil {
+		return nil, err
+	}
+	src, err := raster.Decode(b, l.TileFormat())
+	if err != nil {
+		return nil, err
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	pixelMeters := (maxY - minY) / float64(height)
+	for row := 0; row < height; row++ {
+		lat := raster.Meters2Lat(maxY - (float64(row)+0.5)*pixelMeters)
+		srcRow := int(math.Floor((maxLat - lat) / (maxLat - minLat) * float64(height)))
+		if srcRow < 0 {
+			srcRow = 0
+		} else if srcRow >= height {
+			srcRow = height - 1
+		}
+		sb := src.Bounds()
+		draw.Draw(dst, image.Rect(0, row, width, row+1), src, image.Pt(sb.Min.X, sb.Min.Y+srcRow), draw.Src)
+	}
+	return raster.Encode(dst, l.TileFormat())
+}
+
+//GetMap requests an image of width x height pixels covering bbox (minX, minY, maxX, maxY) expressed in the CRS of the server:
+//meters for EPSG:3857, or longitudes and latitudes in degrees for EPSG:4326.
+func (l *Layer) GetMap(bbox [4]float64, width, height int) ([]byte, error) {
+	params := url.Values{}
+	params.Set("SERVICE", "WMS")
+	params.Set("REQUEST", "GetMap")
+	params.Set("VERSION", l.Version)
+	params.Set("LAYERS", l.Name)
+	params.Set("STYLES", l.Styles)
+	params.Set("FORMAT", l.Format)
+	params.Set("WIDTH", strconv.Itoa(width))
+	params.Set("HEIGHT", strconv.Itoa(height))
+	if l.Transparent {
+		params.Set("TRANSPARENT", "TRUE")
+	}
+
+	crsParam := "CRS"
+	if l.Version < "1.3" {
+		crsParam = "SRS"
+	}
+	params.Set(crsParam, l.CRS)
+
+	//WMS 1.3.0 follows the axis order of the CRS: latitude first for EPSG:4326
+	if l.CRS == "EPSG:4326" && l.Version >= "1.3" {
+		bbox = [4]float64{bbox[1], bbox[0], bbox[3], bbox[2]}
+	}
+	var values []string
+	for _, v := range bbox {
+		if v == 0 {
+			v = 0 //Avoid formatting -0
+		}
+		values = append(values, strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	params.Set("BBOX", strings.Join(values, ","))
+
+	u, err := l.requestURL(params)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := l.client().Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("Unexpected HTTP status for %s: %s", l.URL, resp.Status)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+		return nil, serviceException(b)
+	}
+
+	return b, nil
+}
+
+//serviceException extracts the error reported by a WMS server in place of an image
+func serviceException(b []byte) error {
+	var report struct {
+		Exceptions []struct {
+			Code    string `xml:"code,attr"`
+			Message string `xml:",chardata"`
+		} `xml:"ServiceException"`
+	}
+	if err := xml.Unmarshal(b, &report); err != nil || len(report.Exceptions) == 0 {
+		return errors.New("WMS server did not return an image")
+	}
+
+	e := report.Exceptions[0]
+	msg := strings.TrimSpace(e.Message)
+	if len(e.Code) > 0 {
+		msg = e.Code + ": " + msg
+	}
+	return fmt.Errorf("WMS service exception: %s", msg)
+}
@@ -0,0 +1,268 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package wms
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/xeonx/raster"
+	"github.com/xeonx/raster/formats/zxyserver"
+)
+
+var red = color.RGBA{255, 0, 0, 255}
+var blue = color.RGBA{0, 0, 255, 255}
+
+const capabilities = `<?xml version="1.0" encoding="UTF-8"?>
+<WMS_Capabilities version="1.3.0" xmlns="http://www.opengis.net/wms">
+	<Service><Name>WMS</Name><Title>Test</Title></Service>
+	<Capability>
+		<Layer>
+			<Title>Root</Title>
+			<Layer><Name>roads</Name><Title>Roads</Title></Layer>
+			<Layer>
+				<Name>landuse</Name><Title>Land use</Title>
+				<Layer><Name>forest</Name><Title>Forest</Title></Layer>
+			</Layer>
+		</Layer>
+	</Capability>
+</WMS_Capabilities>`
+
+//newTestServer creates a WMS stand-in recording the parameters of the last GetMap request.
+//Images are red north of the equator and blue south of it, assuming a BBOX centered on the equator.
+func newTestServer(t *testing.T, last *map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := make(map[string]string)
+		for key, values := range r.URL.Query() {
+			params[key] = values[0]
+		}
+		*last = params
+
+		switch params["REQUEST"] {
+		case "GetCapabilities":
+			w.Header().Set("Content-Type", "text/xml")
+			w.Write([]byte(capabilities))
+		case "GetMap":
+			if params["LAYERS"] == "missing" {
+				w.Header().Set("Content-Type", "application/vnd.ogc.se_xml")
+				w.Write([]byte(`<ServiceExceptionReport><ServiceException code="LayerNotDefined">Unknown layer missing</ServiceException></ServiceExceptionReport>`))
+				return
+			}
+			width, _ := strconv.Atoi(params["WIDTH"])
+			height, _ := strconv.Atoi(params["HEIGHT"])
+			img := image.NewRGBA(image.Rect(0, 0, width, height))
+			draw.Draw(img, image.Rect(0, 0, width, height/2), &image.Uniform{red}, image.ZP, draw.Src)
+			draw.Draw(img, image.Rect(0, height/2, width, height), &image.Uniform{blue}, image.ZP, draw.Src)
+			b, err := raster.Encode(img, "png")
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(b)
+		default:
+			http.Error(w, "Bad request", http.StatusBadRequest)
+		}
+	}))
+}
+
+func TestOpen(t *testing.T) {
+	s, err := Open("http://example.com/wms?map=test.map&service=wms&version=1.1.1&layers=roads,rivers&srs=epsg:4326&format=image/jpeg&transparent=true&width=512")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.URL != "http://example.com/wms?map=test.map" || s.Version != "1.1.1" || s.Layers != "roads,rivers" ||
+		s.CRS != "EPSG:4326" || s.Format != "image/jpeg" || !s.Transparent || s.Size != 512 {
+		t.Errorf("Open() => %+v", s)
+	}
+
+	s, err = Open("http://example.com/wms?SERVICE=WMS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Version != "1.3.0" || s.CRS != "EPSG:3857" || s.Format != "image/png" || s.Transparent || s.Size != 0 {
+		t.Errorf("Open() defaults => %+v", s)
+	}
+
+	for _, dsn := range []string{
+		"http://example.com/wms?SERVICE=WMS&CRS=EPSG:2154",
+		"http://example.com/wms?LAYERS=roads",
+	} {
+		if _, err := Open(dsn); err == nil {
+			t.Errorf("Open(%s) should fail", dsn)
+		}
+	}
+
+	d := wmsDriver{}
+	if !d.CanOpen("http://example.com/wms?service=WMS&layers=roads") || d.CanOpen("http://example.com/{z}/{x}/{y}.png") {
+		t.Error("CanOpen() failed")
+	}
+}
+
+func TestListTileLayers(t *testing.T) {
+	var params map[string]string
+	ts := newTestServer(t, &params)
+	defer ts.Close()
+
+	s, err := Open(ts.URL + "?SERVICE=WMS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := s.ListTileLayers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(layers, ",") != "roads,landuse,forest" {
+		t.Errorf("ListTileLayers() => %v", layers)
+	}
+	if params["VERSION"] != "1.3.0" {
+		t.Errorf("GetCapabilities parameters => %v", params)
+	}
+
+	s.Layers = "roads,forest"
+	if layers, _ := s.ListTileLayers(); len(layers) != 1 || layers[0] != "roads,forest" {
+		t.Errorf("ListTileLayers() with configured layers => %v", layers)
+	}
+}
+
+func TestGetRaw(t *testing.T) {
+	var params map[string]string
+	ts := newTestServer(t, &params)
+	defer ts.Close()
+
+	testCases := []struct {
+		dsn    string
+		block  raster.TileBlock
+		expect map[string]string
+	}{
+		{
+			"?SERVICE=WMS&LAYERS=roads&STYLES=night&TRANSPARENT=TRUE",
+			raster.TileBlock{Level: 1, Xmin: 0, Xmax: 0, Ymin: 0, Ymax: 0},
+			map[string]string{"CRS": "EPSG:3857", "LAYERS": "roads", "STYLES": "night", "TRANSPARENT": "TRUE",
+				"WIDTH": "256", "HEIGHT": "256", "BBOX": "-20037508.34278924,-20037508.34278924,0,0"},
+		},
+		{
+			"?SERVICE=WMS&VERSION=1.1.1&LAYERS=roads&WIDTH=512",
+			raster.TileBlock{Level: 1, Xmin: 0, Xmax: 1, Ymin: 1, Ymax: 1},
+			map[string]string{"SRS": "EPSG:3857", "WIDTH": "1024", "HEIGHT": "512",
+				"BBOX": "-20037508.34278924,0,20037508.34278924,20037508.34278924"},
+		},
+		{
+			"?SERVICE=WMS&VERSION=1.1.1&LAYERS=roads&SRS=EPSG:4326",
+			raster.TileBlock{Level: 1, Xmin: 1, Xmax: 1, Ymin: 1, Ymax: 1},
+			map[string]string{"SRS": "EPSG:4326", "BBOX": "0,0,180,85.05112877980659"},
+		},
+		{
+			"?SERVICE=WMS&LAYERS=roads&CRS=EPSG:4326",
+			raster.TileBlock{Level: 1, Xmin: 1, Xmax: 1, Ymin: 1, Ymax: 1},
+			map[string]string{"CRS": "EPSG:4326", "BBOX": "0,0,85.05112877980659,180"},
+		},
+	}
+
+	for _, tc := range testCases {
+		s, err := Open(ts.URL + tc.dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := s.OpenTileLayer(s.Layers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := r.(*Layer)
+		b, err := l.GetRawMetatile(tc.block)
+		if err != nil {
+			t.Fatalf("GetRawMetatile(%s) => %s", tc.dsn, err)
+		}
+		img, err := raster.Decode(b, l.TileFormat())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds().Dx() != (tc.block.Xmax-tc.block.Xmin+1)*l.TileSize() {
+			t.Errorf("GetRawMetatile(%s) => image of %v", tc.dsn, img.Bounds())
+		}
+		for key, value := range tc.expect {
+			if params[key] != value {
+				t.Errorf("GetRawMetatile(%s) => %s=%s, want %s", tc.dsn, key, params[key], value)
+			}
+		}
+	}
+}
+
+func TestGetRawEPSG4326(t *testing.T) {
+	var params map[string]string
+	ts := newTestServer(t, &params)
+	defer ts.Close()
+
+	s, err := Open(ts.URL + "?SERVICE=WMS&LAYERS=roads&CRS=EPSG:4326")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := s.OpenTileLayer(s.Layers)
+
+	//The level 0 tile is centered on the equator both in web mercator and in WGS 84
+	b, err := r.GetRaw(0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := raster.Decode(b, "png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for row, expected := range map[int]color.RGBA{0: red, 127: red, 128: blue, 255: blue} {
+		if c := color.RGBAModel.Convert(img.At(10, row)); c != expected {
+			t.Errorf("row %d => %v, want %v", row, c, expected)
+		}
+	}
+}
+
+func TestServiceException(t *testing.T) {
+	var params map[string]string
+	ts := newTestServer(t, &params)
+	defer ts.Close()
+
+	s, err := Open(ts.URL + "?SERVICE=WMS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := s.OpenTileLayer("missing")
+	if _, err := r.GetRaw(0, 0, 0); err == nil || !strings.Contains(err.Error(), "LayerNotDefined: Unknown layer missing") {
+		t.Errorf("GetRaw() => %v", err)
+	}
+}
+
+func TestOpenOptions(t *testing.T) {
+	var params map[string]string
+	wms := newTestServer(t, &params)
+	defer wms.Close()
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		wms.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	//The zxyserver options apply to the requests of WMS sources
+	source, err := raster.Open("wms", ts.URL+"?SERVICE=WMS&LAYERS=roads",
+		zxyserver.WithSettings(zxyserver.Settings{UserAgent: "MyApp/1.0", RequestsPerSecond: 100}),
+		zxyserver.WithCredentials(zxyserver.Credentials{Token: "abc"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := raster.OpenTileLayerAt(source, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetRaw(1, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if header.Get("User-Agent") != "MyApp/1.0" || header.Get("Authorization") != "Bearer abc" {
+		t.Errorf("GetRaw() sent headers %v", header)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package wmts
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/xeonx/raster"
+)
+
+//Capabilities is the subset of a WMTS GetCapabilities document describing the layers and how to request their tiles.
+type Capabilities struct {
+	Operations     []Operation     `xml:"OperationsMetadata>Operation"`
+	Layers         []Layer         `xml:"Contents>Layer"`
+	TileMatrixSets []TileMatrixSet `xml:"Contents>TileMatrixSet"`
+}
+
+//Operation describes the endpoints of an operation, such as GetTile.
+type Operation struct {
+	Name string         `xml:"name,attr"`
+	Gets []OperationGet `xml:"DCP>HTTP>Get"`
+}
+
+//OperationGet is an HTTP GET endpoint of an operation with its allowed encodings (KVP, RESTful).
+type OperationGet struct {
+	Href      string   `xml:"http://www.w3.org/1999/xlink href,attr"`
+	Encodings []string `xml:"Constraint>AllowedValues>Value"`
+}
+
+//Layer is a layer of a GetCapabilities document.
+type Layer struct {
+	Identifier     string        `xml:"Identifier"`
+	Title          string        `xml:"Title"`
+	Styles         []Style       `xml:"Style"`
+	Formats        []string      `xml:"Format"`
+	TileMatrixSets []string      `xml:"TileMatrixSetLink>TileMatrixSet"`
+	ResourceURLs   []ResourceURL `xml:"ResourceURL"`
+	Dimensions     []Dimension   `xml:"Dimension"`
+}
+
+//Style is a style of a layer
+type Style struct {
+	Identifier string `xml:"Identifier"`
+	IsDefault  bool   `xml:"isDefault,attr"`
+}
+
+//ResourceURL is a URL template of the RESTful encoding, with {TileMatrixSet}, {TileMatrix}, {TileRow}, {TileCol}, {Style}
+//and dimension placeholders.
+type ResourceURL struct {
+	Format       string `xml:"format,attr"`
+	ResourceType string `xml:"resourceType,attr"`
+	Template     string `xml:"template,attr"`
+}
+
+//Dimension is an additional dimension of a layer, such as the time. Its default value is requested.
+type Dimension struct {
+	Identifier string `xml:"Identifier"`
+	Default    string `xml:"Default"`
+}
+
+//TileMatrixSet is a set of tile matrices, one per scale.
+type TileMatrixSet struct {
+	Identifier   string       `xml:"Identifier"`
+	SupportedCRS string       `xml:"SupportedCRS"`
+	TileMatrices []TileMatrix `xml:"TileMatrix"`
+}
+
+//TileMatrix is a grid of tiles at a given scale.
+type TileMatrix struct {
+	Identifier       string  `xml:"Identifier"`
+	ScaleDenominator float64 `xml:"ScaleDenominator"`
+	TopLeftCorner    string  `xml:"TopLeftCorner"`
+	TileWidth        int     `xml:"TileWidth"`
+	TileHeight       int     `xml:"TileHeight"`
+	MatrixWidth      int     `xml:"MatrixWidth"`
+	MatrixHeight     int     `xml:"MatrixHeight"`
+}
+
+//ReadCapabilities parses a GetCapabilities document
+func ReadCapabilities(r io.Reader) (*Capabilities, error) {
+	var c Capabilities
+	if err := xml.NewDecoder(r).Decode(&c); err != nil {
+		return nil, fmt.Errorf("Invalid WMTS capabilities: %s", err)
+	}
+	return &c, nil
+}
+
+//Layer returns the layer of a given identifier, or nil
+func (c *Capabilities) Layer(identifier string) *Layer {
+	for i := range c.Layers {
+		if c.Layers[i].Identifier == identifier {
+			return &c.Layers[i]
+		}
+	}
+	return nil
+}
+
+//TileMatrixSet returns the tile matrix set of a given identifier, or nil
+func (c *Capabilities) TileMatrixSet(identifier string) *TileMatrixSet {
+	for i := range c.TileMatrixSets {
+		if c.TileMatrixSets[i].Identifier == identifier {
+			return &c.TileMatrixSets[i]
+		}
+	}
+	return nil
+}
+
+//kvpEndpoint returns the endpoint of the GetTile operation supporting the KVP encoding, or an empty string
+func (c *Capabilities) kvpEndpoint() string {
+	for _, o := range c.Operations {
+		if o.Name != "GetTile" {
+			continue
+		}
+		for _, g := range o.Gets {
+			if len(g.Encodings) == 0 {
+				return g.Href
+			}
+			for _, e := range g.Encodings {
+				if strings.EqualFold(e, "KVP") {
+					return g.Href
+				}
+			}
+		}
+	}
+	return ""
+}
+
+//webMercatorCRS are the identifiers of EPSG:3857 and its aliases found in SupportedCRS
+var webMercatorCRS = []string{"3857", "900913", "102100", "102113", "3785"}
+
+//isWebMercator returns true if the CRS of the set is web mercator
+func (s *TileMatrixSet) isWebMercator() bool {
+	crs := s.SupportedCRS
+	if i := strings.LastIndexAny(crs, ":/"); i >= 0 {
+		crs = crs[i+1:]
+	}
+	for _, c := range webMercatorCRS {
+		if crs == c {
+			return true
+		}
+	}
+	return false
+}
+
+//metersPerPixel is the size of a pixel at a scale denominator of 1, according to the WMTS standardized rendering pixel size of 0.28mm
+const metersPerPixel = 0.00028
+
+//levelMatrix locates the tiles of a level in a tile matrix
+type levelMatrix struct {
+	Identifier           string
+	ColOffset, RowOffset int //Column and row of the XYZ tile at the top left corner of the matrix
+	Width, Height        int
+	TileSize             int
+}
+
+//levels maps the tile matrices of a web mercator set to the levels of the library.
+//Matrices not aligned on the web mercator tiles, or of a non square tile size, are ignored.
+func (s *TileMatrixSet) levels() map[int]levelMatrix {
+	levels := make(map[int]levelMatrix)
+	if !s.isWebMercator() {
+		return levels
+	}
+
+	worldSize := raster.Lon2Meters(360)
+	for _, m := range s.TileMatrices {
+		if m.TileWidth <= 0 || m.TileWidth != m.TileHeight || m.ScaleDenominator <= 0 {
+			continue
+		}
+		tileMeters := m.ScaleDenominator * metersPerPixel * float64(m.TileWidth)
+		l := math.Log2(worldSize / tileMeters)
+		level := int(math.Floor(l + 0.5))
+		if math.Abs(l-float64(level)) > 0.01 || level < 0 {
+			continue
+		}
+
+		corner := strings.Fields(m.TopLeftCorner)
+		if len(corner) != 2 {
+			continue
+		}
+		x, errX := strconv.ParseFloat(corner[0], 64)
+		y, errY := strconv.ParseFloat(corner[1], 64)
+		if errX != nil || errY != nil {
+			continue
+		}
+		tileMeters = worldSize / float64(int(1)<<uint(level))
+		col := (x + worldSize/2) / tileMeters
+		row := (worldSize/2 - y) / tileMeters
+		if math.Abs(col-math.Floor(col+0.5)) > 0.01 || math.Abs(row-math.Floor(row+0.5)) > 0.01 {
+			continue
+		}
+
+		levels[level] = levelMatrix{
+			Identifier: m.Identifier,
+			ColOffset:  int(math.Floor(col + 0.5)),
+			RowOffset:  int(math.Floor(row + 0.5)),
+			Width:      m.MatrixWidth,
+			Height:     m.MatrixHeight,
+			TileSize:   m.TileWidth,
+		}
+	}
+	return levels
+}
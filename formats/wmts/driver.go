@@ -0,0 +1,42 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package wmts
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/xeonx/raster"
+)
+
+func init() {
+	raster.Register("wmts", wmtsDriver{})
+}
+
+type wmtsDriver struct {
+}
+
+func (d wmtsDriver) OpenTileSource(dataSourceName string) (raster.TileSource, error) {
+	return Open(dataSourceName)
+}
+
+func (d wmtsDriver) CanOpen(dataSourceName string) bool {
+	if !strings.HasPrefix(dataSourceName, "http") {
+		return false
+	}
+	u, err := url.Parse(dataSourceName)
+	if err != nil {
+		return false
+	}
+	if strings.HasSuffix(strings.ToLower(u.Path), "wmtscapabilities.xml") {
+		return true
+	}
+	for key, values := range u.Query() {
+		if strings.EqualFold(key, "SERVICE") && strings.EqualFold(values[0], "WMTS") {
+			return true
+		}
+	}
+	return false
+}
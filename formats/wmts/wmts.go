@@ -0,0 +1,372 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+/*
+Package wmts provides a tile source reading the tiles of an OGC WMTS (Web Map Tile Service) server.
+
+The layers, styles, formats and tile matrix sets are read from the GetCapabilities document of the server.
+Only web mercator (EPSG:3857) tile matrix sets can be used: their tile matrices are mapped to the levels of
+the library from their scale and top left corner. Tiles are requested with the RESTful encoding if the layer
+has a tile ResourceURL, and with the KVP encoding otherwise.
+*/
+package wmts
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/xeonx/raster"
+)
+
+//Encodings of the GetTile requests
+const (
+	KVP  = "kvp"
+	REST = "rest"
+)
+
+//Server is the TileSource of a WMTS server. Each layer of the server is a TileReader.
+type Server struct {
+	URL          string //URL of the GetCapabilities document
+	Capabilities *Capabilities
+
+	Style         string       //Preferred style. Empty means the default style of each layer.
+	Format        string       //Preferred format, e.g. image/png. Empty means the first format of each layer.
+	TileMatrixSet string       //Preferred tile matrix set. Empty means the first web mercator set of each layer.
+	Encoding      string       //Preferred encoding: KVP or REST. Empty means REST if available.
+	Client        *http.Client //HTTP client sending the requests. nil means http.DefaultClient.
+}
+
+//Open creates a Server from the URL of a GetCapabilities document, optionally followed by options given as a fragment:
+//
+//	http://example.com/wmts/1.0.0/WMTSCapabilities.xml#format=image/jpeg&encoding=kvp
+//
+//The available options are style, format, tilematrixset and encoding (kvp or rest).
+func Open(dataSourceName string) (*Server, error) {
+	u, fragment := dataSourceName, ""
+	if i := strings.LastIndex(dataSourceName, "#"); i >= 0 {
+		u, fragment = dataSourceName[:i], dataSourceName[i+1:]
+	}
+
+	s := &Server{URL: u}
+	options, err := url.ParseQuery(fragment)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid WMTS options: %s", err)
+	}
+	for key, values := range options {
+		value := values[len(values)-1]
+		switch key {
+		case "style":
+			s.Style = value
+		case "format":
+			s.Format = value
+		case "tilematrixset":
+			s.TileMatrixSet = value
+		case "encoding":
+			s.Encoding = strings.ToLower(value)
+			if s.Encoding != KVP && s.Encoding != REST {
+				return nil, fmt.Errorf("Invalid WMTS encoding %s: kvp or rest expected", value)
+			}
+		default:
+			return nil, fmt.Errorf("Unknown WMTS option %s", key)
+		}
+	}
+
+	resp, err := s.client().Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("Unexpected HTTP status for %s: %s", u, resp.Status)
+	}
+
+	s.Capabilities, err = ReadCapabilities(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+//client returns the HTTP client sending the requests
+func (s *Server) client() *http.Client {
+	if s.Client == nil {
+		return http.DefaultClient
+	}
+	return s.Client
+}
+
+//ServiceURL returns the URL of the GetCapabilities document
+func (s *Server) ServiceURL() string {
+	return s.URL
+}
+
+//HTTPClient returns the client sending the requests. nil means http.DefaultClient.
+func (s *Server) HTTPClient() *http.Client {
+	return s.Client
+}
+
+//SetHTTPClient sets the client sending the requests, e.g. to apply the zxyserver options of raster.Open
+func (s *Server) SetHTTPClient(client *http.Client) {
+	s.Client = client
+}
+
+//ListTileLayers list the identifiers of the layers of the server
+func (s *Server) ListTileLayers() ([]string, error) {
+	var layers []string
+	for _, l := range s.Capabilities.Layers {
+		layers = append(layers, l.Identifier)
+	}
+	return layers, nil
+}
+
+//OpenTileLayer opens the tile layer for reading.
+//It fails if the layer has no web mercator tile matrix set, or if the preferred style, format, set or encoding is not available.
+func (s *Server) OpenTileLayer(name string) (raster.TileReader, error) {
+	l := s.Capabilities.Layer(name)
+	if l == nil {
+		return nil, raster.ErrLayerNotFund
+	}
+
+	t := &TileLayer{server: s, Name: name, Dimensions: make(map[string]string)}
+
+	//Style
+	for _, st := range l.Styles {
+		if st.Identifier == s.Style || (len(s.Style) == 0 && (st.IsDefault || len(t.Style) == 0)) {
+			t.Style = st.Identifier
+		}
+	}
+	if len(s.Style) > 0 && t.Style != s.Style {
+		return nil, fmt.Errorf("Style %s not available for WMTS layer %s", s.Style, name)
+	}
+
+	//Format
+	for _, f := range l.Formats {
+		if f == s.Format || (len(s.Format) == 0 && len(t.Format) == 0) {
+			t.Format = f
+		}
+	}
+	if len(t.Format) == 0 || (len(s.Format) > 0 && t.Format != s.Format) {
+		return nil, fmt.Errorf("Format %q not available for WMTS layer %s", s.Format, name)
+	}
+
+	//Tile matrix set: the tiles of all its levels must have the same size
+	mixed := false
+	for _, id := range l.TileMatrixSets {
+		if len(s.TileMatrixSet) > 0 && id != s.TileMatrixSet {
+			continue
+		}
+		set := s.Capabilities.TileMatrixSet(id)
+		if set == nil {
+			continue
+		}
+		if levels := set.levels(); len(levels) > 0 {
+			size, ok := tileSize(levels)
+			if !ok {
+				mixed = true
+				continue
+			}
+			t.TileMatrixSet, t.levels, t.size = id, levels, size
+			break
+		}
+	}
+	if t.levels == nil && mixed {
+		return nil, fmt.Errorf("Tile matrix sets of WMTS layer %s mix several tile sizes", name)
+	}
+	if t.levels == nil {
+		return nil, fmt.Errorf("No web mercator tile matrix set available for WMTS layer %s", name)
+	}
+
+	for _, d := range l.Dimensions {
+		t.Dimensions[d.Identifier] = d.Default
+	}
+
+	//Encoding
+	if s.Encoding != KVP {
+		for _, r := range l.ResourceURLs {
+			if strings.EqualFold(r.ResourceType, "tile") && (r.Format == t.Format || len(r.Format) == 0) {
+				t.Template = r.Template
+				break
+			}
+		}
+	}
+	if len(t.Template) == 0 {
+		t.Endpoint = s.Capabilities.kvpEndpoint()
+	}
+	if len(t.Template) == 0 && (s.Encoding == REST || len(t.Endpoint) == 0) {
+		return nil, fmt.Errorf("No GetTile endpoint available for WMTS layer %s", name)
+	}
+
+	return t, nil
+}
+
+//tileSize returns the size of the tiles of the levels, and false if the levels have different tile sizes
+func tileSize(levels map[int]levelMatrix) (int, bool) {
+	size := 0
+	for _, m := range levels {
+		if size > 0 && m.TileSize != size {
+			return 0, false
+		}
+		size = m.TileSize
+	}
+	return size, true
+}
+
+//TileLayer is the TileReader of a WMTS layer.
+type TileLayer struct {
+	server *Server
+	levels map[int]levelMatrix
+	size   int
+
+	Name          string
+	Style         string
+	Format        string
+	TileMatrixSet string
+	Dimensions    map[string]string //Values of the additional dimensions
+	Template      string            //ResourceURL template of the RESTful encoding. Empty means KVP encoding.
+	Endpoint      string            //GetTile endpoint of the KVP encoding
+}
+
+//TileFormat exposes the image format of the source (png or jpg)
+func (t *TileLayer) TileFormat() string {
+	format := strings.ToLower(t.Format)
+	if i := strings.IndexByte(format, ';'); i >= 0 {
+		format = format[:i]
+	}
+	switch format {
+	case "image/jpeg", "image/jpg":
+		return "jpg"
+	}
+	return strings.TrimPrefix(format, "image/")
+}
+
+//TileSize returns the width and height in pixels of the tiles
+func (t *TileLayer) TileSize() int {
+	return t.size
+}
+
+//locate returns the tile matrix, row and column of the tile for a given level/x/y, or false if it is outside of the tile matrix set
+func (t *TileLayer) locate(level, x, y int) (levelMatrix, int, int, bool) {
+	m, ok := t.levels[level]
+	if !ok {
+		return m, 0, 0, false
+	}
+	yosm := (1 << uint(level)) - y - 1
+	col, row := x-m.ColOffset, yosm-m.RowOffset
+	if col < 0 || row < 0 || (m.Width > 0 && col >= m.Width) || (m.Height > 0 && row >= m.Height) {
+		return m, 0, 0, false
+	}
+	return m, row, col, true
+}
+
+//GetURL returns the URL of the tile for a given level/x/y, or an empty string if it is outside of the tile matrix set.
+func (t *TileLayer) GetURL(level, x, y int) string {
+	m, row, col, ok := t.locate(level, x, y)
+	if !ok {
+		return ""
+	}
+
+	if len(t.Template) > 0 {
+		pairs := []string{
+			"{TileMatrixSet}", t.TileMatrixSet,
+			"{TileMatrix}", m.Identifier,
+			"{TileRow}", strconv.Itoa(row),
+			"{TileCol}", strconv.Itoa(col),
+			"{Style}", t.Style,
+		}
+		for k, v := range t.Dimensions {
+			pairs = append(pairs, "{"+k+"}", v)
+		}
+		return strings.NewReplacer(pairs...).Replace(t.Template)
+	}
+
+	params := url.Values{}
+	params.Set("SERVICE", "WMTS")
+	params.Set("REQUEST", "GetTile")
+	params.Set("VERSION", "1.0.0")
+	params.Set("LAYER", t.Name)
+	params.Set("STYLE", t.Style)
+	params.Set("FORMAT", t.Format)
+	params.Set("TILEMATRIXSET", t.TileMatrixSet)
+	params.Set("TILEMATRIX", m.Identifier)
+	params.Set("TILEROW", strconv.Itoa(row))
+	params.Set("TILECOL", strconv.Itoa(col))
+	for k, v := range t.Dimensions {
+		params.Set(k, v)
+	}
+
+	endpoint := t.Endpoint
+	sep := "?"
+	if strings.Contains(endpoint, "?") {
+		sep = "&"
+		if strings.HasSuffix(endpoint, "?") || strings.HasSuffix(endpoint, "&") {
+			sep = ""
+		}
+	}
+	return endpoint + sep + params.Encode()
+}
+
+//Contains returns true if the tile for a given level/x/y is in the tile matrix set of the layer
+func (t *TileLayer) Contains(level int, x, y int) (bool, error) {
+	_, _, _, ok := t.locate(level, x, y)
+	return ok, nil
+}
+
+//GetRaw retrieves the tile for a given level/x/y.
+//A tile outside of the tile matrix set, or answered with a 404 (Not Found) or 204 (No Content) status, is missing: nil is returned.
+func (t *TileLayer) GetRaw(level, x, y int) ([]byte, error) {
+	u := t.GetURL(level, x, y)
+	if len(u) == 0 {
+		return nil, nil
+	}
+
+	resp, err := t.server.client().Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 || !strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+		if err := exception(b); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Unexpected response for %s: %s %s", u, resp.Status, resp.Header.Get("Content-Type"))
+	}
+
+	return b, nil
+}
+
+//exception extracts the error reported in an OWS ExceptionReport, or returns nil
+func exception(b []byte) error {
+	var report struct {
+		XMLName    xml.Name
+		Exceptions []struct {
+			Code  string   `xml:"exceptionCode,attr"`
+			Texts []string `xml:"ExceptionText"`
+		} `xml:"Exception"`
+	}
+	if err := xml.Unmarshal(b, &report); err != nil || report.XMLName.Local != "ExceptionReport" || len(report.Exceptions) == 0 {
+		return nil
+	}
+
+	e := report.Exceptions[0]
+	msg := e.Code
+	if len(e.Texts) > 0 {
+		msg += ": " + strings.TrimSpace(e.Texts[0])
+	}
+	return errors.New("WMTS exception: " + msg)
+}
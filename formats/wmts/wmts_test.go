@@ -0,0 +1,269 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package wmts
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const capabilities = `<?xml version="1.0" encoding="UTF-8"?>
+<Capabilities xmlns="http://www.opengis.net/wmts/1.0" xmlns:ows="http://www.opengis.net/ows/1.1" xmlns:xlink="http://www.w3.org/1999/xlink" version="1.0.0">
+	<ows:OperationsMetadata>
+		<ows:Operation name="GetCapabilities">
+			<ows:DCP><ows:HTTP><ows:Get xlink:href="SERVER/capabilities?"/></ows:HTTP></ows:DCP>
+		</ows:Operation>
+		<ows:Operation name="GetTile">
+			<ows:DCP><ows:HTTP>
+				<ows:Get xlink:href="SERVER/kvp?">
+					<ows:Constraint name="GetEncoding"><ows:AllowedValues><ows:Value>KVP</ows:Value></ows:AllowedValues></ows:Constraint>
+				</ows:Get>
+			</ows:HTTP></ows:DCP>
+		</ows:Operation>
+	</ows:OperationsMetadata>
+	<Contents>
+		<Layer>
+			<ows:Title>Orthophotos</ows:Title>
+			<ows:Identifier>ortho</ows:Identifier>
+			<Style><ows:Identifier>light</ows:Identifier></Style>
+			<Style isDefault="true"><ows:Identifier>normal</ows:Identifier></Style>
+			<Format>image/jpeg</Format>
+			<Format>image/png</Format>
+			<Dimension><ows:Identifier>Time</ows:Identifier><Default>2015</Default><Value>2015</Value></Dimension>
+			<TileMatrixSetLink><TileMatrixSet>WGS84</TileMatrixSet></TileMatrixSetLink>
+			<TileMatrixSetLink><TileMatrixSet>PM</TileMatrixSet></TileMatrixSetLink>
+			<ResourceURL format="image/jpeg" resourceType="tile" template="SERVER/rest/ortho/{Style}/{Time}/{TileMatrixSet}/{TileMatrix}/{TileRow}/{TileCol}.jpg"/>
+		</Layer>
+		<Layer>
+			<ows:Identifier>geographic</ows:Identifier>
+			<Format>image/png</Format>
+			<TileMatrixSetLink><TileMatrixSet>WGS84</TileMatrixSet></TileMatrixSetLink>
+		</Layer>
+		<TileMatrixSet>
+			<ows:Identifier>PM</ows:Identifier>
+			<ows:SupportedCRS>urn:ogc:def:crs:EPSG::3857</ows:SupportedCRS>
+			<TileMatrix>
+				<ows:Identifier>0</ows:Identifier>
+				<ScaleDenominator>559082264.0287178</ScaleDenominator>
+				<TopLeftCorner>-20037508.3427892 20037508.3427892</TopLeftCorner>
+				<TileWidth>256</TileWidth><TileHeight>256</TileHeight>
+				<MatrixWidth>1</MatrixWidth><MatrixHeight>1</MatrixHeight>
+			</TileMatrix>
+			<TileMatrix>
+				<ows:Identifier>PM:1</ows:Identifier>
+				<ScaleDenominator>279541132.0143589</ScaleDenominator>
+				<TopLeftCorner>-20037508.3427892 20037508.3427892</TopLeftCorner>
+				<TileWidth>256</TileWidth><TileHeight>256</TileHeight>
+				<MatrixWidth>2</MatrixWidth><MatrixHeight>2</MatrixHeight>
+			</TileMatrix>
+			<TileMatrix>
+				<ows:Identifier>PM:2</ows:Identifier>
+				<ScaleDenominator>139770566.0071794</ScaleDenominator>
+				<TopLeftCorner>0 10018754.1713946</TopLeftCorner>
+				<TileWidth>256</TileWidth><TileHeight>256</TileHeight>
+				<MatrixWidth>2</MatrixWidth><MatrixHeight>1</MatrixHeight>
+			</TileMatrix>
+		</TileMatrixSet>
+		<TileMatrixSet>
+			<ows:Identifier>WGS84</ows:Identifier>
+			<ows:SupportedCRS>urn:ogc:def:crs:OGC:1.3:CRS84</ows:SupportedCRS>
+			<TileMatrix>
+				<ows:Identifier>0</ows:Identifier>
+				<ScaleDenominator>279541132.0143589</ScaleDenominator>
+				<TopLeftCorner>-180 90</TopLeftCorner>
+				<TileWidth>256</TileWidth><TileHeight>256</TileHeight>
+				<MatrixWidth>2</MatrixWidth><MatrixHeight>1</MatrixHeight>
+			</TileMatrix>
+		</TileMatrixSet>
+	</Contents>
+</Capabilities>`
+
+//newTestServer creates a WMTS stand-in serving the capabilities and any tile, recording the last tile request
+func newTestServer(last *string) *httptest.Server {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/capabilities":
+			w.Header().Set("Content-Type", "text/xml")
+			w.Write([]byte(strings.Replace(capabilities, "SERVER", ts.URL, -1)))
+		case strings.Contains(r.URL.Path, "/404/"):
+			http.NotFound(w, r)
+		case r.URL.Query().Get("TILEMATRIX") == "invalid":
+			w.Header().Set("Content-Type", "text/xml")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`<ExceptionReport><Exception exceptionCode="TileOutOfRange" locator="TILEROW"><ExceptionText>Row out of range</ExceptionText></Exception></ExceptionReport>`))
+		default:
+			*last = r.URL.RequestURI()
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("\x89PNG\r\n\x1a\n..."))
+		}
+	}))
+	return ts
+}
+
+func TestCapabilities(t *testing.T) {
+	c, err := ReadCapabilities(strings.NewReader(capabilities))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Layers) != 2 || len(c.TileMatrixSets) != 2 || c.kvpEndpoint() != "SERVER/kvp?" {
+		t.Fatalf("ReadCapabilities() => %+v", c)
+	}
+
+	l := c.Layer("ortho")
+	if l == nil || len(l.Styles) != 2 || !l.Styles[1].IsDefault || len(l.Formats) != 2 || len(l.ResourceURLs) != 1 || l.Dimensions[0].Default != "2015" {
+		t.Errorf("Layer(ortho) => %+v", l)
+	}
+
+	levels := c.TileMatrixSet("PM").levels()
+	expected := map[int]levelMatrix{
+		0: {"0", 0, 0, 1, 1, 256},
+		1: {"PM:1", 0, 0, 2, 2, 256},
+		2: {"PM:2", 2, 1, 2, 1, 256},
+	}
+	if len(levels) != len(expected) {
+		t.Errorf("levels() => %+v", levels)
+	}
+	for level, m := range expected {
+		if levels[level] != m {
+			t.Errorf("levels()[%d] => %+v, want %+v", level, levels[level], m)
+		}
+	}
+
+	if levels := c.TileMatrixSet("WGS84").levels(); len(levels) != 0 {
+		t.Errorf("levels() of a geographic set => %+v", levels)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	var last string
+	ts := newTestServer(&last)
+	defer ts.Close()
+
+	s, err := Open(ts.URL + "/capabilities")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := s.ListTileLayers()
+	if err != nil || strings.Join(layers, ",") != "ortho,geographic" {
+		t.Errorf("ListTileLayers() => %v, %v", layers, err)
+	}
+
+	if _, err := s.OpenTileLayer("geographic"); err == nil {
+		t.Error("OpenTileLayer() should fail without web mercator tile matrix set")
+	}
+	if _, err := s.OpenTileLayer("missing"); err == nil {
+		t.Error("OpenTileLayer() should fail on a missing layer")
+	}
+
+	if l, err := s.OpenTileLayer("ortho"); err != nil || l.(*TileLayer).TileSize() != 256 {
+		t.Errorf("OpenTileLayer(ortho) => %v", err)
+	}
+
+	//Levels of different tile sizes: level 2 made of 512 pixels tiles
+	c, err := ReadCapabilities(strings.NewReader(strings.NewReplacer(
+		"<ScaleDenominator>139770566.0071794</ScaleDenominator>", "<ScaleDenominator>69885283.0035897</ScaleDenominator>",
+		"<TileWidth>256</TileWidth><TileHeight>256</TileHeight>\n\t\t\t\t<MatrixWidth>2</MatrixWidth><MatrixHeight>1<", "<TileWidth>512</TileWidth><TileHeight>512</TileHeight>\n\t\t\t\t<MatrixWidth>2</MatrixWidth><MatrixHeight>1<",
+	).Replace(capabilities)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tileSize(c.TileMatrixSet("PM").levels()); ok {
+		t.Error("tileSize() should fail on levels of different tile sizes")
+	}
+	if _, err := (&Server{Capabilities: c}).OpenTileLayer("ortho"); err == nil {
+		t.Error("OpenTileLayer() should fail on levels of different tile sizes")
+	}
+
+	if _, err := Open(ts.URL + "/capabilities#encoding=soap"); err == nil {
+		t.Error("Open() should fail on an invalid encoding")
+	}
+	if s, err := Open(ts.URL + "/capabilities#style=dark"); err != nil {
+		t.Error(err)
+	} else if _, err := s.OpenTileLayer("ortho"); err == nil {
+		t.Error("OpenTileLayer() should fail on a missing style")
+	}
+
+	d := wmtsDriver{}
+	if !d.CanOpen("http://example.com/wmts/1.0.0/WMTSCapabilities.xml") || !d.CanOpen("http://example.com/wmts?service=WMTS&request=GetCapabilities") ||
+		d.CanOpen("http://example.com/wms?SERVICE=WMS") {
+		t.Error("CanOpen() failed")
+	}
+}
+
+func TestGetRaw(t *testing.T) {
+	var last string
+	ts := newTestServer(&last)
+	defer ts.Close()
+
+	testCases := []struct {
+		options     string
+		level, x, y int
+		expected    string
+	}{
+		//RESTful encoding: level 1, TMS y=0 is the bottom row
+		{"", 1, 1, 0, "/rest/ortho/normal/2015/PM/PM:1/1/1.jpg"},
+		//Matrix with an offset
+		{"", 2, 3, 2, "/rest/ortho/normal/2015/PM/PM:2/0/1.jpg"},
+		//KVP encoding
+		{"#encoding=kvp&style=light&format=image/png", 1, 0, 1,
+			"/kvp?FORMAT=image%2Fpng&LAYER=ortho&REQUEST=GetTile&SERVICE=WMTS&STYLE=light&TILECOL=0&TILEMATRIX=PM%3A1&TILEMATRIXSET=PM&TILEROW=0&Time=2015&VERSION=1.0.0"},
+		//No ResourceURL for png: fall back to KVP
+		{"#format=image/png", 0, 0, 0,
+			"/kvp?FORMAT=image%2Fpng&LAYER=ortho&REQUEST=GetTile&SERVICE=WMTS&STYLE=normal&TILECOL=0&TILEMATRIX=0&TILEMATRIXSET=PM&TILEROW=0&Time=2015&VERSION=1.0.0"},
+	}
+
+	for _, tc := range testCases {
+		s, err := Open(ts.URL + "/capabilities" + tc.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := s.OpenTileLayer("ortho")
+		if err != nil {
+			t.Fatal(err)
+		}
+		last = ""
+		b, err := r.GetRaw(tc.level, tc.x, tc.y)
+		if err != nil || b == nil {
+			t.Errorf("GetRaw(%s, %d, %d, %d) => %v, %v", tc.options, tc.level, tc.x, tc.y, b, err)
+		}
+		if last != tc.expected {
+			t.Errorf("GetRaw(%s, %d, %d, %d) requested %s, want %s", tc.options, tc.level, tc.x, tc.y, last, tc.expected)
+		}
+	}
+
+	s, err := Open(ts.URL + "/capabilities")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.OpenTileLayer("ortho")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.TileFormat() != "jpg" {
+		t.Errorf("TileFormat() => %s", r.TileFormat())
+	}
+
+	//Outside of the tile matrix set
+	for _, tile := range [][3]int{{2, 0, 0}, {3, 0, 0}} {
+		if ok, _ := r.Contains(tile[0], tile[1], tile[2]); ok {
+			t.Errorf("Contains(%v) => true", tile)
+		}
+		if b, err := r.GetRaw(tile[0], tile[1], tile[2]); b != nil || err != nil {
+			t.Errorf("GetRaw(%v) => %v, %v", tile, b, err)
+		}
+	}
+
+	//Exception report
+	l := r.(*TileLayer)
+	l.Template = ""
+	l.Endpoint = ts.URL + "/kvp"
+	l.levels[0] = levelMatrix{Identifier: "invalid", Width: 1, Height: 1, TileSize: 256}
+	if _, err := l.GetRaw(0, 0, 0); err == nil || !strings.Contains(err.Error(), "TileOutOfRange: Row out of range") {
+		t.Errorf("GetRaw() => %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package zxyserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+//CredentialsEnv is the environment variable giving the path of the default credentials file
+const CredentialsEnv = "RASTER_CREDENTIALS"
+
+//DefaultAPIKeyHeader is the header carrying the API key when neither APIKeyHeader nor APIKeyParam are set
+const DefaultAPIKeyHeader = "X-API-Key"
+
+//Credentials authenticates the requests sent to a tile server.
+//The API key can be combined with the basic authentication or the bearer token. Both use the Authorization header: the bearer token takes precedence.
+type Credentials struct {
+	Username string `json:"username,omitempty"` //Basic authentication
+	Password string `json:"password,omitempty"` //Basic authentication
+	Token    string `json:"token,omitempty"`    //Bearer token sent in the Authorization header
+
+	APIKey       string `json:"apikey,omitempty"`
+	APIKeyHeader string `json:"apikeyheader,omitempty"` //Header carrying the API key. Empty means DefaultAPIKeyHeader.
+	APIKeyParam  string `json:"apikeyparam,omitempty"`  //Query parameter carrying the API key, instead of a header
+}
+
+//apply adds the credentials to a request
+func (c *Credentials) apply(req *http.Request) {
+	if c == nil {
+		return
+	}
+	if len(c.Username) > 0 || len(c.Password) > 0 {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	if len(c.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if len(c.APIKey) == 0 {
+		return
+	}
+	if len(c.APIKeyParam) > 0 {
+		q := req.URL.Query()
+		q.Set(c.APIKeyParam, c.APIKey)
+		req.URL.RawQuery = q.Encode()
+		return
+	}
+	header := c.APIKeyHeader
+	if len(header) == 0 {
+		header = DefaultAPIKeyHeader
+	}
+	req.Header.Set(header, c.APIKey)
+}
+
+//CredentialSet maps host names to credentials. A host name also matches its subdomains.
+//
+//It is usually read from a JSON file:
+//
+//	{
+//		"api.example.com": {"apikey": "0123456789", "apikeyparam": "key"},
+//		"tile.example.org": {"username": "john", "password": "secret"}
+//	}
+type CredentialSet map[string]Credentials
+
+//ReadCredentials reads a JSON credentials file
+func ReadCredentials(filename string) (CredentialSet, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var s CredentialSet
+	if err := json.NewDecoder(f).Decode(&s); err != nil {
+		return nil, fmt.Errorf("Invalid credentials file %s: %s", filename, err)
+	}
+	return s, nil
+}
+
+//ForURL returns the credentials of the host of a tile URL (or URL template), or nil if none matches.
+//The longest matching host name is used.
+func (s CredentialSet) ForURL(tileURL string) *Credentials {
+	host := tileURL
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	if i := strings.IndexAny(host, "/?#"); i >= 0 {
+		host = host[:i]
+	}
+	host = strings.ToLower(host)
+
+	var found *Credentials
+	var foundLen int
+	for name, c := range s {
+		name = strings.ToLower(name)
+		if host != name && !strings.HasSuffix(host, "."+name) {
+			continue
+		}
+		if found == nil || len(name) > foundLen {
+			c := c
+			found = &c
+			foundLen = len(name)
+		}
+	}
+	return found
+}
+
+//redactURL removes the query, which may contain an API key (given by APIKeyParam or written in the URL template), from a URL reported by an error
+func redactURL(u string) string {
+	if i := strings.IndexByte(u, '?'); i >= 0 {
+		return u[:i]
+	}
+	return u
+}
+
+//redact replaces the URL reported by an error, which includes the query sent, by tileURL without its query
+func redact(err error, tileURL string) error {
+	if uerr, ok := err.(*url.Error); ok {
+		uerr.URL = redactURL(tileURL)
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package zxyserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCredentialsApply(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/0/0/0.png?style=dark", nil)
+	c := &Credentials{Username: "john", Password: "secret", Token: "abc", APIKey: "0123", APIKeyParam: "key"}
+	c.apply(req)
+	if u, p, ok := req.BasicAuth(); ok || u != "" || p != "" {
+		//The bearer token replaces the basic authentication
+		t.Errorf("BasicAuth() => %s, %s, %v", u, p, ok)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer abc" {
+		t.Errorf("Authorization => %s", got)
+	}
+	if got := req.URL.Query().Get("key"); got != "0123" || req.URL.Query().Get("style") != "dark" {
+		t.Errorf("query => %s", req.URL.RawQuery)
+	}
+
+	req, _ = http.NewRequest("GET", "http://example.com/0/0/0.png", nil)
+	c = &Credentials{Username: "john", Password: "secret", APIKey: "0123"}
+	c.apply(req)
+	if u, p, ok := req.BasicAuth(); !ok || u != "john" || p != "secret" {
+		t.Errorf("BasicAuth() => %s, %s, %v", u, p, ok)
+	}
+	if got := req.Header.Get(DefaultAPIKeyHeader); got != "0123" {
+		t.Errorf("%s => %s", DefaultAPIKeyHeader, got)
+	}
+
+	var none *Credentials
+	none.apply(req)
+}
+
+func TestReadCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zxyserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "credentials.json")
+	content := `{
+		"example.com": {"apikey": "0123", "apikeyparam": "key"},
+		"tile.example.com": {"username": "john", "password": "secret"}
+	}`
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := ReadCredentials(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := set.ForURL("http://{s}.tile.example.com/{z}/{x}/{y}.png"); c == nil || c.Username != "john" {
+		t.Errorf("ForURL(tile.example.com) => %+v", c)
+	}
+	if c := set.ForURL("https://api.example.com/{z}/{x}/{y}.png"); c == nil || c.APIKey != "0123" {
+		t.Errorf("ForURL(api.example.com) => %+v", c)
+	}
+	if c := set.ForURL("https://example.org/{z}/{x}/{y}.png"); c != nil {
+		t.Errorf("ForURL(example.org) => %+v", c)
+	}
+	if c := set.ForURL("https://notexample.com/{z}/{x}/{y}.png"); c != nil {
+		t.Errorf("ForURL(notexample.com) => %+v", c)
+	}
+
+	os.Setenv(CredentialsEnv, filename)
+	defer os.Unsetenv(CredentialsEnv)
+	r, err := ParseDataSourceName("http://a.tile.example.com/{z}/{x}/{y}.png")
+	if err != nil || r.Credentials == nil || r.Credentials.Username != "john" {
+		t.Errorf("ParseDataSourceName() => %+v, %v", r, err)
+	}
+}
+
+func TestParseDataSourceNameCredentials(t *testing.T) {
+	os.Setenv("ZXYSERVER_TEST_KEY", "0123")
+	defer os.Unsetenv("ZXYSERVER_TEST_KEY")
+
+	r, err := ParseDataSourceName("http://example.com/{z}/{x}/{y}.png#apikey=$ZXYSERVER_TEST_KEY&apikeyparam=key&header=X-Client:%20raster&header=Accept:image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Credentials == nil || r.Credentials.APIKey != "0123" || r.Credentials.APIKeyParam != "key" {
+		t.Errorf("Credentials => %+v", r.Credentials)
+	}
+	if r.Header.Get("X-Client") != "raster" || r.Header.Get("Accept") != "image/png" {
+		t.Errorf("Header => %v", r.Header)
+	}
+
+	if _, err := ParseDataSourceName("http://example.com/{z}/{x}/{y}.png#header=invalid"); err == nil {
+		t.Error("ParseDataSourceName() should fail on an invalid header")
+	}
+}
+
+func TestRedactedError(t *testing.T) {
+	ts := newTestServer()
+	ts.Close()
+
+	server := ZxyServer{URL: ts.URL + "/{z}/{x}/{y}.png", Credentials: &Credentials{APIKey: "0123", APIKeyParam: "key"}}
+	_, err := server.GetRaw(0, 0, 0)
+	if err == nil {
+		t.Fatal("GetRaw() should fail on a closed server")
+	}
+	if strings.Contains(err.Error(), "0123") {
+		t.Errorf("GetRaw() error reveals the API key: %s", err)
+	}
+
+	//Key written in the URL template
+	server = ZxyServer{URL: ts.URL + "/{z}/{x}/{y}.png?access_token=0123"}
+	if _, err := server.GetRaw(0, 0, 0); err == nil || strings.Contains(err.Error(), "0123") {
+		t.Errorf("GetRaw() => %v, want an error without the API key", err)
+	}
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+	server = ZxyServer{URL: ts.URL + "/{z}/{x}/{y}.png?access_token=0123"}
+	if _, err := server.GetRaw(0, 0, 0); err == nil || strings.Contains(err.Error(), "0123") {
+		t.Errorf("GetRaw() => %v, want an error without the API key", err)
+	}
+}
@@ -5,26 +5,256 @@
 package zxyserver
 
 import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
 
 	"github.com/xeonx/raster"
 )
 
 func init() {
-	raster.Register("zxy", newSingleLayerDriver(func(dataSourceName string) raster.TileReader {
-		return ZxyServer{URL: dataSourceName}
+	raster.Register("zxy", newSingleLayerDriver(func(dataSourceName string) (raster.TileReader, error) {
+		return ParseDataSourceName(dataSourceName)
 	}, func(dataSourceName string) bool {
 		if !strings.HasPrefix(dataSourceName, "http") {
 			return false
 		}
-		if strings.Count(dataSourceName, "%d") != 3 {
-			return false
-		}
-		return true
+		u, _ := splitDataSourceName(dataSourceName)
+		return validURL(u)
 	}))
 }
 
-func newSingleLayerDriver(createTileReader func(dataSourceName string) raster.TileReader, canOpen func(dataSourceName string) bool) raster.Driver {
+//splitDataSourceName separates the URL from the options fragment
+func splitDataSourceName(dataSourceName string) (string, string) {
+	i := strings.LastIndex(dataSourceName, "#")
+	if i < 0 {
+		return dataSourceName, ""
+	}
+	return dataSourceName[:i], dataSourceName[i+1:]
+}
+
+//ParseDataSourceName creates a ZxyServer from a URL, optionally followed by options given as a fragment:
+//
+//	http://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png#useragent=MyApp/1.0&rps=2&maxconn=2
+//
+//The available options are useragent, referer, rps (maximum requests per second per host),
+//maxconn (maximum concurrent connections per host), subdomains (comma separated), size (tile size in pixels)
+//and header (additional 'Name: value' header, may be repeated).
+//
+//Credentials are given by the username, password, token, apikey, apikeyheader and apikeyparam options,
+//or read from the credentials file named by the credentials option or by the RASTER_CREDENTIALS environment variable.
+//Environment variables ($NAME or ${NAME}) are expanded in option values, keeping secrets out of the data source name.
+func ParseDataSourceName(dataSourceName string) (*ZxyServer, error) {
+	u, fragment := splitDataSourceName(dataSourceName)
+	if !validURL(u) {
+		return nil, fmt.Errorf("Invalid zxy URL %s", u)
+	}
+	return ParseOptions(u, fragment)
+}
+
+//ParseOptions creates a ZxyServer for u with the options of a data source name, given without the leading '#'
+//(see ParseDataSourceName). u need not be a tile URL template: the ZxyServer may be used to Fetch another
+//resource, such as a TileJSON document, with the same headers, throttling and credentials as the tiles.
+func ParseOptions(u string, fragment string) (*ZxyServer, error) {
+	r := &ZxyServer{URL: u}
+
+	options, err := url.ParseQuery(fragment)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid zxy options: %s", err)
+	}
+
+	var rps float64
+	var maxConn int
+	var c Credentials
+	credentialsFile := os.Getenv(CredentialsEnv)
+	for key, values := range options {
+		value := os.ExpandEnv(values[len(values)-1])
+		switch key {
+		case "useragent":
+			r.UserAgent = value
+		case "referer":
+			r.Referer = value
+		case "subdomains":
+			r.Subdomains = strings.Split(value, ",")
+		case "size":
+			r.Size, err = strconv.Atoi(value)
+		case "rps":
+			rps, err = strconv.ParseFloat(value, 64)
+		case "maxconn":
+			maxConn, err = strconv.Atoi(value)
+		case "header":
+			r.Header = make(http.Header)
+			for _, h := range values {
+				parts := strings.SplitN(os.ExpandEnv(h), ":", 2)
+				if len(parts) != 2 {
+					return nil, fmt.Errorf("Invalid zxy option header: 'Name: value' expected")
+				}
+				r.Header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+			}
+		case "username":
+			c.Username = value
+		case "password":
+			c.Password = value
+		case "token":
+			c.Token = value
+		case "apikey":
+			c.APIKey = value
+		case "apikeyheader":
+			c.APIKeyHeader = value
+		case "apikeyparam":
+			c.APIKeyParam = value
+		case "credentials":
+			credentialsFile = value
+		default:
+			return nil, fmt.Errorf("Unknown zxy option %s", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid zxy option %s: %s", key, err)
+		}
+	}
+	if rps > 0 || maxConn > 0 {
+		r.Limiter = NewLimiter(rps, maxConn)
+	}
+
+	if c != (Credentials{}) {
+		r.Credentials = &c
+	} else if len(credentialsFile) > 0 {
+		set, err := ReadCredentials(credentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		r.Credentials = set.ForURL(r.URL)
+	}
+
+	return r, nil
+}
+
+//ServerSource is the interface implemented by the tile sources requesting their tiles from zxy servers, such as the tilejson ones.
+type ServerSource interface {
+	//ZxyServers returns the servers requested by the source
+	ZxyServers() []*ZxyServer
+}
+
+//ClientSource is the interface implemented by the tile sources sending their HTTP requests with an http.Client
+//rather than through a ZxyServer, such as the wms and wmts ones.
+type ClientSource interface {
+	//ServiceURL returns the URL of the service, used to select the credentials of the requests
+	ServiceURL() string
+	//HTTPClient returns the client sending the requests. nil means http.DefaultClient.
+	HTTPClient() *http.Client
+	//SetHTTPClient sets the client sending the requests
+	SetHTTPClient(client *http.Client)
+}
+
+//Option returns an option for raster.Open applying fct to the ZxyServer of a source opened by the zxy driver,
+//and to the ZxyServers of a ServerSource.
+//For a ClientSource, fct is applied to a ZxyServer for the ServiceURL, whose settings are then used by the HTTP client
+//of the source (see ZxyServer.HTTPClient).
+//It has no effect on the other sources.
+func Option(fct func(*ZxyServer)) func(*raster.TileSource) error {
+	return func(ts *raster.TileSource) error {
+		switch s := (*ts).(type) {
+		case singleLayerSource:
+			if r, ok := s.TileReader.(*ZxyServer); ok {
+				fct(r)
+			}
+		case ServerSource:
+			for _, r := range s.ZxyServers() {
+				fct(r)
+			}
+		case ClientSource:
+			r := clientServer(s)
+			fct(&r)
+			s.SetHTTPClient(r.HTTPClient())
+		}
+		return nil
+	}
+}
+
+//clientServer returns the ZxyServer holding the settings of the requests of a ClientSource
+func clientServer(s ClientSource) ZxyServer {
+	client := s.HTTPClient()
+	if client != nil {
+		//Settings applied by a previous option
+		if t, ok := client.Transport.(transport); ok {
+			return t.server
+		}
+	}
+	return ZxyServer{URL: s.ServiceURL(), Client: client}
+}
+
+//WithClient returns an option for raster.Open setting the HTTP client of zxy sources, e.g. to inject a test transport
+func WithClient(client *http.Client) func(*raster.TileSource) error {
+	return Option(func(r *ZxyServer) { r.Client = client })
+}
+
+//WithHeader returns an option for raster.Open adding headers to the requests of zxy sources
+func WithHeader(header http.Header) func(*raster.TileSource) error {
+	return Option(func(r *ZxyServer) {
+		if r.Header == nil {
+			r.Header = make(http.Header)
+		}
+		for key, values := range header {
+			r.Header[http.CanonicalHeaderKey(key)] = values
+		}
+	})
+}
+
+//WithCredentials returns an option for raster.Open authenticating the requests of zxy sources
+func WithCredentials(c Credentials) func(*raster.TileSource) error {
+	return Option(func(r *ZxyServer) { r.Credentials = &c })
+}
+
+//Settings are the polite fetching and credentials settings of the zxy sources, usually given by command line flags.
+//Zero values keep the options of the data source names.
+type Settings struct {
+	UserAgent         string
+	Referer           string
+	RequestsPerSecond float64 //Maximum number of requests per second per host
+	MaxConnections    int     //Maximum number of concurrent connections per host
+	CredentialsFile   string  //JSON credentials file (see ReadCredentials)
+}
+
+//WithSettings returns an option for raster.Open applying settings to zxy sources, overriding the options of the data source name
+func WithSettings(settings Settings) func(*raster.TileSource) error {
+	return func(ts *raster.TileSource) error {
+		var set CredentialSet
+		if len(settings.CredentialsFile) > 0 {
+			var err error
+			set, err = ReadCredentials(settings.CredentialsFile)
+			if err != nil {
+				return err
+			}
+		}
+
+		return Option(func(r *ZxyServer) {
+			if c := set.ForURL(r.URL); c != nil {
+				r.Credentials = c
+			}
+			if len(settings.UserAgent) > 0 {
+				r.UserAgent = settings.UserAgent
+			}
+			if len(settings.Referer) > 0 {
+				r.Referer = settings.Referer
+			}
+			if settings.RequestsPerSecond > 0 || settings.MaxConnections > 0 {
+				l := NewLimiter(settings.RequestsPerSecond, settings.MaxConnections)
+				if r.Limiter != nil && settings.RequestsPerSecond <= 0 {
+					l.RequestsPerSecond = r.Limiter.RequestsPerSecond
+				}
+				if r.Limiter != nil && settings.MaxConnections <= 0 {
+					l.MaxConnections = r.Limiter.MaxConnections
+				}
+				r.Limiter = l
+			}
+		})(ts)
+	}
+}
+
+func newSingleLayerDriver(createTileReader func(dataSourceName string) (raster.TileReader, error), canOpen func(dataSourceName string) bool) raster.Driver {
 	return singleLayerDriver{
 		createTileReader: createTileReader,
 		canOpen:          canOpen,
@@ -33,13 +263,19 @@ func newSingleLayerDriver(createTileReader func(dataSourceName string) raster.Ti
 
 type singleLayerDriver struct {
 	canOpen          func(dataSourceName string) bool
-	createTileReader func(dataSourceName string) raster.TileReader
+	createTileReader func(dataSourceName string) (raster.TileReader, error)
 }
 
 func (d singleLayerDriver) OpenTileSource(dataSourceName string) (raster.TileSource, error) {
+	r, err := d.createTileReader(dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	//The options, which may hold credentials, are not part of the layer name
+	name, _ := splitDataSourceName(dataSourceName)
 	return singleLayerSource{
-		TileReader: d.createTileReader(dataSourceName),
-		Name:       dataSourceName,
+		TileReader: r,
+		Name:       name,
 	}, nil
 }
 func (d singleLayerDriver) CanOpen(dataSourceName string) bool {
@@ -56,6 +292,8 @@ func (s singleLayerSource) ListTileLayers() ([]string, error) {
 	layers = append(layers, s.Name)
 	return layers, nil
 }
+
+//OpenTileLayer returns the reader of the source itself, so that its optional interfaces (such as raster.ConditionalReader) are visible
 func (s singleLayerSource) OpenTileLayer(name string) (raster.TileReader, error) {
-	return s, nil
+	return s.TileReader, nil
 }
@@ -0,0 +1,120 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package zxyserver
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//Limiter throttles the requests sent to each host: at most RequestsPerSecond requests per second
+//and MaxConnections concurrent requests. Hosts answering with a Retry-After header are paused for the requested delay.
+//
+//A Limiter is safe for use by multiple goroutines and may be shared by several ZxyServer.
+type Limiter struct {
+	RequestsPerSecond float64 //0 means no limit
+	MaxConnections    int     //0 means no limit
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+//hostState is the throttling state of a single host
+type hostState struct {
+	next  time.Time     //Earliest time of the next request
+	slots chan struct{} //Semaphore limiting concurrent requests
+}
+
+//NewLimiter creates a Limiter. 0 means no limit for any parameter.
+func NewLimiter(requestsPerSecond float64, maxConnections int) *Limiter {
+	return &Limiter{
+		RequestsPerSecond: requestsPerSecond,
+		MaxConnections:    maxConnections,
+	}
+}
+
+//host returns the state of a host, creating it if needed. l.mu must be held.
+func (l *Limiter) host(name string) *hostState {
+	if l.hosts == nil {
+		l.hosts = make(map[string]*hostState)
+	}
+	st, ok := l.hosts[name]
+	if !ok {
+		st = &hostState{}
+		if l.MaxConnections > 0 {
+			st.slots = make(chan struct{}, l.MaxConnections)
+		}
+		l.hosts[name] = st
+	}
+	return st
+}
+
+//acquire waits until a request can be sent to host. The returned function must be called once the request is done.
+func (l *Limiter) acquire(host string) func() {
+	if l == nil {
+		return func() {}
+	}
+
+	l.mu.Lock()
+	st := l.host(host)
+	now := time.Now()
+	start := st.next
+	if start.Before(now) {
+		start = now
+	}
+	st.next = start
+	if l.RequestsPerSecond > 0 {
+		st.next = start.Add(time.Duration(float64(time.Second) / l.RequestsPerSecond))
+	}
+	l.mu.Unlock()
+
+	time.Sleep(start.Sub(now))
+
+	if st.slots == nil {
+		return func() {}
+	}
+	st.slots <- struct{}{}
+	return func() { <-st.slots }
+}
+
+//pause delays the next requests to host by d
+func (l *Limiter) pause(host string, d time.Duration) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st := l.host(host)
+	if next := time.Now().Add(d); next.After(st.next) {
+		st.next = next
+	}
+}
+
+//retryAfter returns the delay requested by the Retry-After header of a 429 (Too Many Requests) or 503 (Service Unavailable) response.
+//It returns false if the request should not be retried.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		d := t.Sub(time.Now())
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
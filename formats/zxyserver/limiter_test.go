@@ -0,0 +1,80 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package zxyserver
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(50, 2)
+
+	start := time.Now()
+	var mu sync.Mutex
+	var active, maxActive int
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release := l.acquire("example.com")
+			mu.Lock()
+			active++
+			if active > maxActive {
+				maxActive = active
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			active--
+			mu.Unlock()
+			release()
+		}()
+	}
+	wg.Wait()
+
+	if maxActive > 2 {
+		t.Errorf("%d concurrent requests, want at most 2", maxActive)
+	}
+	//6 requests at 50 per second: the last one starts after 100ms
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("6 requests sent in %s", elapsed)
+	}
+
+	//Other hosts are not throttled
+	start = time.Now()
+	l.acquire("example.org")()
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("first request to an other host delayed by %s", elapsed)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	testCases := []struct {
+		status int
+		header string
+		delay  time.Duration
+		retry  bool
+	}{
+		{http.StatusTooManyRequests, "120", 2 * time.Minute, true},
+		{http.StatusServiceUnavailable, "0", 0, true},
+		{http.StatusServiceUnavailable, "", 0, false},
+		{http.StatusServiceUnavailable, "soon", 0, false},
+		{http.StatusInternalServerError, "10", 0, false},
+		{http.StatusTooManyRequests, "Wed, 21 Oct 2015 07:28:00 GMT", 0, true},
+	}
+
+	for _, tc := range testCases {
+		resp := &http.Response{StatusCode: tc.status, Header: http.Header{}}
+		resp.Header.Set("Retry-After", tc.header)
+		delay, retry := retryAfter(resp)
+		if delay != tc.delay || retry != tc.retry {
+			t.Errorf("retryAfter(%d, %s) => %s, %v", tc.status, tc.header, delay, retry)
+		}
+	}
+}
@@ -5,64 +5,355 @@
 package zxyserver
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xeonx/raster"
 )
 
+//DefaultUserAgent is the User-Agent sent when ZxyServer.UserAgent is empty.
+//Tile usage policies (such as the OpenStreetMap one) require a User-Agent identifying the application: set ZxyServer.UserAgent accordingly.
+var DefaultUserAgent = "xeonx-raster (+https://github.com/xeonx/raster)"
+
+//maxRetries is the maximum number of retries of a request answered with a Retry-After header
+const maxRetries = 3
+
+//maxRetryAfter is the longest Retry-After delay honoured. Longer delays are reported as errors.
+const maxRetryAfter = 5 * time.Minute
+
+//DefaultSubdomains are the subdomains used for the {s} placeholder when ZxyServer.Subdomains is empty
+var DefaultSubdomains = []string{"a", "b", "c"}
+
 //ZxyServer is the TileReader for OpenStreetMap like servers.
-//URL must contains three '%d' indicating where the level, x and y will be placed (in this order).
+//
+//URL is a template where the following placeholders are replaced for each tile:
+//
+//	{z}	level
+//	{x}	x
+//	{y}	y, counted from the top (XYZ convention used by most servers)
+//	{-y}	y, counted from the bottom (TMS convention)
+//	{s}	subdomain, rotated over Subdomains
+//	{q}	quadkey, as used by Bing Maps
+//	{r}	"@2x" for high-DPI tiles (Size of 512 or more), empty otherwise
+//
+//For backward compatibility, URL may instead contain three '%d' indicating where the level, x and y (counted from the top) will be placed (in this order).
 //
 //See http://wiki.openstreetmap.org/wiki/Tile_usage_policy before using the OpenStreetMap servers.
 type ZxyServer struct {
-	URL string //eg.: http://a.tile.openstreetmap.org/%d/%d/%d.png
+	URL        string   //eg.: http://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png or http://a.tile.openstreetmap.org/%d/%d/%d.png
+	Size       int      //Width and height in pixels of the tiles. 0 means raster.DefaultTileSize.
+	Subdomains []string //Subdomains used for the {s} placeholder. Empty means DefaultSubdomains.
+
+	UserAgent string   //User-Agent header of the requests. Empty means DefaultUserAgent.
+	Referer   string   //Optional Referer header of the requests
+	Limiter   *Limiter //Optional throttling of the requests. Retry-After headers are honoured even without Limiter.
+
+	Client      *http.Client //HTTP client sending the requests. nil means http.DefaultClient.
+	Header      http.Header  //Additional headers of the requests, overriding UserAgent and Referer
+	Credentials *Credentials //Optional authentication of the requests
+}
+
+//isTemplate returns true if url uses the placeholders syntax rather than '%d'
+func isTemplate(url string) bool {
+	return strings.Contains(url, "{z}") || strings.Contains(url, "{q}")
+}
+
+//validURL returns true if url is either a template locating the tiles or contains three '%d'
+func validURL(url string) bool {
+	if !isTemplate(url) {
+		return strings.Count(url, "%d") == 3
+	}
+	if strings.Contains(url, "{q}") {
+		return true
+	}
+	return strings.Contains(url, "{x}") && (strings.Contains(url, "{y}") || strings.Contains(url, "{-y}"))
+}
+
+//quadkey computes the Bing Maps quadkey of a tile, with y counted from the top
+func quadkey(level, x, y int) string {
+	q := make([]byte, 0, level)
+	for i := level; i > 0; i-- {
+		digit := byte('0')
+		mask := 1 << uint(i-1)
+		if x&mask != 0 {
+			digit++
+		}
+		if y&mask != 0 {
+			digit += 2
+		}
+		q = append(q, digit)
+	}
+	return string(q)
 }
 
 //TileFormat exposes the image format of the source (png or jpg)
 func (r ZxyServer) TileFormat() string {
-	ext := path.Ext(r.URL)
+	u := r.URL
+	if i := strings.IndexAny(u, "?#"); i >= 0 {
+		u = u[:i]
+	}
+	ext := path.Ext(u)
 	if len(ext) > 0 && ext[0] == '.' {
 		ext = ext[1:]
 	}
 	return ext
 }
 
+//TileSize returns the width and height in pixels of the tiles
+func (r ZxyServer) TileSize() int {
+	if r.Size <= 0 {
+		return raster.DefaultTileSize
+	}
+	return r.Size
+}
+
 //GetURL returns the URL for the given level/x/y.
 func (r ZxyServer) GetURL(level, x, y int) string {
 	var ymax = 1 << uint(level)
 	var yosm = ymax - y - 1
 
-	return fmt.Sprintf(r.URL, level, x, yosm)
+	if !isTemplate(r.URL) {
+		return fmt.Sprintf(r.URL, level, x, yosm)
+	}
+
+	subdomains := r.Subdomains
+	if len(subdomains) == 0 {
+		subdomains = DefaultSubdomains
+	}
+	retina := ""
+	if r.TileSize() >= 2*raster.DefaultTileSize {
+		retina = "@2x"
+	}
+
+	return strings.NewReplacer(
+		"{z}", strconv.Itoa(level),
+		"{x}", strconv.Itoa(x),
+		"{y}", strconv.Itoa(yosm),
+		"{-y}", strconv.Itoa(y),
+		"{s}", subdomains[(x+yosm)%len(subdomains)],
+		"{q}", quadkey(level, x, yosm),
+		"{r}", retina,
+	).Replace(r.URL)
+}
+
+//magicNumbers are the signatures of the image formats, as found at the beginning of the files
+var magicNumbers = map[string][]string{
+	"png":  {"\x89PNG\r\n\x1a\n"},
+	"jpg":  {"\xff\xd8\xff"},
+	"jpeg": {"\xff\xd8\xff"},
+	"gif":  {"GIF87a", "GIF89a"},
+}
+
+//checkContent verifies that a response body is an image of the expected format.
+//Formats without known signature are only checked not to be HTML pages.
+func checkContent(format string, contentType string, body []byte) error {
+	signatures, ok := magicNumbers[strings.ToLower(format)]
+	if !ok {
+		if strings.HasPrefix(contentType, "text/html") {
+			return fmt.Errorf("Unexpected content type '%s' for a %s tile", contentType, format)
+		}
+		return nil
+	}
+
+	for _, sig := range signatures {
+		if strings.HasPrefix(string(body), sig) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unexpected content (type '%s') for a %s tile", contentType, format)
+}
+
+//statusError creates the error reported for an unexpected HTTP status
+func statusError(url string, resp *http.Response) error {
+	return fmt.Errorf("Unexpected HTTP status for %s: %s", redactURL(url), resp.Status)
+}
+
+//do sends a request for url with optional additional headers, throttled by the Limiter.
+//Requests answered with a Retry-After header are retried after the requested delay.
+func (r ZxyServer) do(method, url string, header http.Header) (*http.Response, error) {
+	for retry := 0; ; retry++ {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		userAgent := r.UserAgent
+		if len(userAgent) == 0 {
+			userAgent = DefaultUserAgent
+		}
+		req.Header.Set("User-Agent", userAgent)
+		if len(r.Referer) > 0 {
+			req.Header.Set("Referer", r.Referer)
+		}
+		for key, values := range r.Header {
+			req.Header.Del(key)
+			for _, value := range values {
+				req.Header.Add(key, value)
+			}
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		r.Credentials.apply(req)
+
+		client := r.Client
+		if client == nil {
+			client = http.DefaultClient
+		}
+
+		release := r.Limiter.acquire(req.URL.Host)
+		resp, err := client.Do(req)
+		release()
+		if err != nil {
+			return nil, redact(err, url)
+		}
+
+		delay, ok := retryAfter(resp)
+		if !ok || retry >= maxRetries || delay > maxRetryAfter {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		if r.Limiter != nil {
+			r.Limiter.pause(req.URL.Host, delay)
+		} else {
+			time.Sleep(delay)
+		}
+	}
 }
 
 //GetRaw retrieves the tile for a given level/x/y.
+//A tile answered with a 404 (Not Found) or 204 (No Content) status is missing: nil is returned.
+//Other non-2xx statuses, and contents not matching TileFormat, are reported as errors.
 func (r ZxyServer) GetRaw(level, x, y int) ([]byte, error) {
+	rawImg, _, err := r.get(level, x, y, nil)
+	return rawImg, err
+}
+
+//isEntityTag returns true if etag is an HTTP entity tag (e.g. "xyzzy" or W/"xyzzy") rather than a content hash computed locally
+func isEntityTag(etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	return len(etag) >= 2 && etag[0] == '"' && etag[len(etag)-1] == '"'
+}
+
+//GetRawIfModified retrieves the tile for a given level/x/y, unless the server reports that it did not change since
+//a copy described by info was stored: raster.ErrNotModified is returned in that case.
+//
+//An If-None-Match header is sent if info.ETag is an HTTP entity tag (as returned by a previous call), otherwise
+//an If-Modified-Since header is sent with info.ModTime, the time the copy was stored.
+//The returned TileInfo holds the ETag of the retrieved tile, if any.
+func (r ZxyServer) GetRawIfModified(level, x, y int, info raster.TileInfo) ([]byte, raster.TileInfo, error) {
+	header := make(http.Header)
+	if isEntityTag(info.ETag) {
+		header.Set("If-None-Match", info.ETag)
+	} else if !info.ModTime.IsZero() {
+		header.Set("If-Modified-Since", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+
+	rawImg, respHeader, err := r.get(level, x, y, header)
+	if err != nil {
+		return nil, raster.TileInfo{}, err
+	}
+	return rawImg, raster.TileInfo{ETag: respHeader.Get("ETag")}, nil
+}
+
+//get retrieves the tile for a given level/x/y with optional additional request headers.
+//It returns the response headers, and raster.ErrNotModified on a 304 (Not Modified) status.
+func (r ZxyServer) get(level, x, y int, header http.Header) ([]byte, http.Header, error) {
 	url := r.GetURL(level, x, y)
 
-	resp, err := http.Get(url)
+	resp, err := r.do("GET", url, header)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, resp.Header, raster.ErrNotModified
+	}
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNoContent {
+		return nil, resp.Header, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, nil, statusError(url, resp)
+	}
+
 	rawImg, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := checkContent(r.TileFormat(), resp.Header.Get("Content-Type"), rawImg); err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", redactURL(url), err)
+	}
+
+	return rawImg, resp.Header, nil
+}
+
+//Fetch requests URL as is, rather than as a tile URL template, with the headers, throttling and credentials of r.
+//It returns the body of the response. Non-2xx statuses are reported as errors.
+func (r ZxyServer) Fetch() ([]byte, error) {
+	resp, err := r.do("GET", r.URL, nil)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, statusError(r.URL, resp)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+//HTTPClient returns an http.Client sending the requests with the headers, throttling and credentials of r, through r.Client.
+//It allows other HTTP tile sources, such as WMS servers, to be requested like the zxy servers.
+//Credentials are only sent to the host of r.URL.
+func (r ZxyServer) HTTPClient() *http.Client {
+	return &http.Client{Transport: transport{server: r}}
+}
+
+//transport is the http.RoundTripper of the clients returned by ZxyServer.HTTPClient
+type transport struct {
+	server ZxyServer
+}
+
+//RoundTrip sends a request without body with the settings of the server
+func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body.Close()
+		return nil, errors.New("Requests with a body are not supported")
+	}
 
-	return rawImg, nil
+	server := t.server
+	if u, err := url.Parse(server.URL); err != nil || !strings.EqualFold(u.Host, req.URL.Host) {
+		server.Credentials = nil
+	}
+	return server.do(req.Method, req.URL.String(), req.Header)
 }
 
-//Contains returns true if the reader already contains the tile for a given level/x/y
+//Contains returns true if the reader already contains the tile for a given level/x/y,
+//i.e. if the server answers to a HEAD request with a 2xx status other than 204 (No Content).
 func (r ZxyServer) Contains(level int, x, y int) (bool, error) {
 	url := r.GetURL(level, x, y)
 
-	resp, err := http.Head(url)
+	resp, err := r.do("HEAD", url, nil)
 	if err != nil {
 		return false, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNoContent {
+		return false, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return false, statusError(url, resp)
+	}
+
 	return true, nil
 }
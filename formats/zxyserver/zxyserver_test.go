@@ -0,0 +1,332 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package zxyserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xeonx/raster"
+)
+
+func TestGetURL(t *testing.T) {
+	testCases := []struct {
+		server   ZxyServer
+		expected string
+	}{
+		{ZxyServer{URL: "http://a.tile.openstreetmap.org/%d/%d/%d.png"}, "http://a.tile.openstreetmap.org/3/5/1.png"},
+		{ZxyServer{URL: "http://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png"}, "http://a.tile.openstreetmap.org/3/5/1.png"},
+		{ZxyServer{URL: "http://example.com/tms/{z}/{x}/{-y}.png"}, "http://example.com/tms/3/5/6.png"},
+		{ZxyServer{URL: "http://{s}.example.com/{q}.jpeg?g=1", Subdomains: []string{"t0", "t1", "t2", "t3"}}, "http://t2.example.com/103.jpeg?g=1"},
+		{ZxyServer{URL: "http://example.com/{z}/{x}/{y}{r}.png"}, "http://example.com/3/5/1.png"},
+		{ZxyServer{URL: "http://example.com/{z}/{x}/{y}{r}.png", Size: 512}, "http://example.com/3/5/1@2x.png"},
+	}
+
+	for _, tc := range testCases {
+		if got := tc.server.GetURL(3, 5, 6); got != tc.expected {
+			t.Errorf("GetURL(%s) => %s, want %s", tc.server.URL, got, tc.expected)
+		}
+	}
+
+	if got := (ZxyServer{URL: "http://example.com/{q}.png"}).GetURL(0, 0, 0); got != "http://example.com/.png" {
+		t.Errorf("GetURL() at level 0 => %s", got)
+	}
+}
+
+func TestTileFormat(t *testing.T) {
+	for url, expected := range map[string]string{
+		"http://a.tile.openstreetmap.org/%d/%d/%d.png":  "png",
+		"http://example.com/{z}/{x}/{y}.jpg?key=secret": "jpg",
+		"http://example.com/{z}/{x}/{y}{r}.png#hash":    "png",
+	} {
+		if got := (ZxyServer{URL: url}).TileFormat(); got != expected {
+			t.Errorf("TileFormat(%s) => %s, want %s", url, got, expected)
+		}
+	}
+}
+
+func TestValidURL(t *testing.T) {
+	for url, expected := range map[string]bool{
+		"http://a.tile.openstreetmap.org/%d/%d/%d.png": true,
+		"http://example.com/%d/%d.png":                 false,
+		"http://{s}.example.com/{z}/{x}/{y}.png":       true,
+		"http://example.com/{z}/{x}/{-y}.png":          true,
+		"http://example.com/{q}.png":                   true,
+		"http://example.com/{z}/{x}.png":               false,
+		"http://example.com/tile.png":                  false,
+	} {
+		if got := validURL(url); got != expected {
+			t.Errorf("validURL(%s) => %v, want %v", url, got, expected)
+		}
+	}
+}
+
+//newTestServer creates a tile server answering according to the requested path
+func newTestServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/0/0/0.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("\x89PNG\r\n\x1a\n..."))
+		case "/1/0/0.png":
+			w.WriteHeader(http.StatusNoContent)
+		case "/1/1/0.png":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>Tile not available</html>"))
+		case "/1/1/1.png":
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestGetRaw(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+	server := ZxyServer{URL: ts.URL + "/{z}/{x}/{y}.png"}
+
+	testCases := []struct {
+		level, x, y   int
+		found         bool
+		fails         bool
+		contains      bool
+		containsFails bool
+	}{
+		{0, 0, 0, true, false, true, false},
+		{1, 0, 1, false, false, false, false}, //204
+		{1, 1, 1, false, true, true, false},   //HTML page
+		{1, 1, 0, false, true, false, true},   //503
+		{1, 0, 0, false, false, false, false}, //404
+	}
+
+	for _, tc := range testCases {
+		raw, err := server.GetRaw(tc.level, tc.x, tc.y)
+		if (err != nil) != tc.fails || (raw != nil) != tc.found {
+			t.Errorf("GetRaw(%d, %d, %d) => %v, %v", tc.level, tc.x, tc.y, raw, err)
+		}
+
+		contains, err := server.Contains(tc.level, tc.x, tc.y)
+		if contains != tc.contains || (err != nil) != tc.containsFails {
+			t.Errorf("Contains(%d, %d, %d) => %v, %v", tc.level, tc.x, tc.y, contains, err)
+		}
+	}
+}
+
+func TestCheckContent(t *testing.T) {
+	if err := checkContent("jpg", "image/jpeg", []byte("\xff\xd8\xff\xe0")); err != nil {
+		t.Error(err)
+	}
+	if err := checkContent("jpg", "image/png", []byte("\x89PNG\r\n\x1a\n")); err == nil {
+		t.Error("checkContent() should fail on a PNG content for a jpg tile")
+	}
+	if err := checkContent("pbf", "application/x-protobuf", []byte{0x1a}); err != nil {
+		t.Error(err)
+	}
+	if err := checkContent("pbf", "text/html; charset=utf-8", []byte("<html>")); err == nil {
+		t.Error("checkContent() should fail on HTML content")
+	}
+}
+
+func TestParseDataSourceName(t *testing.T) {
+	r, err := ParseDataSourceName("http://{s}.example.com/{z}/{x}/{y}.png#useragent=MyApp%2F1.0&referer=http://example.org/&rps=2&maxconn=4&subdomains=t0,t1&size=512")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.URL != "http://{s}.example.com/{z}/{x}/{y}.png" || r.UserAgent != "MyApp/1.0" || r.Referer != "http://example.org/" ||
+		len(r.Subdomains) != 2 || r.Size != 512 {
+		t.Errorf("ParseDataSourceName() => %+v", r)
+	}
+	if r.Limiter == nil || r.Limiter.RequestsPerSecond != 2 || r.Limiter.MaxConnections != 4 {
+		t.Errorf("ParseDataSourceName() => limiter %+v", r.Limiter)
+	}
+
+	r, err = ParseDataSourceName("http://a.tile.openstreetmap.org/%d/%d/%d.png")
+	if err != nil || r.Limiter != nil || r.URL != "http://a.tile.openstreetmap.org/%d/%d/%d.png" {
+		t.Errorf("ParseDataSourceName() => %+v, %v", r, err)
+	}
+
+	for _, dsn := range []string{
+		"http://example.com/{z}/{x}/{y}.png#unknown=1",
+		"http://example.com/{z}/{x}/{y}.png#rps=fast",
+		"http://example.com/tiles.png#rps=2",
+	} {
+		if _, err := ParseDataSourceName(dsn); err == nil {
+			t.Errorf("ParseDataSourceName(%s) should fail", dsn)
+		}
+	}
+}
+
+func TestPoliteRequests(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("User-Agent") != "MyApp/1.0" || r.Header.Get("Referer") != "http://example.org/" {
+			t.Errorf("Unexpected headers %v", r.Header)
+		}
+		if requests == 1 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG\r\n\x1a\n..."))
+	}))
+	defer ts.Close()
+
+	server := ZxyServer{
+		URL:       ts.URL + "/{z}/{x}/{y}.png",
+		UserAgent: "MyApp/1.0",
+		Referer:   "http://example.org/",
+		Limiter:   NewLimiter(100, 1),
+	}
+	raw, err := server.GetRaw(0, 0, 0)
+	if err != nil || raw == nil {
+		t.Fatalf("GetRaw() => %v, %v", raw, err)
+	}
+	if requests != 2 {
+		t.Errorf("%d requests sent, want 2", requests)
+	}
+}
+
+func TestOpenOptions(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Client") != "raster" || r.Header.Get("Authorization") != "Bearer abc" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG\r\n\x1a\n..."))
+	}))
+	defer ts.Close()
+
+	header := make(http.Header)
+	header.Set("X-Client", "raster")
+	source, err := raster.Open("zxy", ts.URL+"/{z}/{x}/{y}.png",
+		WithClient(ts.Client()), WithHeader(header), WithCredentials(Credentials{Token: "abc"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := raster.OpenTileLayerAt(source, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := reader.GetRaw(0, 0, 0)
+	if err != nil || raw == nil {
+		t.Errorf("GetRaw() => %v, %v", raw, err)
+	}
+}
+
+func TestWithSettings(t *testing.T) {
+	source, err := raster.Open("zxy", "http://example.com/{z}/{x}/{y}.png#useragent=Old&referer=http://example.org/&rps=5",
+		WithSettings(Settings{UserAgent: "New", MaxConnections: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := raster.OpenTileLayerAt(source, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := reader.(*ZxyServer)
+	if r.UserAgent != "New" || r.Referer != "http://example.org/" || r.Limiter.RequestsPerSecond != 5 || r.Limiter.MaxConnections != 2 {
+		t.Errorf("WithSettings() => %+v, limiter %+v", r, r.Limiter)
+	}
+
+	_, err = raster.Open("zxy", "http://example.com/{z}/{x}/{y}.png", WithSettings(Settings{CredentialsFile: "missing.json"}))
+	if err == nil {
+		t.Error("WithSettings() should fail on a missing credentials file")
+	}
+}
+
+func TestGetRawIfModified(t *testing.T) {
+	lastModified := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "0.png", lastModified, strings.NewReader("\x89PNG\r\n\x1a\n..."))
+	}))
+	defer ts.Close()
+	server := ZxyServer{URL: ts.URL + "/{z}/{x}/{y}.png"}
+
+	testCases := []struct {
+		info        raster.TileInfo
+		notModified bool
+	}{
+		{raster.TileInfo{}, false},
+		{raster.TileInfo{ETag: `"v1"`}, true},
+		{raster.TileInfo{ETag: `"v0"`}, false},
+		{raster.TileInfo{ETag: `W/"v1"`}, true},
+		{raster.TileInfo{ModTime: lastModified.Add(time.Hour)}, true},
+		{raster.TileInfo{ModTime: lastModified.Add(-time.Hour)}, false},
+		//Content hashes are not sent as If-None-Match
+		{raster.TileInfo{ModTime: lastModified.Add(time.Hour), ETag: "8154f2ab366901a6744c15cef7c62eba"}, true},
+	}
+
+	for _, tc := range testCases {
+		raw, info, err := server.GetRawIfModified(0, 0, 0, tc.info)
+		if tc.notModified {
+			if err != raster.ErrNotModified {
+				t.Errorf("GetRawIfModified(%+v) => %v, %v, want not modified", tc.info, raw, err)
+			}
+			continue
+		}
+		if err != nil || raw == nil || info.ETag != `"v1"` {
+			t.Errorf("GetRawIfModified(%+v) => %v, %+v, %v", tc.info, raw, info, err)
+		}
+	}
+}
+
+func TestOpenConditional(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "0.png", time.Time{}, strings.NewReader("\x89PNG\r\n\x1a\n..."))
+	}))
+	defer ts.Close()
+
+	source, err := raster.Open("zxy", ts.URL+"/{z}/{x}/{y}.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := raster.OpenTileLayerAt(source, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr, ok := reader.(raster.ConditionalReader)
+	if !ok {
+		t.Fatal("Layers opened by the zxy driver should implement raster.ConditionalReader")
+	}
+	if _, _, err := cr.GetRawIfModified(0, 0, 0, raster.TileInfo{ETag: `"v1"`}); err != raster.ErrNotModified {
+		t.Errorf("GetRawIfModified() => %v, want not modified", err)
+	}
+}
+
+func TestOpenSize(t *testing.T) {
+	source, err := raster.Open("zxy", "http://example.com/{z}/{x}/{y}{r}.png#size=512")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := raster.OpenTileLayerAt(source, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := raster.TileSize(reader); size != 512 {
+		t.Errorf("TileSize() => %d, want 512", size)
+	}
+}
+
+func TestLayerName(t *testing.T) {
+	source, err := raster.Open("zxy", "http://example.com/{z}/{x}/{y}.png#apikey=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := source.ListTileLayers()
+	if err != nil || len(layers) != 1 || layers[0] != "http://example.com/{z}/{x}/{y}.png" {
+		t.Errorf("ListTileLayers() => %v, %v", layers, err)
+	}
+}
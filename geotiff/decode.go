@@ -0,0 +1,327 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+//Additional TIFF tags and values used by the decoder
+const (
+	tagPredictor = 317
+	tagColorMap  = 320
+
+	typeByte     = 1
+	typeASCII    = 2
+	typeRational = 5
+	typeFloat    = 11
+
+	compressionAdobeDeflate = 32946
+	photometricWhiteIsZero  = 0
+	photometricBlackIsZero  = 1
+	photometricPalette      = 3
+	extraSampleAssociated   = 1
+	predictorHorizontal     = 2
+	rasterPixelIsPoint      = 2
+)
+
+//MaxPixels is the maximum number of pixels of an image read by Decode.
+//The default limits the decoded image to 1 GiB of memory (4 bytes per pixel).
+var MaxPixels = 1 << 28
+
+//maxSamples is the maximum number of samples per pixel, including extra samples
+const maxSamples = 8
+
+//decoder holds the state of the decoding of a TIFF file
+type decoder struct {
+	data      []byte
+	byteOrder binary.ByteOrder
+	tags      map[uint16][]float64
+}
+
+//tag returns the nth value of a tag, or def if not available
+func (d *decoder) tag(tag uint16, n int, def float64) float64 {
+	values := d.tags[tag]
+	if n >= len(values) {
+		return def
+	}
+	return values[n]
+}
+
+//readIFD reads the first image file directory
+func (d *decoder) readIFD() error {
+	if len(d.data) < 8 {
+		return errors.New("geotiff: invalid header")
+	}
+	switch string(d.data[:4]) {
+	case "II*\x00":
+		d.byteOrder = binary.LittleEndian
+	case "MM\x00*":
+		d.byteOrder = binary.BigEndian
+	default:
+		return errors.New("geotiff: invalid header")
+	}
+
+	offset := int(d.byteOrder.Uint32(d.data[4:]))
+	if offset+2 > len(d.data) {
+		return errors.New("geotiff: invalid IFD offset")
+	}
+	count := int(d.byteOrder.Uint16(d.data[offset:]))
+	if offset+2+12*count > len(d.data) {
+		return errors.New("geotiff: invalid IFD")
+	}
+
+	sizes := map[uint16]int{typeByte: 1, typeASCII: 1, typeShort: 2, typeLong: 4, typeRational: 8, typeFloat: 4, typeDouble: 8}
+	d.tags = make(map[uint16][]float64)
+	for i := 0; i < count; i++ {
+		e := d.data[offset+2+12*i:]
+		tag, datatype, n := d.byteOrder.Uint16(e), d.byteOrder.Uint16(e[2:]), int(d.byteOrder.Uint32(e[4:]))
+		size, ok := sizes[datatype]
+		if !ok || datatype == typeASCII {
+			continue
+		}
+		values := e[8:12]
+		if size*n > 4 {
+			valuesOffset := int(d.byteOrder.Uint32(e[8:]))
+			if valuesOffset+size*n > len(d.data) {
+				return fmt.Errorf("geotiff: invalid offset for tag %d", tag)
+			}
+			values = d.data[valuesOffset:]
+		}
+		for j := 0; j < n; j++ {
+			v := values[j*size:]
+			var f float64
+			switch datatype {
+			case typeByte:
+				f = float64(v[0])
+			case typeShort:
+				f = float64(d.byteOrder.Uint16(v))
+			case typeLong:
+				f = float64(d.byteOrder.Uint32(v))
+			case typeRational:
+				f = float64(d.byteOrder.Uint32(v)) / float64(d.byteOrder.Uint32(v[4:]))
+			case typeFloat:
+				f = float64(math.Float32frombits(d.byteOrder.Uint32(v)))
+			case typeDouble:
+				f = math.Float64frombits(d.byteOrder.Uint64(v))
+			}
+			d.tags[tag] = append(d.tags[tag], f)
+		}
+	}
+	return nil
+}
+
+//georeference decodes the georeferencing tags
+func (d *decoder) georeference() (Georeference, error) {
+	var ref Georeference
+
+	scale, tiepoint := d.tags[tagModelPixelScale], d.tags[tagModelTiepoint]
+	if len(scale) < 2 || len(tiepoint) < 6 {
+		return ref, errors.New("geotiff: missing ModelPixelScale or ModelTiepoint tag")
+	}
+	ref.PixelSizeX, ref.PixelSizeY = scale[0], scale[1]
+	ref.OriginX = tiepoint[3] - tiepoint[0]*ref.PixelSizeX
+	ref.OriginY = tiepoint[4] + tiepoint[1]*ref.PixelSizeY
+
+	keys := d.tags[tagGeoKeyDirectory]
+	for i := 4; i+3 < len(keys); i += 4 {
+		//Only keys stored directly in the directory are used
+		if keys[i+1] != 0 {
+			continue
+		}
+		switch keys[i] {
+		case keyProjectedCSType, keyGeographicType:
+			if ref.EPSG == 0 || keys[i] == keyProjectedCSType {
+				ref.EPSG = int(keys[i+3])
+			}
+		case keyGTRasterType:
+			if keys[i+3] == rasterPixelIsPoint {
+				ref.OriginX -= ref.PixelSizeX / 2
+				ref.OriginY += ref.PixelSizeY / 2
+			}
+		}
+	}
+
+	//Legacy codes of web mercator
+	switch ref.EPSG {
+	case 900913, 3785, 102100, 102113:
+		ref.EPSG = EPSG3857
+	}
+
+	return ref, nil
+}
+
+//block returns the decompressed data of the nth strip or tile
+func (d *decoder) block(offsetTag, countTag uint16, n int) ([]byte, error) {
+	offset, count := int(d.tag(offsetTag, n, -1)), int(d.tag(countTag, n, -1))
+	if offset < 0 || count < 0 || offset+count > len(d.data) {
+		return nil, errors.New("geotiff: invalid strip or tile offset")
+	}
+	data := d.data[offset : offset+count]
+
+	switch int(d.tag(tagCompression, 0, compressionNone)) {
+	case compressionNone:
+		return data, nil
+	case compressionDeflate, compressionAdobeDeflate:
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return ioutil.ReadAll(zr)
+	}
+	return nil, fmt.Errorf("geotiff: unsupported compression %d", int(d.tag(tagCompression, 0, 0)))
+}
+
+//Decode reads a GeoTIFF image from r and returns the image and its georeference.
+//
+//Supported images are 8-bit gray, paletted, RGB or RGBA images with interleaved samples, in strips or tiles,
+//uncompressed or compressed with deflate (with or without horizontal predictor).
+func Decode(r io.Reader) (image.Image, Georeference, error) {
+	var ref Georeference
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, ref, err
+	}
+	d := &decoder{data: data}
+	if err := d.readIFD(); err != nil {
+		return nil, ref, err
+	}
+
+	ref, err = d.georeference()
+	if err != nil {
+		return nil, ref, err
+	}
+
+	if w, h := d.tag(tagImageWidth, 0, 0), d.tag(tagImageLength, 0, 0); w <= 0 || h <= 0 {
+		return nil, ref, errors.New("geotiff: invalid image size")
+	} else if w*h > float64(MaxPixels) {
+		return nil, ref, fmt.Errorf("geotiff: image too large: %.0fx%.0f pixels, the maximum is %d pixels", w, h, MaxPixels)
+	}
+	width, height := int(d.tag(tagImageWidth, 0, 0)), int(d.tag(tagImageLength, 0, 0))
+	samples := int(d.tag(tagSamplesPerPixel, 0, 1))
+	photometric := int(d.tag(tagPhotometricInterpretation, 0, photometricBlackIsZero))
+	if samples < 1 || samples > maxSamples {
+		return nil, ref, fmt.Errorf("geotiff: unsupported samples per pixel %d", samples)
+	}
+	for i := 0; i < samples; i++ {
+		if d.tag(tagBitsPerSample, i, 8) != 8 {
+			return nil, ref, errors.New("geotiff: only 8-bit samples are supported")
+		}
+	}
+	if d.tag(tagPlanarConfiguration, 0, 1) != 1 {
+		return nil, ref, errors.New("geotiff: only interleaved samples are supported")
+	}
+	if photometric == photometricPalette && len(d.tags[tagColorMap]) < 3*256 {
+		return nil, ref, errors.New("geotiff: missing color map")
+	}
+	if photometric == photometricRGB && samples < 3 {
+		return nil, ref, errors.New("geotiff: invalid samples per pixel")
+	}
+	if photometric > photometricPalette {
+		return nil, ref, fmt.Errorf("geotiff: unsupported photometric interpretation %d", photometric)
+	}
+	predictor := d.tag(tagPredictor, 0, 1) == predictorHorizontal
+	premultiplied := d.tag(tagExtraSamples, 0, 0) == extraSampleAssociated
+
+	//Strips are handled as tiles having the width of the image
+	blockWidth, blockHeight := width, int(d.tag(tagRowsPerStrip, 0, float64(height)))
+	offsetTag, countTag := uint16(tagStripOffsets), uint16(tagStripByteCounts)
+	if _, tiled := d.tags[tagTileWidth]; tiled {
+		blockWidth, blockHeight = int(d.tag(tagTileWidth, 0, 0)), int(d.tag(tagTileLength, 0, 0))
+		offsetTag, countTag = tagTileOffsets, tagTileByteCounts
+	}
+	if blockWidth <= 0 || blockHeight <= 0 {
+		return nil, ref, errors.New("geotiff: invalid strip or tile size")
+	}
+	if blockHeight > height {
+		blockHeight = height
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	n := 0
+	for by := 0; by < height; by += blockHeight {
+		for bx := 0; bx < width; bx += blockWidth {
+			data, err := d.block(offsetTag, countTag, n)
+			if err != nil {
+				return nil, ref, err
+			}
+			n++
+
+			rowLength := blockWidth * samples
+			for y := 0; y < blockHeight && by+y < height; y++ {
+				if (y+1)*rowLength > len(data) {
+					break
+				}
+				row := data[y*rowLength : (y+1)*rowLength]
+				if predictor {
+					for i := samples; i < len(row); i++ {
+						row[i] += row[i-samples]
+					}
+				}
+				for x := 0; x < blockWidth && bx+x < width; x++ {
+					img.SetNRGBA(bx+x, by+y, d.pixel(row[x*samples:(x+1)*samples], photometric, premultiplied))
+				}
+			}
+		}
+	}
+
+	return img, ref, nil
+}
+
+//pixel converts the samples of a pixel into a color
+func (d *decoder) pixel(s []byte, photometric int, premultiplied bool) color.NRGBA {
+	var c color.NRGBA
+	switch photometric {
+	case photometricWhiteIsZero:
+		c = color.NRGBA{255 - s[0], 255 - s[0], 255 - s[0], 255}
+	case photometricBlackIsZero:
+		c = color.NRGBA{s[0], s[0], s[0], 255}
+	case photometricPalette:
+		//Color map values are 16-bit: red values, then green values, then blue values
+		c = color.NRGBA{
+			uint8(int(d.tag(tagColorMap, int(s[0]), 0)) >> 8),
+			uint8(int(d.tag(tagColorMap, 256+int(s[0]), 0)) >> 8),
+			uint8(int(d.tag(tagColorMap, 512+int(s[0]), 0)) >> 8),
+			255,
+		}
+	default:
+		c = color.NRGBA{s[0], s[1], s[2], 255}
+	}
+
+	//Extra sample is alpha
+	alphaIndex := 1
+	if photometric == photometricRGB {
+		alphaIndex = 3
+	}
+	if len(s) > alphaIndex && d.tags[tagExtraSamples] != nil {
+		c.A = s[alphaIndex]
+		if premultiplied && c.A > 0 && c.A < 255 {
+			c.R = unpremultiply(c.R, c.A)
+			c.G = unpremultiply(c.G, c.A)
+			c.B = unpremultiply(c.B, c.A)
+		}
+	}
+	return c
+}
+
+//unpremultiply converts a color component premultiplied by alpha into a non premultiplied one
+func unpremultiply(v, alpha uint8) uint8 {
+	u := int(v) * 255 / int(alpha)
+	if u > 255 {
+		return 255
+	}
+	return uint8(u)
+}
@@ -0,0 +1,74 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"image"
+	"image/draw"
+	"io"
+
+	"github.com/xeonx/geographic"
+	"github.com/xeonx/raster"
+)
+
+//toGeographic reprojects a mosaic into EPSG:4326.
+//As longitudes are linear in both systems, only the rows are resampled (nearest neighbour), keeping the image size.
+func toGeographic(m *raster.Mosaic) (image.Image, Georeference) {
+	bounds := m.Image.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	lonMin := raster.Meters2Lon(m.OriginX)
+	lonMax := raster.Meters2Lon(m.OriginX + float64(width)*m.PixelSize)
+	latMax := raster.Meters2Lat(m.OriginY)
+	latMin := raster.Meters2Lat(m.OriginY - float64(height)*m.PixelSize)
+	pixelSizeY := (latMax - latMin) / float64(height)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for row := 0; row < height; row++ {
+		lat := latMax - (float64(row)+0.5)*pixelSizeY
+		srcRow := int((m.OriginY - raster.Lat2Meters(lat)) / m.PixelSize)
+		if srcRow < 0 {
+			srcRow = 0
+		}
+		if srcRow >= height {
+			srcRow = height - 1
+		}
+		draw.Draw(dst, image.Rect(0, row, width, row+1), m.Image, bounds.Min.Add(image.Pt(0, srcRow)), draw.Src)
+	}
+
+	return dst, Georeference{
+		EPSG:       EPSG4326,
+		OriginX:    lonMin,
+		OriginY:    latMax,
+		PixelSizeX: (lonMax - lonMin) / float64(width),
+		PixelSizeY: pixelSizeY,
+	}
+}
+
+//EncodeMosaic writes a mosaic to w in GeoTIFF format, in the given coordinate reference system (EPSG3857 or EPSG4326).
+func EncodeMosaic(w io.Writer, m *raster.Mosaic, epsg int, opts *Options) error {
+	if epsg == EPSG4326 {
+		img, ref := toGeographic(m)
+		return Encode(w, img, ref, opts)
+	}
+
+	return Encode(w, m.Image, Georeference{
+		EPSG:       epsg,
+		OriginX:    m.OriginX,
+		OriginY:    m.OriginY,
+		PixelSizeX: m.PixelSize,
+		PixelSizeY: m.PixelSize,
+	}, opts)
+}
+
+//Export writes the tiles of r covering bbox at the given level to w as a single GeoTIFF image,
+//in the given coordinate reference system (EPSG3857 or EPSG4326).
+func Export(w io.Writer, r raster.TileReader, bbox geographic.BoundingBox, level int, epsg int, opts *Options) error {
+	m, err := raster.NewMosaic(r, bbox, level)
+	if err != nil {
+		return err
+	}
+	return EncodeMosaic(w, m, epsg, opts)
+}
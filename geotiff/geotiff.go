@@ -0,0 +1,337 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+/*
+Package geotiff provides a pure Go GeoTIFF encoder, allowing to export tile layers as georeferenced images.
+
+Images are written as 8-bit RGBA TIFF (baseline TIFF 6.0 with unassociated alpha), either in strips or internally tiled,
+optionally compressed with deflate. The georeferencing is stored in the ModelPixelScale, ModelTiepoint and GeoKeyDirectory tags,
+for the EPSG:3857 (web mercator) and EPSG:4326 (WGS 84) coordinate reference systems.
+
+For more information on GeoTIFF format, see http://www.remotesensing.org/geotiff/spec/geotiffhome.html .
+*/
+package geotiff
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+//Supported coordinate reference systems
+const (
+	EPSG3857 = 3857 //Web mercator, in meters
+	EPSG4326 = 4326 //WGS 84 latitude/longitude, in degrees
+)
+
+//Georeference locates an image in a coordinate reference system.
+type Georeference struct {
+	EPSG       int     //Coordinate reference system (EPSG3857 or EPSG4326)
+	OriginX    float64 //X (or longitude) of the top-left corner of the top-left pixel
+	OriginY    float64 //Y (or latitude) of the top-left corner of the top-left pixel
+	PixelSizeX float64 //Width of a pixel in units of the coordinate reference system
+	PixelSizeY float64 //Height of a pixel in units of the coordinate reference system
+}
+
+//Options configures the GeoTIFF encoding.
+type Options struct {
+	TileSize int  //Width and height of the internal tiles, multiple of 16. 0 means the image is written in strips.
+	Deflate  bool //Compress the image data with deflate
+}
+
+//TIFF tags
+const (
+	tagImageWidth                = 256
+	tagImageLength               = 257
+	tagBitsPerSample             = 258
+	tagCompression               = 259
+	tagPhotometricInterpretation = 262
+	tagStripOffsets              = 273
+	tagSamplesPerPixel           = 277
+	tagRowsPerStrip              = 278
+	tagStripByteCounts           = 279
+	tagPlanarConfiguration       = 284
+	tagTileWidth                 = 322
+	tagTileLength                = 323
+	tagTileOffsets               = 324
+	tagTileByteCounts            = 325
+	tagExtraSamples              = 338
+	tagSampleFormat              = 339
+	tagModelPixelScale           = 33550
+	tagModelTiepoint             = 33922
+	tagGeoKeyDirectory           = 34735
+)
+
+//TIFF field types
+const (
+	typeShort  = 3
+	typeLong   = 4
+	typeDouble = 12
+)
+
+//GeoKeys
+const (
+	keyGTModelType          = 1024
+	keyGTRasterType         = 1025
+	keyGeographicType       = 2048
+	keyGeogAngularUnits     = 2054
+	keyProjectedCSType      = 3072
+	keyProjLinearUnits      = 3076
+	modelTypeProjected      = 1
+	modelTypeGeographic     = 2
+	rasterPixelIsArea       = 1
+	angularUnitDegree       = 9102
+	linearUnitMeter         = 9001
+	compressionNone         = 1
+	compressionDeflate      = 8
+	photometricRGB          = 2
+	extraSampleUnassociated = 2
+)
+
+//stripSize is the approximate size in bytes of the uncompressed strips
+const stripSize = 64 * 1024
+
+//ifdEntry is a single TIFF directory entry
+type ifdEntry struct {
+	tag      uint16
+	datatype uint16
+	shorts   []uint16
+	longs    []uint32
+	doubles  []float64
+}
+
+func (e ifdEntry) count() uint32 {
+	switch e.datatype {
+	case typeShort:
+		return uint32(len(e.shorts))
+	case typeLong:
+		return uint32(len(e.longs))
+	}
+	return uint32(len(e.doubles))
+}
+
+//data returns the encoded values of the entry
+func (e ifdEntry) data() []byte {
+	var b bytes.Buffer
+	switch e.datatype {
+	case typeShort:
+		binary.Write(&b, binary.LittleEndian, e.shorts)
+	case typeLong:
+		binary.Write(&b, binary.LittleEndian, e.longs)
+	default:
+		binary.Write(&b, binary.LittleEndian, e.doubles)
+	}
+	return b.Bytes()
+}
+
+//geoEntries returns the GeoKeyDirectory and the ModelPixelScale and ModelTiepoint entries for a georeference
+func geoEntries(ref Georeference) ([]ifdEntry, error) {
+	var keys [][4]uint16
+	switch ref.EPSG {
+	case EPSG3857:
+		keys = [][4]uint16{
+			{keyGTModelType, 0, 1, modelTypeProjected},
+			{keyGTRasterType, 0, 1, rasterPixelIsArea},
+			{keyProjectedCSType, 0, 1, EPSG3857},
+			{keyProjLinearUnits, 0, 1, linearUnitMeter},
+		}
+	case EPSG4326:
+		keys = [][4]uint16{
+			{keyGTModelType, 0, 1, modelTypeGeographic},
+			{keyGTRasterType, 0, 1, rasterPixelIsArea},
+			{keyGeographicType, 0, 1, EPSG4326},
+			{keyGeogAngularUnits, 0, 1, angularUnitDegree},
+		}
+	default:
+		return nil, fmt.Errorf("geotiff: unsupported coordinate reference system EPSG:%d", ref.EPSG)
+	}
+
+	//Header: version 1.1.0 and number of keys
+	directory := []uint16{1, 1, 0, uint16(len(keys))}
+	for _, k := range keys {
+		directory = append(directory, k[:]...)
+	}
+
+	return []ifdEntry{
+		{tag: tagModelPixelScale, datatype: typeDouble, doubles: []float64{ref.PixelSizeX, ref.PixelSizeY, 0}},
+		{tag: tagModelTiepoint, datatype: typeDouble, doubles: []float64{0, 0, 0, ref.OriginX, ref.OriginY, 0}},
+		{tag: tagGeoKeyDirectory, datatype: typeShort, shorts: directory},
+	}, nil
+}
+
+//block returns the pixels of the rectangle r of img as RGBA bytes. Pixels outside the image are transparent.
+func block(img image.Image, r image.Rectangle) []byte {
+	b := make([]byte, 0, 4*r.Dx()*r.Dy())
+	bounds := img.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			p := image.Pt(x, y).Add(bounds.Min)
+			if !p.In(bounds) {
+				b = append(b, 0, 0, 0, 0)
+				continue
+			}
+			c := color.NRGBAModel.Convert(img.At(p.X, p.Y)).(color.NRGBA)
+			b = append(b, c.R, c.G, c.B, c.A)
+		}
+	}
+	return b
+}
+
+//compress compresses a block of data if requested
+func compress(data []byte, deflate bool) ([]byte, error) {
+	if !deflate {
+		return data, nil
+	}
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+//Encode writes the image img to w in GeoTIFF format, georeferenced by ref.
+//A nil opts writes an uncompressed image in strips.
+func Encode(w io.Writer, img image.Image, ref Georeference, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.TileSize < 0 || opts.TileSize%16 != 0 {
+		return errors.New("geotiff: tile size must be a multiple of 16")
+	}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	if width <= 0 || height <= 0 {
+		return errors.New("geotiff: empty image")
+	}
+
+	geo, err := geoEntries(ref)
+	if err != nil {
+		return err
+	}
+
+	//Image data, in strips or tiles
+	var blocks []image.Rectangle
+	if opts.TileSize > 0 {
+		for y := 0; y < height; y += opts.TileSize {
+			for x := 0; x < width; x += opts.TileSize {
+				blocks = append(blocks, image.Rect(x, y, x+opts.TileSize, y+opts.TileSize))
+			}
+		}
+	} else {
+		rowsPerStrip := stripSize / (4 * width)
+		if rowsPerStrip < 1 {
+			rowsPerStrip = 1
+		}
+		for y := 0; y < height; y += rowsPerStrip {
+			bottom := y + rowsPerStrip
+			if bottom > height {
+				bottom = height
+			}
+			blocks = append(blocks, image.Rect(0, y, width, bottom))
+		}
+	}
+
+	//Blocks are encoded first, as their size is required to write the IFD before them
+	data := make([][]byte, len(blocks))
+	for i, r := range blocks {
+		data[i], err = compress(block(img, r), opts.Deflate)
+		if err != nil {
+			return err
+		}
+	}
+	offsets := make([]uint32, len(blocks))
+	byteCounts := make([]uint32, len(blocks))
+
+	compression := uint16(compressionNone)
+	if opts.Deflate {
+		compression = compressionDeflate
+	}
+	entries := []ifdEntry{
+		{tag: tagImageWidth, datatype: typeLong, longs: []uint32{uint32(width)}},
+		{tag: tagImageLength, datatype: typeLong, longs: []uint32{uint32(height)}},
+		{tag: tagBitsPerSample, datatype: typeShort, shorts: []uint16{8, 8, 8, 8}},
+		{tag: tagCompression, datatype: typeShort, shorts: []uint16{compression}},
+		{tag: tagPhotometricInterpretation, datatype: typeShort, shorts: []uint16{photometricRGB}},
+		{tag: tagSamplesPerPixel, datatype: typeShort, shorts: []uint16{4}},
+		{tag: tagPlanarConfiguration, datatype: typeShort, shorts: []uint16{1}},
+		{tag: tagExtraSamples, datatype: typeShort, shorts: []uint16{extraSampleUnassociated}},
+		{tag: tagSampleFormat, datatype: typeShort, shorts: []uint16{1, 1, 1, 1}},
+	}
+	if opts.TileSize > 0 {
+		entries = append(entries,
+			ifdEntry{tag: tagTileWidth, datatype: typeLong, longs: []uint32{uint32(opts.TileSize)}},
+			ifdEntry{tag: tagTileLength, datatype: typeLong, longs: []uint32{uint32(opts.TileSize)}},
+			ifdEntry{tag: tagTileOffsets, datatype: typeLong, longs: offsets},
+			ifdEntry{tag: tagTileByteCounts, datatype: typeLong, longs: byteCounts},
+		)
+	} else {
+		entries = append(entries,
+			ifdEntry{tag: tagStripOffsets, datatype: typeLong, longs: offsets},
+			ifdEntry{tag: tagRowsPerStrip, datatype: typeLong, longs: []uint32{uint32(blocks[0].Dy())}},
+			ifdEntry{tag: tagStripByteCounts, datatype: typeLong, longs: byteCounts},
+		)
+	}
+	entries = append(entries, geo...)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	//Layout: header, IFD, values not fitting in the 4 bytes of an entry, image data
+	const ifdOffset = 8
+	valuesOffset := uint32(ifdOffset + 2 + 12*len(entries) + 4)
+	valuesLength := uint32(0)
+	for _, e := range entries {
+		if l := 4 * ((uint32(len(e.data())) + 3) / 4); l > 4 {
+			valuesLength += l
+		}
+	}
+	offset := valuesOffset + valuesLength
+	for i := range data {
+		offsets[i] = offset
+		byteCounts[i] = uint32(len(data[i]))
+		offset += byteCounts[i]
+	}
+
+	var ifd, values bytes.Buffer
+	ifd.Write([]byte{'I', 'I', 42, 0})
+	binary.Write(&ifd, binary.LittleEndian, uint32(ifdOffset))
+	binary.Write(&ifd, binary.LittleEndian, uint16(len(entries)))
+	for _, e := range entries {
+		//offsets and byteCounts are shared with their entries and are now filled
+		d := e.data()
+		binary.Write(&ifd, binary.LittleEndian, e.tag)
+		binary.Write(&ifd, binary.LittleEndian, e.datatype)
+		binary.Write(&ifd, binary.LittleEndian, e.count())
+		if len(d) <= 4 {
+			ifd.Write(append(d, make([]byte, 4-len(d))...))
+			continue
+		}
+		binary.Write(&ifd, binary.LittleEndian, valuesOffset+uint32(values.Len()))
+		values.Write(d)
+		values.Write(make([]byte, (4-len(d)%4)%4))
+	}
+	binary.Write(&ifd, binary.LittleEndian, uint32(0)) //No next IFD
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(ifd.Bytes()); err != nil {
+		return err
+	}
+	if _, err := bw.Write(values.Bytes()); err != nil {
+		return err
+	}
+	for _, d := range data {
+		if _, err := bw.Write(d); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,221 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package geotiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"testing"
+)
+
+//readIFD decodes the first IFD of a little endian TIFF file into a map of tag to values (as float64).
+func readIFD(t *testing.T, b []byte) map[uint16][]float64 {
+	if string(b[:4]) != "II*\x00" {
+		t.Fatalf("invalid TIFF header: %v", b[:4])
+	}
+	le := binary.LittleEndian
+	offset := le.Uint32(b[4:])
+	count := int(le.Uint16(b[offset:]))
+
+	tags := make(map[uint16][]float64)
+	for i := 0; i < count; i++ {
+		e := b[int(offset)+2+12*i:]
+		tag, datatype, n := le.Uint16(e), le.Uint16(e[2:]), int(le.Uint32(e[4:]))
+		size := map[uint16]int{typeShort: 2, typeLong: 4, typeDouble: 8}[datatype]
+		data := e[8:12]
+		if size*n > 4 {
+			data = b[le.Uint32(e[8:]):]
+		}
+		for j := 0; j < n; j++ {
+			switch datatype {
+			case typeShort:
+				tags[tag] = append(tags[tag], float64(le.Uint16(data[2*j:])))
+			case typeLong:
+				tags[tag] = append(tags[tag], float64(le.Uint32(data[4*j:])))
+			case typeDouble:
+				tags[tag] = append(tags[tag], math.Float64frombits(le.Uint64(data[8*j:])))
+			}
+		}
+	}
+	return tags
+}
+
+//readPixels decodes the RGBA pixels of a TIFF file written by Encode.
+func readPixels(t *testing.T, b []byte, tags map[uint16][]float64) *image.NRGBA {
+	width, height := int(tags[tagImageWidth][0]), int(tags[tagImageLength][0])
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	var blocks []image.Rectangle
+	offsets, counts := tags[tagStripOffsets], tags[tagStripByteCounts]
+	if tw, ok := tags[tagTileWidth]; ok {
+		size := int(tw[0])
+		offsets, counts = tags[tagTileOffsets], tags[tagTileByteCounts]
+		for y := 0; y < height; y += size {
+			for x := 0; x < width; x += size {
+				blocks = append(blocks, image.Rect(x, y, x+size, y+size))
+			}
+		}
+	} else {
+		rows := int(tags[tagRowsPerStrip][0])
+		for y := 0; y < height; y += rows {
+			blocks = append(blocks, image.Rect(0, y, width, y+rows))
+		}
+	}
+
+	for i, r := range blocks {
+		data := b[int(offsets[i]) : int(offsets[i])+int(counts[i])]
+		if tags[tagCompression][0] == compressionDeflate {
+			zr, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err = ioutil.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for y := r.Min.Y; y < r.Max.Y && y < height; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				p := 4 * ((y-r.Min.Y)*r.Dx() + x - r.Min.X)
+				if x < width {
+					img.SetNRGBA(x, y, color.NRGBA{data[p], data[p+1], data[p+2], data[p+3]})
+				}
+			}
+		}
+	}
+	return img
+}
+
+func TestEncode(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 100, 70))
+	for y := 0; y < 70; y++ {
+		for x := 0; x < 100; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), 7, 200})
+		}
+	}
+	ref := Georeference{EPSG: EPSG3857, OriginX: -1000, OriginY: 2000, PixelSizeX: 10, PixelSizeY: 10}
+
+	for _, opts := range []*Options{nil, {Deflate: true}, {TileSize: 32}, {TileSize: 32, Deflate: true}} {
+		var b bytes.Buffer
+		if err := Encode(&b, src, ref, opts); err != nil {
+			t.Fatalf("Encode(%+v) => %v", opts, err)
+		}
+
+		tags := readIFD(t, b.Bytes())
+		if got := tags[tagModelPixelScale]; len(got) != 3 || got[0] != 10 || got[1] != 10 {
+			t.Errorf("Encode(%+v) => ModelPixelScale %v", opts, got)
+		}
+		if got := tags[tagModelTiepoint]; len(got) != 6 || got[3] != -1000 || got[4] != 2000 {
+			t.Errorf("Encode(%+v) => ModelTiepoint %v", opts, got)
+		}
+		if got := tags[tagGeoKeyDirectory]; len(got) != 20 || got[len(got)-8] != keyProjectedCSType || got[len(got)-5] != EPSG3857 {
+			t.Errorf("Encode(%+v) => GeoKeyDirectory %v", opts, got)
+		}
+
+		img := readPixels(t, b.Bytes(), tags)
+		if img.Bounds() != src.Bounds() {
+			t.Fatalf("Encode(%+v) => %v, want %v", opts, img.Bounds(), src.Bounds())
+		}
+		if !bytes.Equal(img.Pix, src.Pix) {
+			t.Errorf("Encode(%+v) => pixels differ", opts)
+		}
+	}
+
+	if err := Encode(ioutil.Discard, src, Georeference{EPSG: 2056}, nil); err == nil {
+		t.Errorf("Encode(EPSG:2056) => no error")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 100, 70))
+	for y := 0; y < 70; y++ {
+		for x := 0; x < 100; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), 7, 200})
+		}
+	}
+	ref := Georeference{EPSG: EPSG4326, OriginX: 5.5, OriginY: 47.5, PixelSizeX: 0.01, PixelSizeY: 0.005}
+
+	for _, opts := range []*Options{nil, {Deflate: true}, {TileSize: 32, Deflate: true}} {
+		var b bytes.Buffer
+		if err := Encode(&b, src, ref, opts); err != nil {
+			t.Fatalf("Encode(%+v) => %v", opts, err)
+		}
+
+		img, decodedRef, err := Decode(&b)
+		if err != nil {
+			t.Fatalf("Decode(%+v) => %v", opts, err)
+		}
+		if decodedRef != ref {
+			t.Errorf("Decode(%+v) => %+v, want %+v", opts, decodedRef, ref)
+		}
+		nrgba, ok := img.(*image.NRGBA)
+		if !ok || nrgba.Bounds() != src.Bounds() || !bytes.Equal(nrgba.Pix, src.Pix) {
+			t.Errorf("Decode(%+v) => pixels differ", opts)
+		}
+	}
+
+	if _, _, err := Decode(bytes.NewReader([]byte("not a tiff"))); err == nil {
+		t.Errorf("Decode(invalid) => no error")
+	}
+}
+
+//setTag overwrites the first value of a tag stored in the IFD entry of a little endian TIFF file
+func setTag(t *testing.T, b []byte, tag uint16, value uint32) {
+	le := binary.LittleEndian
+	offset := le.Uint32(b[4:])
+	count := int(le.Uint16(b[offset:]))
+	for i := 0; i < count; i++ {
+		e := b[int(offset)+2+12*i:]
+		if le.Uint16(e) != tag {
+			continue
+		}
+		switch le.Uint16(e[2:]) {
+		case typeShort:
+			le.PutUint16(e[8:], uint16(value))
+		case typeLong:
+			le.PutUint32(e[8:], value)
+		}
+		return
+	}
+	t.Fatalf("tag %d not found", tag)
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	var encoded bytes.Buffer
+	if err := Encode(&encoded, src, Georeference{EPSG: EPSG4326, PixelSizeX: 1, PixelSizeY: 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		tag   uint16
+		value uint32
+	}{
+		{"no samples", tagSamplesPerPixel, 0},
+		{"too many samples", tagSamplesPerPixel, 1000},
+		{"RGB with 2 samples", tagSamplesPerPixel, 2},
+		{"too large", tagImageWidth, 1 << 30},
+	} {
+		b := append([]byte(nil), encoded.Bytes()...)
+		setTag(t, b, tc.tag, tc.value)
+		if _, _, err := Decode(bytes.NewReader(b)); err == nil {
+			t.Errorf("Decode(%s) => no error", tc.name)
+		}
+	}
+
+	//Gray image with a single sample
+	b := append([]byte(nil), encoded.Bytes()...)
+	setTag(t, b, tagSamplesPerPixel, 1)
+	setTag(t, b, tagPhotometricInterpretation, photometricBlackIsZero)
+	if _, _, err := Decode(bytes.NewReader(b)); err != nil {
+		t.Errorf("Decode(gray) => %v", err)
+	}
+}
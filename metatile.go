@@ -0,0 +1,155 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package raster
+
+import (
+	"image"
+	"image/draw"
+)
+
+//MetatileReader is the interface implemented by a TileReader able to provide a block of tiles as a single image,
+//such as a renderer for which producing a large image is much more efficient than producing each tile.
+//
+//A MetatileReader must be safe for use by multiple goroutines.
+type MetatileReader interface {
+	TileReader
+	//GetRawMetatile retrieves a single image covering all the tiles of block, encoded in TileFormat.
+	//The image is made of (Xmax-Xmin+1) x (Ymax-Ymin+1) tiles of TileSize pixels. As y follows the TMS convention,
+	//the top row of the image is Ymax. A nil image means that the tiles are not available.
+	GetRawMetatile(block TileBlock) ([]byte, error)
+}
+
+//metatileBlocks splits a block into metatiles of size x size tiles aligned on multiples of size,
+//clipped to the tile matrix of the level.
+func metatileBlocks(block TileBlock, size int) []TileBlock {
+	var blocks []TileBlock
+	last := n(block.Level) - 1
+	for mx := (block.Xmin / size) * size; mx <= block.Xmax; mx += size {
+		for my := (block.Ymin / size) * size; my <= block.Ymax; my += size {
+			blocks = append(blocks, TileBlock{
+				Level: block.Level,
+				Xmin:  mx,
+				Xmax:  minInt(mx+size-1, last),
+				Ymin:  my,
+				Ymax:  minInt(my+size-1, last),
+			})
+		}
+	}
+	return blocks
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+//contains returns true if the tile x/y is in the block
+func (b TileBlock) contains(x, y int) bool {
+	return x >= b.Xmin && x <= b.Xmax && y >= b.Ymin && y <= b.Ymax
+}
+
+//metatileTile extracts the tile x/y from the image of a metatile.
+func metatileTile(img image.Image, meta TileBlock, x, y int) image.Image {
+	size := img.Bounds().Dx() / (meta.Xmax - meta.Xmin + 1)
+	left := (x - meta.Xmin) * size
+	top := (meta.Ymax - y) * size
+	r := image.Rect(left, top, left+size, top+size).Add(img.Bounds().Min)
+
+	tile := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(tile, tile.Bounds(), img, r.Min, draw.Src)
+	return tile
+}
+
+//copyMetatiles copies a block of tiles by requesting metatiles of MetatileSize x MetatileSize tiles.
+//Metatiles are aligned on multiples of MetatileSize, but only the tiles of block are written.
+func (c *Copier) copyMetatiles(mr MetatileReader, block TileBlock, progressFct func(level, x, y int, processed bool)) (int, error) {
+	processedCount := 0
+	for _, meta := range metatileBlocks(block, c.MetatileSize) {
+		processed, err := c.copyMetatile(mr, meta, block)
+		for x := meta.Xmin; x <= meta.Xmax; x++ {
+			for y := meta.Ymin; y <= meta.Ymax; y++ {
+				if !block.contains(x, y) {
+					continue
+				}
+				if processed[[2]int{x, y}] {
+					processedCount++
+				}
+				if progressFct != nil && err == nil {
+					progressFct(block.Level, x, y, processed[[2]int{x, y}])
+				}
+			}
+		}
+		if err != nil {
+			return processedCount, err
+		}
+	}
+	return processedCount, nil
+}
+
+//copyMetatile copies the tiles of block within a single metatile.
+//It returns the set of x/y of the tiles copied in the destination.
+func (c *Copier) copyMetatile(mr MetatileReader, meta TileBlock, block TileBlock) (map[[2]int]bool, error) {
+	processed := make(map[[2]int]bool)
+
+	//Skip the request if all tiles are filtered
+	var tiles [][2]int
+	for x := meta.Xmin; x <= meta.Xmax; x++ {
+		for y := meta.Ymin; y <= meta.Ymax; y++ {
+			if !block.contains(x, y) {
+				continue
+			}
+			filtered, err := c.isFiltered(meta.Level, x, y)
+			if err != nil {
+				return processed, err
+			}
+			if !filtered {
+				tiles = append(tiles, [2]int{x, y})
+			}
+		}
+	}
+	if len(tiles) == 0 {
+		return processed, nil
+	}
+
+	rawImg, err := mr.GetRawMetatile(meta)
+	if err != nil {
+		return processed, err
+	}
+	if rawImg == nil {
+		for _, t := range tiles {
+			if err := c.deleteMissing(meta.Level, t[0], t[1]); err != nil {
+				return processed, err
+			}
+		}
+		return processed, nil
+	}
+	img, err := Decode(rawImg, c.fromFormat)
+	if err != nil {
+		return processed, err
+	}
+
+	for _, t := range tiles {
+		b, err := EncodeWithOptions(metatileTile(img, meta, t[0], t[1]), c.toFormat, c.EncodeOptions)
+		if err != nil {
+			return processed, err
+		}
+		err = c.to.SetRaw(meta.Level, t[0], t[1], b)
+		if err != nil {
+			return processed, err
+		}
+		processed[t] = true
+	}
+
+	return processed, nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package raster
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+//renderer is a MetatileReader painting each tile with a color depending on its x/y.
+type renderer struct {
+	*memTiles
+	requests int
+}
+
+func tileColor(x, y int) color.RGBA {
+	return color.RGBA{uint8(x * 16), uint8(y * 16), 128, 255}
+}
+
+func (r *renderer) GetRawMetatile(block TileBlock) ([]byte, error) {
+	r.requests++
+	w, h := block.Xmax-block.Xmin+1, block.Ymax-block.Ymin+1
+	m := image.NewRGBA(image.Rect(0, 0, w*256, h*256))
+	for px := 0; px < w*256; px++ {
+		for py := 0; py < h*256; py++ {
+			m.SetRGBA(px, py, tileColor(block.Xmin+px/256, block.Ymax-py/256))
+		}
+	}
+	return Encode(m, "png")
+}
+
+func TestCopyMetatiles(t *testing.T) {
+	src := &renderer{memTiles: newMemTiles("png", 256)}
+	dst := newMemTiles("png", 256)
+
+	c, err := NewCopier(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.MetatileSize = 4
+	c.Filter = func(level, x, y int) (bool, error) {
+		return x == 2 && y == 2, nil
+	}
+
+	block := TileBlock{Level: 3, Xmin: 1, Xmax: 5, Ymin: 2, Ymax: 3}
+	progress := 0
+	processed, err := c.CopyBlock(block, func(level, x, y int, processed bool) {
+		progress++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processed != block.Count()-1 {
+		t.Errorf("CopyBlock() => %d processed, want %d", processed, block.Count()-1)
+	}
+	if progress != block.Count() {
+		t.Errorf("CopyBlock() => %d progress calls, want %d", progress, block.Count())
+	}
+	if src.requests != 2 {
+		t.Errorf("CopyBlock() => %d metatile requests, want 2", src.requests)
+	}
+
+	for x := block.Xmin; x <= block.Xmax; x++ {
+		for y := block.Ymin; y <= block.Ymax; y++ {
+			raw, _ := dst.GetRaw(block.Level, x, y)
+			if x == 2 && y == 2 {
+				if raw != nil {
+					t.Errorf("filtered tile (%d, %d) copied", x, y)
+				}
+				continue
+			}
+			img, err := Decode(raw, "png")
+			if err != nil {
+				t.Fatalf("tile (%d, %d): %v", x, y, err)
+			}
+			if img.Bounds().Dx() != 256 || img.Bounds().Dy() != 256 {
+				t.Errorf("tile (%d, %d) => %v, want 256x256", x, y, img.Bounds())
+			}
+			if got := color.RGBAModel.Convert(img.At(0, 0)); got != tileColor(x, y) {
+				t.Errorf("tile (%d, %d) => %v, want %v", x, y, got, tileColor(x, y))
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package raster
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+
+	"github.com/xeonx/geographic"
+)
+
+//earthRadius is the radius in meters of the sphere used by the web mercator projection (EPSG:3857)
+const earthRadius = 6378137.0
+
+//maxLatitude is the latitude in degree of the top edge of the web mercator world (EPSG:3857)
+const maxLatitude = 85.0511287798
+
+//MaxMosaicPixels is the maximum number of pixels of an image built by NewMosaic.
+//The default limits the image to 1 GiB of memory (4 bytes per pixel).
+var MaxMosaicPixels = 1 << 28
+
+//Mosaic is a single image stitched from the tiles of a TileReader, with its georeferencing in web mercator (EPSG:3857).
+type Mosaic struct {
+	Image     image.Image
+	Level     int     //Level of the tiles used to build the image
+	OriginX   float64 //X in meters of the top-left corner of the top-left pixel
+	OriginY   float64 //Y in meters of the top-left corner of the top-left pixel
+	PixelSize float64 //Width and height in meters of a pixel
+}
+
+//lon2Pixel transforms a longitude in degree into a global pixel x at a given level.
+func lon2Pixel(level int, tileSize int, longitudeDeg float64) float64 {
+	return float64(tileSize*n(level)) * (longitudeDeg + 180.) / 360.
+}
+
+//lat2Pixel transforms a latitude in degree into a global pixel y (from the top) at a given level.
+//Latitudes beyond the web mercator world are clamped to its edges.
+func lat2Pixel(level int, tileSize int, latitudeDeg float64) float64 {
+	latitudeDeg = math.Max(-maxLatitude, math.Min(maxLatitude, latitudeDeg))
+	latitudeRad := latitudeDeg / 180 * math.Pi
+	return float64(tileSize*n(level)) * (1. - math.Log(math.Tan(latitudeRad)+1/math.Cos(latitudeRad))/math.Pi) / 2.
+}
+
+//MosaicLevel returns the lowest level at which the largest side of bbox spans at least size pixels,
+//for tiles of tileSize pixels. The result is capped to maxLevel.
+func MosaicLevel(bbox geographic.BoundingBox, size int, tileSize int, maxLevel int) int {
+	for level := 0; level < maxLevel; level++ {
+		width := lon2Pixel(level, tileSize, bbox.LongitudeMaxDeg) - lon2Pixel(level, tileSize, bbox.LongitudeMinDeg)
+		height := lat2Pixel(level, tileSize, bbox.LatitudeMinDeg) - lat2Pixel(level, tileSize, bbox.LatitudeMaxDeg)
+		if width >= float64(size) || height >= float64(size) {
+			return level
+		}
+	}
+	return maxLevel
+}
+
+//NewMosaic stitches the tiles of r covering bbox at the given level into a single image cropped to bbox.
+//Missing tiles are left transparent. An error is returned if the image would exceed MaxMosaicPixels.
+func NewMosaic(r TileReader, bbox geographic.BoundingBox, level int) (*Mosaic, error) {
+	if bbox.LongitudeMinDeg >= bbox.LongitudeMaxDeg || bbox.LatitudeMinDeg >= bbox.LatitudeMaxDeg {
+		return nil, errors.New("Invalid bounding box: empty area")
+	}
+
+	tileSize := TileSize(r)
+	format := r.TileFormat()
+
+	//Pixel area at the given level, clipped to the world
+	world := image.Rect(0, 0, tileSize*n(level), tileSize*n(level))
+	width := math.Ceil(lon2Pixel(level, tileSize, math.Min(bbox.LongitudeMaxDeg, 180))) - math.Floor(lon2Pixel(level, tileSize, math.Max(bbox.LongitudeMinDeg, -180)))
+	height := math.Ceil(lat2Pixel(level, tileSize, bbox.LatitudeMinDeg)) - math.Floor(lat2Pixel(level, tileSize, bbox.LatitudeMaxDeg))
+	if width*height > float64(MaxMosaicPixels) {
+		return nil, fmt.Errorf("Mosaic too large: %.0fx%.0f pixels at level %d, the maximum is %d pixels", width, height, level, MaxMosaicPixels)
+	}
+	area := image.Rect(
+		int(math.Floor(lon2Pixel(level, tileSize, bbox.LongitudeMinDeg))),
+		int(math.Floor(lat2Pixel(level, tileSize, bbox.LatitudeMaxDeg))),
+		int(math.Ceil(lon2Pixel(level, tileSize, bbox.LongitudeMaxDeg))),
+		int(math.Ceil(lat2Pixel(level, tileSize, bbox.LatitudeMinDeg))),
+	).Intersect(world)
+	if area.Empty() {
+		return nil, errors.New("Invalid bounding box: outside of the world")
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, area.Dx(), area.Dy()))
+	for x := area.Min.X / tileSize; x <= (area.Max.X-1)/tileSize; x++ {
+		for yosm := area.Min.Y / tileSize; yosm <= (area.Max.Y-1)/tileSize; yosm++ {
+			y := n(level) - yosm - 1
+
+			rawImg, err := r.GetRaw(level, x, y)
+			if err != nil {
+				return nil, fmt.Errorf("Tile %d/%d/%d: %s", level, x, y, err)
+			}
+			if rawImg == nil {
+				continue
+			}
+			img, err := Decode(rawImg, format)
+			if err != nil {
+				return nil, fmt.Errorf("Tile %d/%d/%d: %s", level, x, y, err)
+			}
+
+			pt := image.Pt(x*tileSize, yosm*tileSize).Sub(area.Min)
+			draw.Draw(dst, img.Bounds().Sub(img.Bounds().Min).Add(pt), img, img.Bounds().Min, draw.Src)
+		}
+	}
+
+	pixelSize := 2 * math.Pi * earthRadius / float64(world.Dx())
+	return &Mosaic{
+		Image:     dst,
+		Level:     level,
+		OriginX:   float64(area.Min.X)*pixelSize - math.Pi*earthRadius,
+		OriginY:   math.Pi*earthRadius - float64(area.Min.Y)*pixelSize,
+		PixelSize: pixelSize,
+	}, nil
+}
+
+//WorldFileExt returns the conventional extension of the world file for an image format (e.g. ".pgw" for png)
+func WorldFileExt(format string) string {
+	switch format {
+	case "png":
+		return ".pgw"
+	case "jpg":
+		return ".jgw"
+	case "tif":
+		return ".tfw"
+	}
+	return ".wld"
+}
+
+//WriteWorldFile writes the world file describing the georeferencing of the mosaic.
+//Coordinates are in web mercator (EPSG:3857) meters.
+func (m *Mosaic) WriteWorldFile(w io.Writer) error {
+	//A world file references the center of the top-left pixel
+	_, err := fmt.Fprintf(w, "%.10f\n0.0000000000\n0.0000000000\n%.10f\n%.10f\n%.10f\n",
+		m.PixelSize,
+		-m.PixelSize,
+		m.OriginX+m.PixelSize/2,
+		m.OriginY-m.PixelSize/2)
+	return err
+}
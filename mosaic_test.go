@@ -0,0 +1,96 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package raster
+
+import (
+	"bytes"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/xeonx/geographic"
+)
+
+func TestNewMosaic(t *testing.T) {
+	src := newMemTiles("png", 256)
+	src.SetRaw(1, 0, 1, uniformTile(t, 256, color.RGBA{255, 0, 0, 255})) //top-left
+	src.SetRaw(1, 1, 0, uniformTile(t, 256, color.RGBA{0, 0, 255, 255})) //bottom-right
+
+	//Whole world
+	world := geographic.BoundingBox{LongitudeMinDeg: -180, LongitudeMaxDeg: 180, LatitudeMinDeg: -85.0511287798, LatitudeMaxDeg: 85.0511287798}
+	m, err := NewMosaic(src, world, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Image.Bounds().Dx() != 512 || m.Image.Bounds().Dy() != 512 {
+		t.Errorf("NewMosaic(world, 1) => %v, want 512x512", m.Image.Bounds())
+	}
+	if got := color.RGBAModel.Convert(m.Image.At(10, 10)); got != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("NewMosaic(world, 1) top-left => %v, want red", got)
+	}
+	if got := color.RGBAModel.Convert(m.Image.At(500, 500)); got != (color.RGBA{0, 0, 255, 255}) {
+		t.Errorf("NewMosaic(world, 1) bottom-right => %v, want blue", got)
+	}
+	if got := color.RGBAModel.Convert(m.Image.At(500, 10)); got != (color.RGBA{}) {
+		t.Errorf("NewMosaic(world, 1) missing tile => %v, want transparent", got)
+	}
+	if math.Abs(m.OriginX+20037508.34) > 0.01 || math.Abs(m.OriginY-20037508.34) > 0.01 {
+		t.Errorf("NewMosaic(world, 1) origin => %f, %f", m.OriginX, m.OriginY)
+	}
+	if math.Abs(m.PixelSize-78271.517) > 0.001 {
+		t.Errorf("NewMosaic(world, 1) pixel size => %f", m.PixelSize)
+	}
+
+	//North-east quarter, cropped
+	m, err = NewMosaic(src, geographic.BoundingBox{LongitudeMinDeg: 0, LongitudeMaxDeg: 90, LatitudeMinDeg: 0, LatitudeMaxDeg: 85.0511287798}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Image.Bounds().Dx() != 128 || m.Image.Bounds().Dy() != 256 {
+		t.Errorf("NewMosaic(north-east, 1) => %v, want 128x256", m.Image.Bounds())
+	}
+	if math.Abs(m.OriginX) > 0.01 {
+		t.Errorf("NewMosaic(north-east, 1) origin x => %f, want 0", m.OriginX)
+	}
+
+	var b bytes.Buffer
+	if err := m.WriteWorldFile(&b); err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(b.Bytes(), []byte("\n")); lines != 6 {
+		t.Errorf("WriteWorldFile() => %d lines, want 6", lines)
+	}
+
+	for _, lat := range []float64{-85, -45.5, 0, 12.3, 85} {
+		if got := Meters2Lat(Lat2Meters(lat)); math.Abs(got-lat) > 1e-9 {
+			t.Errorf("Meters2Lat(Lat2Meters(%f)) => %f", lat, got)
+		}
+	}
+
+	if level := MosaicLevel(world, 1000, 256, 18); level != 2 {
+		t.Errorf("MosaicLevel(world, 1000) => %d, want 2", level)
+	}
+}
+
+func TestNewMosaicLimits(t *testing.T) {
+	src := newMemTiles("png", 256)
+
+	//Latitudes beyond the web mercator world are clamped
+	m, err := NewMosaic(src, geographic.BoundingBox{LongitudeMinDeg: -180, LongitudeMaxDeg: 180, LatitudeMinDeg: -90, LatitudeMaxDeg: 90}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Image.Bounds().Dx() != 512 || m.Image.Bounds().Dy() != 512 {
+		t.Errorf("NewMosaic(-90..90, 1) => %v, want 512x512", m.Image.Bounds())
+	}
+	if level := MosaicLevel(geographic.BoundingBox{LongitudeMinDeg: 0, LongitudeMaxDeg: 1, LatitudeMinDeg: 0, LatitudeMaxDeg: 90}, 1000, 256, 18); level != 3 {
+		t.Errorf("MosaicLevel(0..90) => %d, want 3", level)
+	}
+
+	//Too many pixels
+	if _, err := NewMosaic(src, geographic.BoundingBox{LongitudeMinDeg: -180, LongitudeMaxDeg: 180, LatitudeMinDeg: -90, LatitudeMaxDeg: 90}, 18); err == nil {
+		t.Error("NewMosaic(world, 18) should fail")
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package raster
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+//toPaletted converts an image into a 8-bit paletted image.
+//The palette is computed with a median cut quantizer. If dither is true, Floyd-Steinberg error diffusion is applied.
+func toPaletted(img image.Image, dither bool) *image.Paletted {
+	if p, ok := img.(*image.Paletted); ok {
+		return p
+	}
+
+	palette := medianCutQuantizer{}.Quantize(make(color.Palette, 0, 256), img)
+	dst := image.NewPaletted(img.Bounds(), palette)
+
+	var drawer draw.Drawer = draw.Src
+	if dither {
+		drawer = draw.FloydSteinberg
+	}
+	drawer.Draw(dst, dst.Bounds(), img, img.Bounds().Min)
+
+	return dst
+}
+
+//medianCutQuantizer implements draw.Quantizer using the median cut algorithm.
+type medianCutQuantizer struct{}
+
+//colorBox is a set of colors, with their pixel count, processed by the median cut algorithm.
+type colorBox struct {
+	colors []color.NRGBA
+	counts []int
+}
+
+//colorBoxSorter sorts the colors of a box along one of their channels, or by their packed RGBA value.
+type colorBoxSorter struct {
+	box     *colorBox
+	channel int
+}
+
+//packedChannels is the colorBoxSorter channel sorting the colors by their packed RGBA value
+const packedChannels = 4
+
+func (s colorBoxSorter) Len() int { return len(s.box.colors) }
+func (s colorBoxSorter) Less(i, j int) bool {
+	if s.channel == packedChannels {
+		return packed(s.box.colors[i]) < packed(s.box.colors[j])
+	}
+	return channel(s.box.colors[i], s.channel) < channel(s.box.colors[j], s.channel)
+}
+func (s colorBoxSorter) Swap(i, j int) {
+	s.box.colors[i], s.box.colors[j] = s.box.colors[j], s.box.colors[i]
+	s.box.counts[i], s.box.counts[j] = s.box.counts[j], s.box.counts[i]
+}
+
+//channel returns the value of the nth channel (r, g, b, a) of a color
+func channel(c color.NRGBA, n int) uint8 {
+	switch n {
+	case 0:
+		return c.R
+	case 1:
+		return c.G
+	case 2:
+		return c.B
+	}
+	return c.A
+}
+
+//packed returns the RGBA channels of a color packed in a single value
+func packed(c color.NRGBA) uint32 {
+	return uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A)
+}
+
+//widestChannel returns the channel having the widest range in the box, and this range.
+func (b *colorBox) widestChannel() (int, int) {
+	bestChannel, bestRange := 0, -1
+	for ch := 0; ch < 4; ch++ {
+		min, max := 255, 0
+		for _, c := range b.colors {
+			v := int(channel(c, ch))
+			if v < min {
+				min = v
+			}
+			if v > max {
+				max = v
+			}
+		}
+		if max-min > bestRange {
+			bestChannel, bestRange = ch, max-min
+		}
+	}
+	return bestChannel, bestRange
+}
+
+//split divides the box in two boxes of similar pixel count along its widest channel.
+//The sort is stable so that the palette only depends on the image.
+func (b *colorBox) split(ch int) (colorBox, colorBox) {
+	sort.Stable(colorBoxSorter{box: b, channel: ch})
+
+	total := 0
+	for _, c := range b.counts {
+		total += c
+	}
+
+	//Find the median, keeping at least one color in each box
+	i, acc := 1, b.counts[0]
+	for i < len(b.colors)-1 && acc*2 < total {
+		acc += b.counts[i]
+		i++
+	}
+
+	return colorBox{colors: b.colors[:i], counts: b.counts[:i]},
+		colorBox{colors: b.colors[i:], counts: b.counts[i:]}
+}
+
+//average returns the mean color of the box, weighted by pixel count.
+func (b *colorBox) average() color.NRGBA {
+	var r, g, bl, a, total int
+	for i, c := range b.colors {
+		n := b.counts[i]
+		r += int(c.R) * n
+		g += int(c.G) * n
+		bl += int(c.B) * n
+		a += int(c.A) * n
+		total += n
+	}
+	return color.NRGBA{
+		R: uint8((r + total/2) / total),
+		G: uint8((g + total/2) / total),
+		B: uint8((bl + total/2) / total),
+		A: uint8((a + total/2) / total),
+	}
+}
+
+//Quantize appends up to cap(p) - len(p) colors to p and returns the updated palette suitable for converting m to a paletted image.
+func (q medianCutQuantizer) Quantize(p color.Palette, m image.Image) color.Palette {
+	n := cap(p) - len(p)
+	if n <= 0 {
+		return p
+	}
+
+	//Build the histogram of the image
+	histogram := make(map[color.NRGBA]int)
+	bounds := m.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			histogram[c]++
+		}
+	}
+
+	box := colorBox{
+		colors: make([]color.NRGBA, 0, len(histogram)),
+		counts: make([]int, 0, len(histogram)),
+	}
+	for c, count := range histogram {
+		box.colors = append(box.colors, c)
+		box.counts = append(box.counts, count)
+	}
+	//Fixed order, whatever the iteration order of the histogram
+	sort.Sort(colorBoxSorter{box: &box, channel: packedChannels})
+
+	//Few colors: the palette is exact
+	if len(box.colors) <= n {
+		for _, c := range box.colors {
+			p = append(p, c)
+		}
+		return p
+	}
+
+	//Split the box having the widest channel range until enough boxes are available
+	boxes := []colorBox{box}
+	for len(boxes) < n {
+		bestBox, bestChannel, bestRange := -1, 0, 0
+		for i := range boxes {
+			if len(boxes[i].colors) < 2 {
+				continue
+			}
+			ch, r := boxes[i].widestChannel()
+			if r > bestRange {
+				bestBox, bestChannel, bestRange = i, ch, r
+			}
+		}
+		if bestBox < 0 {
+			break
+		}
+
+		b1, b2 := boxes[bestBox].split(bestChannel)
+		boxes[bestBox] = b1
+		boxes = append(boxes, b2)
+	}
+
+	for i := range boxes {
+		p = append(p, boxes[i].average())
+	}
+	return p
+}
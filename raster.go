@@ -13,6 +13,7 @@ package raster
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"image"
 	"image/jpeg"
 	"image/png"
@@ -52,17 +53,80 @@ func Lat2Y(level int, latitudeDeg float64) int {
 	return n(level) - yosm - 1
 }
 
+//EncodeOptions configures the encoding of images.
+//The zero value, like a nil *EncodeOptions, uses the default settings of the image/jpeg and image/png packages.
+type EncodeOptions struct {
+	JPEGQuality    int                  //JPEG quality, ranging from 1 to 100 inclusive. 0 means jpeg.DefaultQuality.
+	PNGCompression png.CompressionLevel //PNG compression level
+	Palette        bool                 //Quantize PNG images to a 8-bit palette (at most 256 colors)
+	Dither         bool                 //Apply Floyd-Steinberg error diffusion when quantizing to a palette
+}
+
+//appliesTo returns true if at least one of the options changes the encoding of the given format.
+func (opts *EncodeOptions) appliesTo(format string) bool {
+	if opts == nil {
+		return false
+	}
+	switch format {
+	case "jpg":
+		return opts.JPEGQuality != 0
+	case "png":
+		return opts.PNGCompression != png.DefaultCompression || opts.Palette
+	}
+	return false
+}
+
+//Lon2Meters transforms a longitude in degree into a web mercator (EPSG:3857) x in meters.
+func Lon2Meters(longitudeDeg float64) float64 {
+	return earthRadius * longitudeDeg * math.Pi / 180
+}
+
+//Lat2Meters transforms a latitude in degree into a web mercator (EPSG:3857) y in meters.
+func Lat2Meters(latitudeDeg float64) float64 {
+	return earthRadius * math.Log(math.Tan(math.Pi/4+latitudeDeg*math.Pi/360))
+}
+
+//Meters2Lon transforms a web mercator (EPSG:3857) x in meters into a longitude in degree.
+func Meters2Lon(x float64) float64 {
+	return x / earthRadius * 180 / math.Pi
+}
+
+//Meters2Lat transforms a web mercator (EPSG:3857) y in meters into a latitude in degree.
+func Meters2Lat(y float64) float64 {
+	return (2*math.Atan(math.Exp(y/earthRadius)) - math.Pi/2) * 180 / math.Pi
+}
+
 //Encode encodes an image in the given format. Only jpg and png are supported.
 func Encode(img image.Image, format string) ([]byte, error) {
+	return EncodeWithOptions(img, format, nil)
+}
+
+//EncodeWithOptions encodes an image in the given format using the given options. Only jpg and png are supported.
+//A nil opts is equivalent to Encode.
+func EncodeWithOptions(img image.Image, format string, opts *EncodeOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &EncodeOptions{}
+	}
 
 	var b bytes.Buffer
 	if format == "jpg" {
-		err := jpeg.Encode(&b, img, nil)
+		var jpegOpts *jpeg.Options
+		if opts.JPEGQuality != 0 {
+			if opts.JPEGQuality < 1 || opts.JPEGQuality > 100 {
+				return nil, fmt.Errorf("Invalid JPEG quality: %d. Must be between 1 and 100.", opts.JPEGQuality)
+			}
+			jpegOpts = &jpeg.Options{Quality: opts.JPEGQuality}
+		}
+		err := jpeg.Encode(&b, img, jpegOpts)
 		if err != nil {
 			return nil, err
 		}
 	} else if format == "png" {
-		err := png.Encode(&b, img)
+		if opts.Palette {
+			img = toPaletted(img, opts.Dither)
+		}
+		encoder := png.Encoder{CompressionLevel: opts.PNGCompression}
+		err := encoder.Encode(&b, img)
 		if err != nil {
 			return nil, err
 		}
@@ -132,44 +196,65 @@ func All(filters ...Filter) Filter {
 	}
 }
 
-//transformFct is any transformation function usable inside the encoding or decoding of images.
-type transformFct func(src []byte) ([]byte, error)
-
-//GetTansformFct returns a function able to convert between the raw format of from and the one of to.
-//A nil value means that no transformation is required.
-func getTansformFct(from TileReader, to TileReadWriter) transformFct {
-	var transform transformFct
-	if from.TileFormat() != to.TileFormat() {
-		transform = func(src []byte) ([]byte, error) {
-			img, err := Decode(src, from.TileFormat())
-			if err != nil {
-				return nil, err
-			}
-			return Encode(img, to.TileFormat())
-		}
-	}
-	return transform
+//Area is the interface implemented by an area of interest, such as aoi.Coverage, restricting the tiles copied by a Copier.
+type Area interface {
+	//Blocks returns the tile blocks covering the area at a given level, aligned on multiples of blockSize.
+	//The blocks may contain some tiles outside of the area.
+	Blocks(level int, blockSize int) []TileBlock
+	//Intersects returns true if the tile has at least one point in common with the area
+	Intersects(level, x, y int) bool
 }
 
 //Copier copies tiles from a TileReader to a TileReadWriter.
 //An optional filter allow to discard Tiles before copy.
+//
+//Tiles are decoded and re-encoded only when the source and destination formats differ,
+//unless EncodeOptions sets an option of the destination format: every tile is then re-encoded using these options.
+//
+//If MetatileSize is greater than 1 and the source implements MetatileReader, CopyBlock requests
+//the tiles by blocks of MetatileSize x MetatileSize tiles.
+//
+//If Area is set, the tiles outside of it are not copied, and CopyBlock only iterates the blocks of the Area
+//(aligned on MetatileSize) within the given block.
+//
+//If DeleteMissing is set, the tiles missing in the source are deleted from the destination, which must then be a TileDeleter.
 type Copier struct {
-	from      TileReader
-	to        TileReadWriter
-	transform transformFct
-
-	Filter Filter
+	from       TileReader
+	to         TileReadWriter
+	fromFormat string
+	toFormat   string
+
+	Filter        Filter
+	Area          Area
+	EncodeOptions *EncodeOptions
+	MetatileSize  int
+	DeleteMissing bool
 }
 
 //NewCopier creates a Copier between from and to.
 func NewCopier(from TileReader, to TileReadWriter) (*Copier, error) {
 	return &Copier{
-		from:      from,
-		to:        to,
-		transform: getTansformFct(from, to),
+		from:       from,
+		to:         to,
+		fromFormat: from.TileFormat(),
+		toFormat:   to.TileFormat(),
 	}, nil
 }
 
+//transform converts a raw tile from the source format into the destination one.
+//The tile is returned unchanged if no transformation is required.
+func (c *Copier) transform(src []byte) ([]byte, error) {
+	if c.fromFormat == c.toFormat && !c.EncodeOptions.appliesTo(c.toFormat) {
+		return src, nil
+	}
+
+	img, err := Decode(src, c.fromFormat)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeWithOptions(img, c.toFormat, c.EncodeOptions)
+}
+
 //TileBlock represents a rectangular set of tiles at a given level
 type TileBlock struct {
 	Level int
@@ -184,6 +269,22 @@ func (b TileBlock) Count() int {
 	return (b.Xmax - b.Xmin + 1) * (b.Ymax - b.Ymin + 1)
 }
 
+//Intersect computes the tiles common to two blocks of the same level.
+//It returns false if they do not intersect.
+func (b TileBlock) Intersect(o TileBlock) (TileBlock, bool) {
+	r := TileBlock{
+		Level: b.Level,
+		Xmin:  maxInt(b.Xmin, o.Xmin),
+		Xmax:  minInt(b.Xmax, o.Xmax),
+		Ymin:  maxInt(b.Ymin, o.Ymin),
+		Ymax:  minInt(b.Ymax, o.Ymax),
+	}
+	if b.Level != o.Level || r.Xmin > r.Xmax || r.Ymin > r.Ymax {
+		return r, false
+	}
+	return r, true
+}
+
 //GetTileBlock computes the tile block enveloping the bounding box
 func GetTileBlock(bbox geographic.BoundingBox, level int) (TileBlock, error) {
 	b := TileBlock{
@@ -207,10 +308,54 @@ func GetTileBlock(bbox geographic.BoundingBox, level int) (TileBlock, error) {
 	return b, nil
 }
 
+//IntersectBoundingBox computes the intersection of two bounding boxes.
+//It returns false if they do not intersect.
+func IntersectBoundingBox(a, b geographic.BoundingBox) (geographic.BoundingBox, bool) {
+	bbox := geographic.BoundingBox{
+		LatitudeMinDeg:  math.Max(a.LatitudeMinDeg, b.LatitudeMinDeg),
+		LatitudeMaxDeg:  math.Min(a.LatitudeMaxDeg, b.LatitudeMaxDeg),
+		LongitudeMinDeg: math.Max(a.LongitudeMinDeg, b.LongitudeMinDeg),
+		LongitudeMaxDeg: math.Min(a.LongitudeMaxDeg, b.LongitudeMaxDeg),
+	}
+	if bbox.LatitudeMinDeg > bbox.LatitudeMaxDeg || bbox.LongitudeMinDeg > bbox.LongitudeMaxDeg {
+		return bbox, false
+	}
+	return bbox, true
+}
+
 //CopyBlock copies a block of tiles.
 //If progressFct is not nil, it is called during the iteration after each tile.
 //It returns the count of tiles copied in the destination and the first error encountered, if any.
 func (c *Copier) CopyBlock(block TileBlock, progressFct func(level, x, y int, processed bool)) (int, error) {
+	if c.Area == nil {
+		return c.copyBlock(block, progressFct)
+	}
+
+	blockSize := 1
+	if c.MetatileSize > 1 {
+		blockSize = c.MetatileSize
+	}
+	processedCount := 0
+	for _, b := range c.Area.Blocks(block.Level, blockSize) {
+		b, ok := b.Intersect(block)
+		if !ok {
+			continue
+		}
+		n, err := c.copyBlock(b, progressFct)
+		processedCount += n
+		if err != nil {
+			return processedCount, err
+		}
+	}
+	return processedCount, nil
+}
+
+//copyBlock copies all the tiles of a block which are not filtered.
+func (c *Copier) copyBlock(block TileBlock, progressFct func(level, x, y int, processed bool)) (int, error) {
+	if mr, ok := c.from.(MetatileReader); ok && c.MetatileSize > 1 {
+		return c.copyMetatiles(mr, block, progressFct)
+	}
+
 	processedCount := 0
 	for x := block.Xmin; x <= block.Xmax; x++ {
 		for y := block.Ymin; y <= block.Ymax; y++ {
@@ -229,33 +374,49 @@ func (c *Copier) CopyBlock(block TileBlock, progressFct func(level, x, y int, pr
 	return processedCount, nil
 }
 
+//isFiltered returns true if the tile is outside of the Area or excluded from copy by the Filter.
+func (c *Copier) isFiltered(level, x, y int) (bool, error) {
+	if c.Area != nil && !c.Area.Intersects(level, x, y) {
+		return true, nil
+	}
+	if c.Filter == nil {
+		return false, nil
+	}
+	return c.Filter(level, x, y)
+}
+
 //Copy copies a single of tile.
 //It returns the true if the tile was copied in the destination and the first error encountered, if any.
+//A tile not modified in the source since it was stored in the destination is not copied (see ConditionalReader),
+//nor a tile missing in the source.
 func (c *Copier) Copy(level, x, y int) (bool, error) {
 
-	if c.Filter != nil {
-		filtered, err := c.Filter(level, x, y)
-		if err != nil {
-			return false, err
-		}
-		if filtered {
-			return false, nil
-		}
+	filtered, err := c.isFiltered(level, x, y)
+	if err != nil || filtered {
+		return false, err
 	}
 
-	rawImg, err := c.from.GetRaw(level, x, y)
+	rawImg, info, err := c.get(level, x, y)
+	if err == ErrNotModified {
+		return false, c.touch(level, x, y, info)
+	}
 	if err != nil {
 		return false, err
 	}
+	if rawImg == nil {
+		return false, c.deleteMissing(level, x, y)
+	}
 
-	if c.transform != nil {
-		rawImg, err = c.transform(rawImg)
-		if err != nil {
-			return false, err
-		}
+	rawImg, err = c.transform(rawImg)
+	if err != nil {
+		return false, err
 	}
 
-	err = c.to.SetRaw(level, x, y, rawImg)
+	if iw, ok := c.to.(TileInfoWriter); ok && len(info.ETag) > 0 {
+		err = iw.SetRawInfo(level, x, y, rawImg, info)
+	} else {
+		err = c.to.SetRaw(level, x, y, rawImg)
+	}
 	if err != nil {
 		return false, err
 	}
@@ -263,6 +424,63 @@ func (c *Copier) Copy(level, x, y int) (bool, error) {
 	return true, nil
 }
 
+//deleteMissing removes a tile missing in the source from the destination, if DeleteMissing is set.
+func (c *Copier) deleteMissing(level, x, y int) error {
+	if !c.DeleteMissing {
+		return nil
+	}
+	d, ok := c.to.(TileDeleter)
+	if !ok {
+		return errors.New("Destination does not allow deleting tiles")
+	}
+	return d.Delete(level, x, y)
+}
+
+//get retrieves a tile from the source.
+//When the source is a ConditionalReader and the destination a TileInfoReader, a tile already stored in the destination
+//is only retrieved if it changed since: ErrNotModified is returned otherwise, with the information of the stored tile.
+func (c *Copier) get(level, x, y int) ([]byte, TileInfo, error) {
+	cr, ok := c.from.(ConditionalReader)
+	if !ok {
+		rawImg, err := c.from.GetRaw(level, x, y)
+		return rawImg, TileInfo{}, err
+	}
+
+	var info TileInfo
+	if ir, ok := c.to.(TileInfoReader); ok {
+		var found bool
+		var err error
+		info, found, err = ir.TileInfo(level, x, y)
+		if err != nil {
+			return nil, TileInfo{}, err
+		}
+		if !found {
+			info = TileInfo{}
+		}
+	}
+
+	rawImg, newInfo, err := cr.GetRawIfModified(level, x, y, info)
+	if err == ErrNotModified {
+		return nil, info, err
+	}
+	return rawImg, newInfo, err
+}
+
+//touch records in the destination that a stored tile was found not modified in the source, by storing it again
+//with its ETag and the current time, so that it is not requested again before its next expiry.
+//It has no effect if the destination is not a TileInfoWriter.
+func (c *Copier) touch(level, x, y int, info TileInfo) error {
+	iw, ok := c.to.(TileInfoWriter)
+	if !ok {
+		return nil
+	}
+	rawImg, err := c.to.GetRaw(level, x, y)
+	if err != nil || rawImg == nil {
+		return err
+	}
+	return iw.SetRawInfo(level, x, y, rawImg, TileInfo{ETag: info.ETag})
+}
+
 //Copy copies a single tile from a reader to a writer.
 //It returns the true if the tile was copied in the destination and the first error encountered, if any.
 func Copy(from TileReader, to TileReadWriter, level, x, y int) (bool, error) {
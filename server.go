@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"regexp"
 	"strconv"
+	"sync"
 
 	"bytes"
 	"image"
@@ -17,12 +18,20 @@ import (
 	"image/png"
 )
 
-var urlRegex = regexp.MustCompile(`\A/.*/(\d+)/(\d+)/(\d+)\.(png|jpeg)\z`)
+var urlRegex = regexp.MustCompile(`\A/.*/(\d+)/(\d+)/(\d+)(@2x)?\.(png|jpeg)\z`)
 
-var grayTile []byte
+//grayTiles contains the tiles served when a tile is not found, by tile size.
+var grayTiles = make(map[int][]byte)
 
 func init() {
-	m := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for _, size := range []int{DefaultTileSize, 2 * DefaultTileSize} {
+		grayTiles[size] = newGrayTile(size)
+	}
+}
+
+//newGrayTile creates a uniform gray PNG tile of size x size pixels.
+func newGrayTile(size int) []byte {
+	m := image.NewRGBA(image.Rect(0, 0, size, size))
 	gray := color.RGBA{96, 96, 96, 255}
 	draw.Draw(m, m.Bounds(), &image.Uniform{gray}, image.ZP, draw.Src)
 
@@ -30,18 +39,41 @@ func init() {
 	if err := png.Encode(w, m); err != nil {
 		log.Fatal(err)
 	}
-	grayTile = w.Bytes()
+	return w.Bytes()
 }
 
 //Server allows serving tiles through HTTP on URLS like
 //http://example.com/any/sub/path/level/x/y.ext where level, x and y are the tile
 //identification integers and ext is the requested file format (png or jpeg).
 //
+//High-DPI tiles are served on URLS like http://example.com/any/sub/path/level/x/y@2x.ext.
+//Tiles of 256 pixels and @2x tiles of 512 pixels are served whatever the tile size of TileReader,
+//using NewTileSizeConverter when required.
+//
 //By default, Server does not follow the OSM convention for y value but the MBTiles
 //convention.
 type Server struct {
 	TileReader TileReader
 	ZeroIsTop  bool //Flag indicating if the server follow the OSM convention (0,0 is top-left) instead of TMS/MBTiles convention
+
+	readersOnce sync.Once
+	readers     map[int]TileReader //TileReader converted to each served tile size
+}
+
+//reader returns the TileReader providing tiles of the given size, or nil if the conversion is not possible.
+func (s *Server) reader(size int) TileReader {
+	s.readersOnce.Do(func() {
+		s.readers = make(map[int]TileReader)
+		for _, size := range []int{DefaultTileSize, 2 * DefaultTileSize} {
+			r, err := NewTileSizeConverter(s.TileReader, size)
+			if err != nil {
+				log.Print("Error: ", err)
+				continue
+			}
+			s.readers[size] = r
+		}
+	})
+	return s.readers[size]
 }
 
 //ServeHTTP implements net/http.Handler
@@ -49,7 +81,7 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	//Split URL
 	m := urlRegex.FindStringSubmatch(r.URL.Path)
-	if m == nil || len(m) != 5 {
+	if m == nil || len(m) != 6 {
 		log.Print("Invalid URL: ", r.URL.Path, m)
 		http.NotFound(w, r)
 		return
@@ -74,14 +106,24 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	//TODO: m[4] is requested extension (jpeg or png). If it is not the stored format, we can convert on the fly.
+	size := DefaultTileSize
+	if m[4] == "@2x" {
+		size = 2 * DefaultTileSize
+	}
+	//TODO: m[5] is requested extension (jpeg or png). If it is not the stored format, we can convert on the fly.
+
+	tileReader := s.reader(size)
+	if tileReader == nil {
+		http.NotFound(w, r)
+		return
+	}
 
 	//Reverse y to follow osm conventions
 	if s.ZeroIsTop {
 		y = (1 << uint(level)) - y - 1
 	}
 
-	tileData, err := s.TileReader.GetRaw(level, x, y)
+	tileData, err := tileReader.GetRaw(level, x, y)
 	if err != nil {
 		log.Print("Error: ", level, x, y, err)
 		http.NotFound(w, r)
@@ -89,7 +131,7 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	if tileData == nil {
 		log.Print("Not found: ", level, x, y, err)
-		tileData = grayTile
+		tileData = grayTiles[size]
 	}
 	w.Write(tileData)
 }
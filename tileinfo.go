@@ -0,0 +1,89 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package raster
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+//TileInfo describes when a stored tile was written and what its content is.
+type TileInfo struct {
+	ModTime time.Time //Time of the last write of the tile
+	ETag    string    //Opaque identifier of the content of the tile, such as a content hash or an HTTP ETag
+}
+
+//TileInfoReader is the interface implemented by a TileReader knowing when its tiles were written.
+type TileInfoReader interface {
+	//TileInfo retrieves the information of the tile for a given level/x/y.
+	//It returns false if the tile or its information is not available.
+	TileInfo(level, x, y int) (TileInfo, bool, error)
+}
+
+//ModTimeReader is the interface implemented by a TileInfoReader able to retrieve the modification time of a tile
+//more cheaply than its whole information, such as a tile folder which computes the ETags from the content of the tiles.
+type ModTimeReader interface {
+	//TileModTime retrieves the modification time of the tile for a given level/x/y.
+	//It returns false if the tile or its information is not available.
+	TileModTime(level, x, y int) (time.Time, bool, error)
+}
+
+//ErrNotModified is returned by a ConditionalReader when the tile did not change.
+var ErrNotModified = errors.New("raster: tile not modified")
+
+//ConditionalReader is the interface implemented by a TileReader able to retrieve a tile only if it changed,
+//such as an HTTP server supporting conditional requests.
+type ConditionalReader interface {
+	//GetRawIfModified retrieves the tile for a given level/x/y, unless it did not change since a copy described by info was stored.
+	//It returns the information describing the retrieved tile (e.g. its HTTP ETag), or ErrNotModified if the tile did not change.
+	GetRawIfModified(level, x, y int, info TileInfo) ([]byte, TileInfo, error)
+}
+
+//TileInfoWriter is the interface implemented by a TileReadWriter able to store the information of its tiles.
+type TileInfoWriter interface {
+	//SetRawInfo stores the tile for a given level/x/y, with its ETag. A zero ModTime means the current time.
+	SetRawInfo(level, x, y int, img []byte, info TileInfo) error
+}
+
+//ContentETag computes the ETag of a tile from its content: the hexadecimal MD5 hash of the data.
+func ContentETag(b []byte) string {
+	sum := md5.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
+
+//ModifiedSinceFilter creates a Filter skipping the tiles of r written at or after t, so that only older tiles are refreshed.
+//Tiles missing from r are not skipped. Tiles without information, including all the tiles of a TileReader
+//not implementing TileInfoReader, are considered as old and are not skipped either.
+//Only the modification times are read from a ModTimeReader.
+func ModifiedSinceFilter(r TileReader, t time.Time) Filter {
+	mr, ok := r.(ModTimeReader)
+	if !ok {
+		if ir, isInfoReader := r.(TileInfoReader); isInfoReader {
+			mr, ok = infoModTimeReader{ir}, true
+		}
+	}
+	return func(level, x, y int) (bool, error) {
+		if !ok {
+			return false, nil
+		}
+		modTime, found, err := mr.TileModTime(level, x, y)
+		if err != nil || !found {
+			return false, err
+		}
+		return !modTime.Before(t), nil
+	}
+}
+
+//infoModTimeReader reads the modification times from the whole information of the tiles
+type infoModTimeReader struct {
+	TileInfoReader
+}
+
+func (r infoModTimeReader) TileModTime(level, x, y int) (time.Time, bool, error) {
+	info, found, err := r.TileInfo(level, x, y)
+	return info.ModTime, found, err
+}
@@ -0,0 +1,157 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package raster
+
+import (
+	"testing"
+	"time"
+)
+
+//infoTiles is a memTiles recording the time of each write, used for tests.
+type infoTiles struct {
+	*memTiles
+	infos map[TileID]TileInfo
+}
+
+func (m *infoTiles) SetRaw(level, x, y int, img []byte) error {
+	m.infos[TileID{level, x, y}] = TileInfo{ModTime: time.Now(), ETag: ContentETag(img)}
+	return m.memTiles.SetRaw(level, x, y, img)
+}
+
+func (m *infoTiles) TileInfo(level, x, y int) (TileInfo, bool, error) {
+	info, ok := m.infos[TileID{level, x, y}]
+	return info, ok, nil
+}
+
+func (m *infoTiles) SetRawInfo(level, x, y int, img []byte, info TileInfo) error {
+	if info.ModTime.IsZero() {
+		info.ModTime = time.Now()
+	}
+	m.infos[TileID{level, x, y}] = info
+	return m.memTiles.SetRaw(level, x, y, img)
+}
+
+//versionedTiles is a ConditionalReader serving a single version of each tile, used for tests.
+type versionedTiles struct {
+	*memTiles
+	version string
+	gets    int
+}
+
+func (m *versionedTiles) GetRawIfModified(level, x, y int, info TileInfo) ([]byte, TileInfo, error) {
+	if info.ETag == m.version {
+		return nil, TileInfo{}, ErrNotModified
+	}
+	m.gets++
+	b, err := m.GetRaw(level, x, y)
+	return b, TileInfo{ETag: m.version}, err
+}
+
+func TestCopyNotModified(t *testing.T) {
+	src := &versionedTiles{memTiles: newMemTiles("png", 256), version: `"v1"`}
+	src.SetRaw(1, 0, 0, []byte("tile"))
+	dst := &infoTiles{memTiles: newMemTiles("png", 256), infos: make(map[TileID]TileInfo)}
+
+	c, err := NewCopier(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if copied, err := c.Copy(1, 0, 0); !copied || err != nil {
+		t.Fatalf("Copy() => %v, %v", copied, err)
+	}
+	if info, _, _ := dst.TileInfo(1, 0, 0); info.ETag != `"v1"` || info.ModTime.IsZero() {
+		t.Errorf("TileInfo() => %+v, want the ETag of the source", info)
+	}
+
+	//Unchanged tile: not copied again, but its modification time is refreshed
+	old := time.Now().Add(-48 * time.Hour)
+	dst.infos[TileID{1, 0, 0}] = TileInfo{ModTime: old, ETag: `"v1"`}
+	if copied, err := c.Copy(1, 0, 0); copied || err != nil {
+		t.Errorf("Copy() of a not modified tile => %v, %v", copied, err)
+	}
+	if info, _, _ := dst.TileInfo(1, 0, 0); info.ETag != `"v1"` || !info.ModTime.After(old) {
+		t.Errorf("TileInfo() => %+v, want a refreshed modification time", info)
+	}
+
+	//New version
+	src.version = `"v2"`
+	if copied, err := c.Copy(1, 0, 0); !copied || err != nil {
+		t.Errorf("Copy() of a modified tile => %v, %v", copied, err)
+	}
+	if src.gets != 2 {
+		t.Errorf("%d tiles retrieved, want 2", src.gets)
+	}
+}
+
+func TestModifiedSinceFilter(t *testing.T) {
+	now := time.Now()
+	m := &infoTiles{memTiles: newMemTiles("png", 256), infos: make(map[TileID]TileInfo)}
+	m.SetRaw(1, 0, 0, []byte("recent"))
+	m.SetRaw(1, 0, 1, []byte("old"))
+	m.infos[TileID{1, 0, 1}] = TileInfo{ModTime: now.Add(-48 * time.Hour)}
+	m.memTiles.SetRaw(1, 1, 0, []byte("unknown"))
+
+	filter := ModifiedSinceFilter(m, now.Add(-24*time.Hour))
+	testCases := []struct {
+		tile     TileID
+		filtered bool
+	}{
+		{TileID{1, 0, 0}, true},  //written recently
+		{TileID{1, 0, 1}, false}, //written 2 days ago
+		{TileID{1, 1, 0}, false}, //no information
+		{TileID{1, 1, 1}, false}, //missing
+	}
+	for _, tc := range testCases {
+		filtered, err := filter(tc.tile.Level, tc.tile.X, tc.tile.Y)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filtered != tc.filtered {
+			t.Errorf("ModifiedSinceFilter(%s) => %v, want %v", tc.tile, filtered, tc.filtered)
+		}
+	}
+
+	if filtered, _ := ModifiedSinceFilter(m.memTiles, now)(1, 0, 0); filtered {
+		t.Error("ModifiedSinceFilter() should not skip tiles of a reader without information")
+	}
+
+	if info, _, _ := m.TileInfo(1, 0, 0); info.ETag != "8154f2ab366901a6744c15cef7c62eba" {
+		t.Errorf("ContentETag() => %s", info.ETag)
+	}
+}
+
+//modTimeTiles is an infoTiles reading the modification times alone, used for tests.
+type modTimeTiles struct {
+	*infoTiles
+	infoReads int
+}
+
+func (m *modTimeTiles) TileInfo(level, x, y int) (TileInfo, bool, error) {
+	m.infoReads++
+	return m.infoTiles.TileInfo(level, x, y)
+}
+
+func (m *modTimeTiles) TileModTime(level, x, y int) (time.Time, bool, error) {
+	info, ok := m.infos[TileID{level, x, y}]
+	return info.ModTime, ok, nil
+}
+
+func TestModifiedSinceFilterModTime(t *testing.T) {
+	now := time.Now()
+	m := &modTimeTiles{infoTiles: &infoTiles{memTiles: newMemTiles("png", 256), infos: make(map[TileID]TileInfo)}}
+	m.SetRaw(1, 0, 0, []byte("recent"))
+
+	filter := ModifiedSinceFilter(m, now.Add(-24*time.Hour))
+	if filtered, err := filter(1, 0, 0); !filtered || err != nil {
+		t.Errorf("ModifiedSinceFilter() => %v, %v, want the recent tile skipped", filtered, err)
+	}
+	if filtered, err := filter(1, 1, 1); filtered || err != nil {
+		t.Errorf("ModifiedSinceFilter() of a missing tile => %v, %v", filtered, err)
+	}
+	if m.infoReads != 0 {
+		t.Errorf("ModifiedSinceFilter() read the information of %d tiles, want only their modification time", m.infoReads)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package raster
+
+//TileJSONVersion is the version of the TileJSON specification produced by NewTileJSON
+const TileJSONVersion = "2.2.0"
+
+//maxTileJSONZoom is the default maximum zoom level of the TileJSON specification
+const maxTileJSONZoom = 30
+
+//TileJSON describes a tileset following the TileJSON specification (https://github.com/mapbox/tilejson-spec).
+type TileJSON struct {
+	TileJSON    string    `json:"tilejson"`
+	Name        string    `json:"name,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Version     string    `json:"version,omitempty"`
+	Attribution string    `json:"attribution,omitempty"`
+	Scheme      string    `json:"scheme,omitempty"` //xyz (y=0 at the top, the default) or tms (y=0 at the bottom)
+	Tiles       []string  `json:"tiles"`            //Tile URL templates with {z}, {x} and {y} placeholders
+	MinZoom     int       `json:"minzoom"`
+	MaxZoom     int       `json:"maxzoom"`
+	Bounds      []float64 `json:"bounds,omitempty"` //Longitude min, latitude min, longitude max, latitude max
+	Center      []float64 `json:"center,omitempty"` //Longitude, latitude, zoom level
+	Format      string    `json:"format,omitempty"` //Non standard extension: image format of the tiles (png or jpg)
+}
+
+//LevelRanger is the interface implemented by a TileReader knowing the zoom levels of its tiles.
+type LevelRanger interface {
+	//LevelRange returns the minimum and maximum zoom levels of the tiles
+	LevelRange() (levelMin, levelMax int, err error)
+}
+
+//Attributed is the interface implemented by a TileReader providing the attribution of its tiles.
+type Attributed interface {
+	//Attribution returns the attribution (such as a copyright notice) to display with the tiles
+	Attribution() string
+}
+
+//NewTileJSON creates the TileJSON describing the tiles of r, served at the given URL templates with the xyz scheme.
+//The zoom range, the bounds and the attribution are filled if r implements LevelRanger, Bounded and Attributed.
+func NewTileJSON(r TileReader, tiles ...string) (TileJSON, error) {
+	tj := TileJSON{
+		TileJSON: TileJSONVersion,
+		Scheme:   "xyz",
+		Tiles:    tiles,
+		MaxZoom:  maxTileJSONZoom,
+		Format:   r.TileFormat(),
+	}
+
+	if lr, ok := r.(LevelRanger); ok {
+		levelMin, levelMax, err := lr.LevelRange()
+		if err != nil {
+			return tj, err
+		}
+		tj.MinZoom, tj.MaxZoom = levelMin, levelMax
+	}
+
+	if b, ok := r.(Bounded); ok {
+		bbox, err := b.Bounds()
+		if err != nil {
+			return tj, err
+		}
+		tj.Bounds = []float64{bbox.LongitudeMinDeg, bbox.LatitudeMinDeg, bbox.LongitudeMaxDeg, bbox.LatitudeMaxDeg}
+		tj.Center = []float64{
+			(bbox.LongitudeMinDeg + bbox.LongitudeMaxDeg) / 2,
+			(bbox.LatitudeMinDeg + bbox.LatitudeMaxDeg) / 2,
+			float64(tj.MinZoom),
+		}
+	}
+
+	if a, ok := r.(Attributed); ok {
+		tj.Attribution = a.Attribution()
+	}
+
+	return tj, nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package raster
+
+import (
+	"testing"
+
+	"github.com/xeonx/geographic"
+)
+
+//describedTiles is a memTiles knowing its levels, bounds and attribution, used for tests.
+type describedTiles struct {
+	*memTiles
+}
+
+func (m describedTiles) LevelRange() (int, int, error) { return 2, 12, nil }
+func (m describedTiles) Attribution() string           { return "© Test" }
+func (m describedTiles) Bounds() (geographic.BoundingBox, error) {
+	return geographic.BoundingBox{LongitudeMinDeg: 2, LongitudeMaxDeg: 3, LatitudeMinDeg: 48, LatitudeMaxDeg: 49}, nil
+}
+
+func TestNewTileJSON(t *testing.T) {
+	tj, err := NewTileJSON(newMemTiles("png", 256), "http://example.com/{z}/{x}/{y}.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tj.TileJSON != TileJSONVersion || tj.Scheme != "xyz" || len(tj.Tiles) != 1 || tj.MinZoom != 0 || tj.MaxZoom != 30 ||
+		tj.Bounds != nil || tj.Format != "png" {
+		t.Errorf("NewTileJSON() => %+v", tj)
+	}
+
+	tj, err = NewTileJSON(describedTiles{newMemTiles("jpg", 256)}, "http://a.example.com/{z}/{x}/{y}.jpg", "http://b.example.com/{z}/{x}/{y}.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tj.MinZoom != 2 || tj.MaxZoom != 12 || tj.Attribution != "© Test" || len(tj.Tiles) != 2 || tj.Format != "jpg" {
+		t.Errorf("NewTileJSON() => %+v", tj)
+	}
+	if len(tj.Bounds) != 4 || tj.Bounds[0] != 2 || tj.Bounds[1] != 48 || tj.Bounds[2] != 3 || tj.Bounds[3] != 49 {
+		t.Errorf("NewTileJSON() bounds => %v", tj.Bounds)
+	}
+	if len(tj.Center) != 3 || tj.Center[0] != 2.5 || tj.Center[1] != 48.5 || tj.Center[2] != 2 {
+		t.Errorf("NewTileJSON() center => %v", tj.Center)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package raster
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+//TileID identifies a single tile
+type TileID struct {
+	Level int
+	X     int
+	Y     int
+}
+
+//String formats the tile as "level/x/y"
+func (t TileID) String() string {
+	return fmt.Sprintf("%d/%d/%d", t.Level, t.X, t.Y)
+}
+
+//FlipY converts the tile between the TMS convention (y=0 at the bottom) used in this library
+//and the XYZ convention (y=0 at the top) used by most web maps.
+func (t TileID) FlipY() TileID {
+	return TileID{Level: t.Level, X: t.X, Y: n(t.Level) - 1 - t.Y}
+}
+
+//ParseTileID parses a tile given as "level/x/y". y is returned as given, without any conversion.
+func ParseTileID(s string) (TileID, error) {
+	parts := strings.Split(strings.TrimSpace(s), "/")
+	if len(parts) != 3 {
+		return TileID{}, fmt.Errorf("Invalid tile '%s': level/x/y expected", s)
+	}
+	var values [3]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			return TileID{}, fmt.Errorf("Invalid tile '%s': %s", s, err)
+		}
+		values[i] = v
+	}
+
+	t := TileID{Level: values[0], X: values[1], Y: values[2]}
+	if t.Level < 0 || t.Level > 30 || t.X < 0 || t.X >= n(t.Level) || t.Y < 0 || t.Y >= n(t.Level) {
+		return TileID{}, fmt.Errorf("Invalid tile '%s': out of the tile matrix", s)
+	}
+	return t, nil
+}
+
+//TileIterator iterates over a list of tiles. Its usage follows the one of sql.Rows:
+//
+//	for it.Next() {
+//		tile := it.Tile()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type TileIterator interface {
+	//Next prepares the next tile. It returns false at the end of the list or on error.
+	Next() bool
+	//Tile returns the current tile
+	Tile() TileID
+	//Err returns the error, if any, encountered during the iteration
+	Err() error
+}
+
+//tileSliceIterator is a TileIterator over a slice of tiles
+type tileSliceIterator struct {
+	tiles []TileID
+	i     int
+}
+
+//NewTileSliceIterator creates a TileIterator over a slice of tiles
+func NewTileSliceIterator(tiles []TileID) TileIterator {
+	return &tileSliceIterator{tiles: tiles, i: -1}
+}
+
+func (it *tileSliceIterator) Next() bool {
+	it.i++
+	return it.i < len(it.tiles)
+}
+
+func (it *tileSliceIterator) Tile() TileID {
+	return it.tiles[it.i]
+}
+
+func (it *tileSliceIterator) Err() error {
+	return nil
+}
+
+//tileListReader is a TileIterator reading a tile list, one tile per line
+type tileListReader struct {
+	scanner *bufio.Scanner
+	xyz     bool
+	line    int
+	tile    TileID
+	err     error
+}
+
+//NewTileListReader creates a TileIterator reading a list of tiles, one "level/x/y" per line.
+//Empty lines and lines starting with # are ignored. The list may be gzip compressed.
+//
+//If xyz is true, y is counted from the top (as in XYZ URLs and osm2pgsql expire lists)
+//and converted to the TMS convention used in this library.
+func NewTileListReader(r io.Reader, xyz bool) (TileIterator, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		r = gz
+	} else {
+		r = br
+	}
+
+	return &tileListReader{
+		scanner: bufio.NewScanner(r),
+		xyz:     xyz,
+	}, nil
+}
+
+func (it *tileListReader) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	for it.scanner.Scan() {
+		it.line++
+		line := strings.TrimSpace(it.scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		t, err := ParseTileID(line)
+		if err != nil {
+			it.err = fmt.Errorf("Line %d: %s", it.line, err)
+			return false
+		}
+		if it.xyz {
+			t = t.FlipY()
+		}
+		it.tile = t
+		return true
+	}
+	it.err = it.scanner.Err()
+	return false
+}
+
+func (it *tileListReader) Tile() TileID {
+	return it.tile
+}
+
+func (it *tileListReader) Err() error {
+	return it.err
+}
+
+//CopyTiles copies each tile provided by an iterator.
+//If progressFct is not nil, it is called during the iteration after each tile.
+//It returns the count of tiles copied in the destination and the first error encountered, if any.
+func (c *Copier) CopyTiles(tiles TileIterator, progressFct func(level, x, y int, processed bool)) (int, error) {
+	processedCount := 0
+	for tiles.Next() {
+		t := tiles.Tile()
+		processed, err := c.Copy(t.Level, t.X, t.Y)
+		if err != nil {
+			return processedCount, err
+		}
+		if progressFct != nil {
+			progressFct(t.Level, t.X, t.Y, processed)
+		}
+		if processed {
+			processedCount++
+		}
+	}
+	return processedCount, tiles.Err()
+}
@@ -0,0 +1,201 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package raster
+
+import (
+	"bytes"
+	"compress/gzip"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+const testTileList = `# expired tiles
+1/0/0
+
+2/3/1
+ 2/1/2 
+`
+
+func TestTileListReader(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(testTileList))
+	w.Close()
+
+	for _, compressed := range []bool{false, true} {
+		r := strings.NewReader(testTileList)
+		if compressed {
+			r = strings.NewReader(gz.String())
+		}
+
+		it, err := NewTileListReader(r, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []TileID
+		for it.Next() {
+			got = append(got, it.Tile())
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		//y is flipped from XYZ to TMS
+		expected := []TileID{{1, 0, 1}, {2, 3, 2}, {2, 1, 1}}
+		if len(got) != len(expected) {
+			t.Fatalf("NewTileListReader(compressed=%v) => %v, want %v", compressed, got, expected)
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Errorf("NewTileListReader(compressed=%v) => %v, want %v", compressed, got, expected)
+			}
+		}
+	}
+
+	for _, list := range []string{"1/2/0", "1/0", "a/0/0", "1/0/0\n-1/0/0"} {
+		it, err := NewTileListReader(strings.NewReader(list), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for it.Next() {
+		}
+		if it.Err() == nil {
+			t.Errorf("NewTileListReader(%q) should fail", list)
+		}
+	}
+}
+
+func TestCopyTiles(t *testing.T) {
+	src := newMemTiles("png", 256)
+	tile := uniformTile(t, 256, color.RGBA{255, 0, 0, 255})
+	for _, id := range []TileID{{1, 0, 0}, {1, 1, 0}, {2, 3, 3}} {
+		src.SetRaw(id.Level, id.X, id.Y, tile)
+	}
+	dst := newMemTiles("png", 256)
+	dst.SetRaw(1, 1, 0, tile)
+
+	c, err := NewCopier(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Filter = dst.Contains
+
+	progress := 0
+	processed, err := c.CopyTiles(NewTileSliceIterator([]TileID{{1, 0, 0}, {1, 1, 0}, {2, 3, 3}}), func(level, x, y int, processed bool) {
+		progress++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processed != 2 || progress != 3 {
+		t.Errorf("CopyTiles() => %d processed, %d progress, want 2 and 3", processed, progress)
+	}
+	if ok, _ := dst.Contains(2, 3, 3); !ok {
+		t.Error("CopyTiles() did not copy 2/3/3")
+	}
+}
+
+func TestCopyMissing(t *testing.T) {
+	tile := uniformTile(t, 256, color.RGBA{255, 0, 0, 255})
+	for _, format := range []string{"png", "jpg"} {
+		src := newMemTiles("png", 256)
+		src.SetRaw(1, 0, 0, tile)
+		dst := newMemTiles(format, 256)
+
+		c, err := NewCopier(src, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		processed, err := c.CopyBlock(TileBlock{Level: 1, Xmin: 0, Xmax: 1, Ymin: 0, Ymax: 1}, nil)
+		if err != nil || processed != 1 {
+			t.Errorf("CopyBlock(%s) => %d, %v, want 1 processed", format, processed, err)
+		}
+		if ok, _ := dst.Contains(1, 1, 1); ok {
+			t.Errorf("CopyBlock(%s) wrote a tile missing in the source", format)
+		}
+	}
+
+	//Stored tiles missing in the source are deleted with DeleteMissing
+	src := newMemTiles("png", 256)
+	dst := newMemTiles("png", 256)
+	dst.SetRaw(1, 1, 1, tile)
+	c, err := NewCopier(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processed, err := c.Copy(1, 1, 1); err != nil || processed {
+		t.Errorf("Copy() => %v, %v", processed, err)
+	}
+	if ok, _ := dst.Contains(1, 1, 1); !ok {
+		t.Error("Copy() deleted a tile without DeleteMissing")
+	}
+	c.DeleteMissing = true
+	if processed, err := c.Copy(1, 1, 1); err != nil || processed {
+		t.Errorf("Copy() => %v, %v", processed, err)
+	}
+	if ok, _ := dst.Contains(1, 1, 1); ok {
+		t.Error("Copy() did not delete the tile missing in the source")
+	}
+}
+
+//tileArea is an Area made of a set of tiles of a single level, used for tests.
+type tileArea map[TileID]bool
+
+func (a tileArea) Blocks(level int, blockSize int) []TileBlock {
+	var blocks []TileBlock
+	for t := range a {
+		if t.Level == level {
+			x, y := t.X/blockSize*blockSize, t.Y/blockSize*blockSize
+			blocks = append(blocks, TileBlock{Level: level, Xmin: x, Xmax: x + blockSize - 1, Ymin: y, Ymax: y + blockSize - 1})
+		}
+	}
+	return blocks
+}
+
+func (a tileArea) Intersects(level, x, y int) bool {
+	return a[TileID{level, x, y}]
+}
+
+func TestCopyBlockArea(t *testing.T) {
+	src := newMemTiles("png", 256)
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 8; y++ {
+			src.SetRaw(3, x, y, []byte("tile"))
+		}
+	}
+	dst := newMemTiles("png", 256)
+
+	c, err := NewCopier(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Area = tileArea{{3, 1, 1}: true, {3, 6, 6}: true, {3, 7, 0}: true}
+
+	progress := 0
+	processed, err := c.CopyBlock(TileBlock{Level: 3, Xmin: 0, Xmax: 6, Ymin: 0, Ymax: 7}, func(level, x, y int, processed bool) {
+		progress++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processed != 2 || progress != 2 {
+		t.Errorf("CopyBlock() => %d processed, %d progress, want only the 2 tiles of the area in the block", processed, progress)
+	}
+	for _, id := range []TileID{{3, 1, 1}, {3, 6, 6}} {
+		if ok, _ := dst.Contains(id.Level, id.X, id.Y); !ok {
+			t.Errorf("CopyBlock() did not copy %s", id)
+		}
+	}
+
+	//Blocks aligned on metatiles may contain tiles outside of the area, which are not copied
+	c.MetatileSize = 2
+	if processed, err := c.CopyBlock(TileBlock{Level: 3, Xmin: 0, Xmax: 7, Ymin: 0, Ymax: 7}, nil); processed != 3 || err != nil {
+		t.Errorf("CopyBlock() with metatiles => %d processed, %v, want 3", processed, err)
+	}
+	if ok, _ := dst.Contains(3, 0, 0); ok {
+		t.Error("CopyBlock() copied a tile outside of the area")
+	}
+}
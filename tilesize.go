@@ -0,0 +1,295 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package raster
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+//DefaultTileSize is the width and height in pixels of the tiles of a TileReader not implementing TileSizer.
+const DefaultTileSize = 256
+
+//TileSizer is the interface implemented by a TileReader knowing the pixel size of its tiles.
+type TileSizer interface {
+	//TileSize returns the width and height in pixels of the tiles
+	TileSize() int
+}
+
+//TileSizeWriter is the interface implemented by a TileReadWriter storing the pixel size of its tiles.
+type TileSizeWriter interface {
+	//SetTileSize records the width and height in pixels of the tiles
+	SetTileSize(size int) error
+}
+
+//TileSize returns the width and height in pixels of the tiles provided by r.
+//DefaultTileSize is returned if r does not implement TileSizer.
+func TileSize(r TileReader) int {
+	if s, ok := r.(TileSizer); ok {
+		if size := s.TileSize(); size > 0 {
+			return size
+		}
+	}
+	return DefaultTileSize
+}
+
+//tileSizeConverter is a TileReader providing the tiles of a source at a different pixel size.
+type tileSizeConverter struct {
+	src     TileReader
+	format  string
+	srcSize int
+	size    int
+	shift   uint //Zoom shift between the source and the converted tiles: log2 of the ratio between sizes
+}
+
+//NewTileSizeConverter creates a TileReader providing the tiles of src with a width and height of size pixels.
+//The ratio between size and the tile size of src must be a power of 2. If the sizes are equal, src is returned.
+//
+//Whatever its pixel size, a tile at a given level/x/y covers the same area. Larger tiles (e.g. 512 from a 256 source)
+//are thus merged from the source tiles at a higher level (level+1 for a ratio of 2).
+//Smaller tiles (e.g. 256 from a 512 source) are cut from the source tile at a lower level (level-1 for a ratio of 2).
+//At the levels not available in the source (i.e. level 0 for a ratio of 2), the source tile at level 0 is scaled down.
+//
+//If src is a MetatileReader, so is the returned TileReader: its metatiles are built from the metatiles of src.
+func NewTileSizeConverter(src TileReader, size int) (TileReader, error) {
+	srcSize := TileSize(src)
+	if size == srcSize {
+		return src, nil
+	}
+
+	small, large := srcSize, size
+	if small > large {
+		small, large = large, small
+	}
+	var shift uint
+	for small<<shift < large {
+		shift++
+	}
+	if small <= 0 || small<<shift != large {
+		return nil, fmt.Errorf("raster: unsupported tile size conversion from %d to %d", srcSize, size)
+	}
+
+	c := &tileSizeConverter{
+		src:     src,
+		format:  src.TileFormat(),
+		srcSize: srcSize,
+		size:    size,
+		shift:   shift,
+	}
+	if mr, ok := src.(MetatileReader); ok {
+		return &metatileSizeConverter{tileSizeConverter: c, mr: mr}, nil
+	}
+	return c, nil
+}
+
+//TileFormat exposes the image format of the source (png or jpg)
+func (c *tileSizeConverter) TileFormat() string {
+	return c.format
+}
+
+//TileSize returns the width and height in pixels of the tiles
+func (c *tileSizeConverter) TileSize() int {
+	return c.size
+}
+
+//parent returns the source tile containing the given tile, and the number of levels between them.
+func (c *tileSizeConverter) parent(level, x, y int) (int, int, int, uint) {
+	parentLevel := level - int(c.shift)
+	if parentLevel < 0 {
+		parentLevel = 0
+	}
+	d := uint(level - parentLevel)
+	return parentLevel, x >> d, y >> d, d
+}
+
+//GetRaw retrieves the tile for a given level/x/y.
+func (c *tileSizeConverter) GetRaw(level, x, y int) ([]byte, error) {
+	var img image.Image
+	var err error
+	if c.size > c.srcSize {
+		img, err = c.merge(level, x, y)
+	} else {
+		img, err = c.split(level, x, y)
+	}
+	if err != nil || img == nil {
+		return nil, err
+	}
+
+	return Encode(img, c.format)
+}
+
+//merge builds a large tile from the source tiles it covers. It returns nil if none of them is available.
+func (c *tileSizeConverter) merge(level, x, y int) (image.Image, error) {
+	n := 1 << c.shift
+	dst := image.NewRGBA(image.Rect(0, 0, c.size, c.size))
+
+	found := false
+	for dx := 0; dx < n; dx++ {
+		for dy := 0; dy < n; dy++ {
+			rawImg, err := c.src.GetRaw(level+int(c.shift), x*n+dx, y*n+dy)
+			if err != nil {
+				return nil, err
+			}
+			if rawImg == nil {
+				continue
+			}
+			img, err := Decode(rawImg, c.format)
+			if err != nil {
+				return nil, err
+			}
+
+			//y follows the TMS convention: the first row is at the bottom of the image
+			top := (n - 1 - dy) * c.srcSize
+			r := image.Rect(dx*c.srcSize, top, (dx+1)*c.srcSize, top+c.srcSize)
+			draw.Draw(dst, r, img, img.Bounds().Min, draw.Src)
+			found = true
+		}
+	}
+
+	if !found {
+		return nil, nil
+	}
+	return dst, nil
+}
+
+//split cuts a small tile from the source tile containing it. It returns nil if the source tile is not available.
+func (c *tileSizeConverter) split(level, x, y int) (image.Image, error) {
+	parentLevel, px, py, d := c.parent(level, x, y)
+
+	rawImg, err := c.src.GetRaw(parentLevel, px, py)
+	if err != nil || rawImg == nil {
+		return nil, err
+	}
+	img, err := Decode(rawImg, c.format)
+	if err != nil {
+		return nil, err
+	}
+
+	//y follows the TMS convention: the first row is at the bottom of the image
+	regionSize := c.srcSize >> d
+	left := (x - px<<d) * regionSize
+	top := (1<<d - 1 - (y - py<<d)) * regionSize
+	region := image.Rect(left, top, left+regionSize, top+regionSize).Add(img.Bounds().Min)
+
+	return scaleDown(img, region, c.size), nil
+}
+
+//metatileSizeConverter is a tileSizeConverter of a MetatileReader, providing the metatiles of the source at a different pixel size.
+type metatileSizeConverter struct {
+	*tileSizeConverter
+	mr MetatileReader
+}
+
+//GetRawMetatile retrieves a single image covering all the tiles of block, from the metatile of the source covering the same area.
+func (c *metatileSizeConverter) GetRawMetatile(block TileBlock) ([]byte, error) {
+	d := c.shift
+	if c.size > c.srcSize {
+		//The source metatile covering the same tiles at a higher level has the same pixels
+		return c.mr.GetRawMetatile(TileBlock{
+			Level: block.Level + int(d),
+			Xmin:  block.Xmin << d,
+			Xmax:  (block.Xmax+1)<<d - 1,
+			Ymin:  block.Ymin << d,
+			Ymax:  (block.Ymax+1)<<d - 1,
+		})
+	}
+
+	w, h := block.Xmax-block.Xmin+1, block.Ymax-block.Ymin+1
+	dst := image.NewRGBA(image.Rect(0, 0, w*c.size, h*c.size))
+	if block.Level < int(d) {
+		//Tiles scaled down from the source tile at level 0
+		found := false
+		for x := block.Xmin; x <= block.Xmax; x++ {
+			for y := block.Ymin; y <= block.Ymax; y++ {
+				img, err := c.split(block.Level, x, y)
+				if err != nil {
+					return nil, err
+				}
+				if img == nil {
+					continue
+				}
+				pt := image.Pt((x-block.Xmin)*c.size, (block.Ymax-y)*c.size)
+				draw.Draw(dst, image.Rectangle{pt, pt.Add(image.Pt(c.size, c.size))}, img, img.Bounds().Min, draw.Src)
+				found = true
+			}
+		}
+		if !found {
+			return nil, nil
+		}
+		return Encode(dst, c.format)
+	}
+
+	//The block is cut from the source metatile containing it, at the same resolution
+	src := TileBlock{Level: block.Level - int(d), Xmin: block.Xmin >> d, Xmax: block.Xmax >> d, Ymin: block.Ymin >> d, Ymax: block.Ymax >> d}
+	rawImg, err := c.mr.GetRawMetatile(src)
+	if err != nil || rawImg == nil {
+		return nil, err
+	}
+	img, err := Decode(rawImg, c.format)
+	if err != nil {
+		return nil, err
+	}
+
+	//y follows the TMS convention: the first row is at the bottom of the image
+	left := (block.Xmin - src.Xmin<<d) * c.size
+	top := ((src.Ymax+1)<<d - 1 - block.Ymax) * c.size
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min.Add(image.Pt(left, top)), draw.Src)
+	return Encode(dst, c.format)
+}
+
+//scaleDown returns the region r of img scaled down to a size x size image by averaging the pixels.
+//The width and height of r must be a multiple of size.
+func scaleDown(img image.Image, r image.Rectangle, size int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	factor := r.Dx() / size
+	if factor <= 1 {
+		draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+		return dst
+	}
+
+	count := uint64(factor * factor)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			var sr, sg, sb, sa uint64
+			for j := 0; j < factor; j++ {
+				for i := 0; i < factor; i++ {
+					cr, cg, cb, ca := img.At(r.Min.X+x*factor+i, r.Min.Y+y*factor+j).RGBA()
+					sr += uint64(cr)
+					sg += uint64(cg)
+					sb += uint64(cb)
+					sa += uint64(ca)
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(sr / count),
+				G: uint16(sg / count),
+				B: uint16(sb / count),
+				A: uint16(sa / count),
+			})
+		}
+	}
+	return dst
+}
+
+//Contains returns true if the reader already contains the tile for a given level/x/y
+func (c *tileSizeConverter) Contains(level int, x, y int) (bool, error) {
+	if c.size < c.srcSize {
+		parentLevel, px, py, _ := c.parent(level, x, y)
+		return c.src.Contains(parentLevel, px, py)
+	}
+
+	n := 1 << c.shift
+	for dx := 0; dx < n; dx++ {
+		for dy := 0; dy < n; dy++ {
+			found, err := c.src.Contains(level+int(c.shift), x*n+dx, y*n+dy)
+			if err != nil || found {
+				return found, err
+			}
+		}
+	}
+	return false, nil
+}
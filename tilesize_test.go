@@ -0,0 +1,209 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package raster
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+	"testing"
+)
+
+//memTiles is an in-memory TileReadWriter used for tests.
+type memTiles struct {
+	mu     sync.Mutex
+	format string
+	size   int
+	tiles  map[string][]byte
+}
+
+func newMemTiles(format string, size int) *memTiles {
+	return &memTiles{format: format, size: size, tiles: make(map[string][]byte)}
+}
+
+func (m *memTiles) key(level, x, y int) string { return fmt.Sprintf("%d/%d/%d", level, x, y) }
+func (m *memTiles) TileFormat() string         { return m.format }
+func (m *memTiles) TileSize() int              { return m.size }
+func (m *memTiles) Clear(level int) error      { return nil }
+func (m *memTiles) GetRaw(level, x, y int) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tiles[m.key(level, x, y)], nil
+}
+func (m *memTiles) Contains(level, x, y int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.tiles[m.key(level, x, y)]
+	return ok, nil
+}
+func (m *memTiles) SetRaw(level, x, y int, img []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tiles[m.key(level, x, y)] = img
+	return nil
+}
+
+func (m *memTiles) Delete(level, x, y int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.tiles, m.key(level, x, y))
+	return nil
+}
+
+//uniformTile encodes a size x size png tile of a single color.
+func uniformTile(t *testing.T, size int, c color.Color) []byte {
+	m := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(m, m.Bounds(), &image.Uniform{c}, image.ZP, draw.Src)
+	b, err := Encode(m, "png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestTileSizeConverter(t *testing.T) {
+	colors := map[[2]int]color.RGBA{
+		{0, 0}: {255, 0, 0, 255},   //bottom-left
+		{1, 0}: {0, 255, 0, 255},   //bottom-right
+		{0, 1}: {0, 0, 255, 255},   //top-left
+		{1, 1}: {255, 255, 0, 255}, //top-right
+	}
+
+	src := newMemTiles("png", 256)
+	for xy, c := range colors {
+		src.SetRaw(1, xy[0], xy[1], uniformTile(t, 256, c))
+	}
+
+	//Merge the 4 tiles of level 1 into a single 512 tile at level 0
+	large, err := NewTileSizeConverter(src, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if TileSize(large) != 512 {
+		t.Errorf("TileSize() => %d, want 512", TileSize(large))
+	}
+	raw, err := large.GetRaw(0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := Decode(raw, "png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 512 || img.Bounds().Dy() != 512 {
+		t.Fatalf("GetRaw(0, 0, 0) => %v, want 512x512", img.Bounds())
+	}
+	for xy, c := range colors {
+		px, py := 128+256*xy[0], 128+256*(1-xy[1])
+		if got := color.RGBAModel.Convert(img.At(px, py)); got != c {
+			t.Errorf("merged pixel (%d, %d) => %v, want %v", px, py, got, c)
+		}
+	}
+	if raw, err := large.GetRaw(1, 0, 0); raw != nil || err != nil {
+		t.Errorf("GetRaw(1, 0, 0) => %v, %v, want nil", raw, err)
+	}
+
+	//Split it back into 256 tiles at level 1
+	dst := newMemTiles("png", 512)
+	dst.SetRaw(0, 0, 0, raw)
+	small, err := NewTileSizeConverter(dst, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for xy, c := range colors {
+		raw, err := small.GetRaw(1, xy[0], xy[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := Decode(raw, "png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds().Dx() != 256 {
+			t.Errorf("GetRaw(1, %d, %d) => %v, want 256x256", xy[0], xy[1], img.Bounds())
+		}
+		if got := color.RGBAModel.Convert(img.At(10, 10)); got != c {
+			t.Errorf("split tile (1, %d, %d) => %v, want %v", xy[0], xy[1], got, c)
+		}
+	}
+
+	//Level 0 is the scaled down 512 tile
+	raw, err = small.GetRaw(0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err = Decode(raw, "png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 256 {
+		t.Errorf("GetRaw(0, 0, 0) => %v, want 256x256", img.Bounds())
+	}
+
+	if _, err := NewTileSizeConverter(src, 300); err == nil {
+		t.Errorf("NewTileSizeConverter(256, 300) => no error")
+	}
+}
+
+func TestTileSizeConverterMetatiles(t *testing.T) {
+	for _, tc := range []struct {
+		size     int
+		level    int
+		expected func(x, y int) color.RGBA //Color of the top-left pixel of the tile x/y
+	}{
+		{512, 2, func(x, y int) color.RGBA { return tileColor(2*x, 2*y+1) }}, //merged from level 3
+		{128, 3, func(x, y int) color.RGBA { return tileColor(x/2, y/2) }},   //cut from level 2
+	} {
+		src := &renderer{memTiles: newMemTiles("png", 256)}
+		converter, err := NewTileSizeConverter(src, tc.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := converter.(MetatileReader); !ok {
+			t.Fatalf("NewTileSizeConverter(%d) of a MetatileReader is not a MetatileReader", tc.size)
+		}
+
+		dst := newMemTiles("png", tc.size)
+		c, err := NewCopier(converter, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.MetatileSize = 2
+		block := TileBlock{Level: tc.level, Xmin: 0, Xmax: 3, Ymin: 0, Ymax: 3}
+		if processed, err := c.CopyBlock(block, nil); processed != block.Count() || err != nil {
+			t.Fatalf("CopyBlock(%d) => %d, %v", tc.size, processed, err)
+		}
+		if src.requests != 4 {
+			t.Errorf("CopyBlock(%d) => %d metatile requests, want 4", tc.size, src.requests)
+		}
+
+		for x := block.Xmin; x <= block.Xmax; x++ {
+			for y := block.Ymin; y <= block.Ymax; y++ {
+				raw, _ := dst.GetRaw(block.Level, x, y)
+				img, err := Decode(raw, "png")
+				if err != nil {
+					t.Fatalf("tile (%d, %d): %v", x, y, err)
+				}
+				if img.Bounds().Dx() != tc.size || img.Bounds().Dy() != tc.size {
+					t.Errorf("tile (%d, %d) => %v, want %dx%d", x, y, img.Bounds(), tc.size, tc.size)
+				}
+				if got := color.RGBAModel.Convert(img.At(1, 1)); got != tc.expected(x, y) {
+					t.Errorf("converted tile %d (%d, %d) => %v, want %v", tc.size, x, y, got, tc.expected(x, y))
+				}
+			}
+		}
+	}
+
+	//Levels not available in the source are built tile by tile
+	small, err := NewTileSizeConverter(&renderer{memTiles: newMemTiles("png", 256)}, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw, err := small.(MetatileReader).GetRawMetatile(TileBlock{Level: 0, Xmin: 0, Xmax: 0, Ymin: 0, Ymax: 0}); raw != nil || err != nil {
+		t.Errorf("GetRawMetatile() at level 0 => %v, %v, want nil", raw, err)
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"fmt"
 	"sort"
 	"sync"
+
+	"github.com/xeonx/geographic"
 )
 
 //FindDriverName returns the name of a driver able to import the given data source
@@ -155,3 +157,9 @@ type TileReadWriter interface {
 	//Clear removes all stored tiles at a given level.
 	Clear(level int) error
 }
+
+//Bounded is the interface implemented by a TileReader knowing the area covered by its tiles.
+type Bounded interface {
+	//Bounds returns the area covered by the tiles
+	Bounds() (geographic.BoundingBox, error)
+}